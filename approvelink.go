@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/hmac"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Approval links let an admin approve from a notification without holding
+// the admin secret. Both are set from APPROVE_LINK_SECRET and
+// APPROVE_LINK_BASE_URL, links are off without a secret.
+var (
+	approveLinkSecret  string
+	approveLinkBaseURL string
+)
+
+// approveLinkAdmin is the admin recorded for approvals made through a link
+const approveLinkAdmin = "approve-link"
+
+// approveLinkPage asks the admin to confirm before a link approves anything.
+// Mail scanners and chat unfurlers fetch links on their own, so opening one
+// only shows this page and the form POSTs the token back.
+var approveLinkPage = template.Must(template.New("approve-link").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Approve key request</title>
+</head>
+<body>
+<h1>Approve key request</h1>
+<p>Server: {{.ServerID}}</p>
+<p>IP: {{.IP}}</p>
+{{- if .Reason}}
+<p>Reason: {{.Reason}}</p>
+{{- end}}
+<form method="post" action="approve-link">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Approve</button>
+</form>
+</body>
+</html>
+`))
+
+// approveLinkToken returns the token approving reqID until expires:
+// <req_id>.<unix expiry>.<hex HMAC-SHA256 of the two>
+func approveLinkToken(reqID string, expires time.Time) string {
+	payload := reqID + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + signBody(approveLinkSecret, []byte(payload))
+}
+
+// approveLink returns the link approving req, valid until the request
+// expires, or "" when approval links are off
+func approveLink(req *Request) string {
+	if approveLinkSecret == "" {
+		return ""
+	}
+	return approveLinkBaseURL + "/admin/approve-link?token=" + url.QueryEscape(approveLinkToken(req.ID, req.ExpiresAt))
+}
+
+// parseApproveLinkToken verifies token and returns the request it approves
+// and when it expires
+func parseApproveLinkToken(token string) (string, time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(signBody(approveLinkSecret, []byte(payload)))) {
+		return "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[0], time.Unix(unix, 0), true
+}
+
+// verifyApproveLink checks token and returns the request it approves,
+// responding with the error otherwise
+func verifyApproveLink(c *gin.Context, token string) (string, bool) {
+	if approveLinkSecret == "" {
+		respondError(c, http.StatusNotFound, errCodeNotFound, "Approval links are not enabled.")
+		return "", false
+	}
+	reqID, expires, ok := parseApproveLinkToken(token)
+	if !ok {
+		respondError(c, http.StatusForbidden, errCodeInvalidLink, "Invalid approval link.")
+		return "", false
+	}
+	if time.Now().After(expires) {
+		respondError(c, http.StatusGone, errCodeLinkExpired, "Approval link has expired.")
+		return "", false
+	}
+	return reqID, true
+}
+
+// useApproveLink marks the link of reqID used on the stored request, so the
+// link works once across every instance sharing the store. It reports false
+// if the link was used before; a request that is gone is left to
+// approveRequest to report.
+func useApproveLink(reqID string) (bool, error) {
+	requestLocks.Lock(reqID)
+	defer requestLocks.Unlock(reqID)
+	req, exists, err := store.Get(reqID)
+	if err != nil || !exists {
+		return true, err
+	}
+	if req.ApproveLinkUsed {
+		return false, nil
+	}
+	req.ApproveLinkUsed = true
+	return true, store.Save(req)
+}
+
+// handleApproveLinkPage shows the confirmation page of a signed link, it
+// approves nothing
+func handleApproveLinkPage(c *gin.Context) {
+	token := c.Query("token")
+	reqID, ok := verifyApproveLink(c, token)
+	if !ok {
+		return
+	}
+	requestLocks.RLock(reqID)
+	req, exists, err := store.Get(reqID)
+	requestLocks.RUnlock(reqID)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		respondError(c, http.StatusNotFound, errCodeRequestNotFound, "Request not found")
+		return
+	}
+	if req.ApproveLinkUsed {
+		respondError(c, http.StatusConflict, errCodeLinkUsed, "Approval link has already been used.")
+		return
+	}
+
+	// The token is in the URL, keep it out of caches and referrers
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	data := struct{ ServerID, IP, Reason, Token string }{req.ServerID, req.IP, req.Reason, token}
+	if err := approveLinkPage.Execute(c.Writer, data); err != nil {
+		slog.Error("rendering approval link page failed", "error", err)
+	}
+}
+
+// handleApproveLink approves the request named by a signed link, posted by
+// the confirmation page. It is not behind the admin authentication, the
+// signature is the credential, and each link works once.
+func handleApproveLink(c *gin.Context) {
+	reqID, ok := verifyApproveLink(c, c.PostForm("token"))
+	if !ok {
+		return
+	}
+	unused, err := useApproveLink(reqID)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !unused {
+		respondError(c, http.StatusConflict, errCodeLinkUsed, "Approval link has already been used.")
+		return
+	}
+
+	span := startSpan(c, spanApprove)
+	defer endSpan(c, span)
+	c.Set(adminContextKey, approveLinkAdmin)
+	status, code, message := approveRequest(c, span, reqID, approveLinkAdmin, time.Time{}, "")
+	respondDecision(c, status, code, message)
+}
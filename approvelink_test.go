@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// enableApproveLinks turns approval links on for the test
+func enableApproveLinks(t *testing.T) {
+	t.Helper()
+	originalSecret, originalBase := approveLinkSecret, approveLinkBaseURL
+	approveLinkSecret, approveLinkBaseURL = "approve-link-secret-0123", "https://szlaban.example.com"
+	t.Cleanup(func() { approveLinkSecret, approveLinkBaseURL = originalSecret, originalBase })
+}
+
+// followApproveLink opens the approval link with token, without credentials
+func followApproveLink(router http.Handler, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve-link?token="+url.QueryEscape(token), nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// confirmApproveLink submits the confirmation form of the approval link
+func confirmApproveLink(router http.Handler, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	form := url.Values{"token": {token}}
+	req, _ := http.NewRequest("POST", "/admin/approve-link", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestApproveLink(t *testing.T) {
+	enableApproveLinks(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	token := approveLinkToken(reqID, getTestRequest(t, reqID).ExpiresAt)
+
+	// Opening the link, as a link scanner would, only shows the confirmation
+	w := followApproveLink(router, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), `<form method="post" action="approve-link">`)
+	assert.Contains(t, w.Body.String(), "test-server")
+	assert.False(t, getTestRequest(t, reqID).Approved)
+
+	w = confirmApproveLink(router, token)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, getTestRequest(t, reqID).Approved)
+	assert.Equal(t, []string{approveLinkAdmin}, getTestRequest(t, reqID).ApprovedBy)
+	assert.True(t, getTestRequest(t, reqID).ApproveLinkUsed, "the use is recorded in the store, for every instance")
+
+	w = confirmApproveLink(router, token)
+	assert.Equal(t, http.StatusConflict, w.Code, "a link works once")
+	assert.Equal(t, http.StatusConflict, followApproveLink(router, token).Code)
+}
+
+func TestApproveLinkEscapesPage(t *testing.T) {
+	isolatePendingRequests(t)
+	enableApproveLinks(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, reqID, func(req *Request) { req.Reason = "<script>alert(1)</script>" })
+	w := followApproveLink(router, approveLinkToken(reqID, getTestRequest(t, reqID).ExpiresAt))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "<script>")
+	assert.Contains(t, w.Body.String(), "&lt;script&gt;")
+}
+
+func TestApproveLinkRejected(t *testing.T) {
+	enableApproveLinks(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	expired := approveLinkToken(reqID, time.Now().Add(-time.Second))
+	assert.Equal(t, http.StatusGone, followApproveLink(router, expired).Code)
+	assert.Equal(t, http.StatusGone, confirmApproveLink(router, expired).Code)
+
+	// Another request's signature does not carry over
+	other := createTestRequest(t, router, "test-server")
+	expires := time.Now().Add(time.Hour)
+	signature := approveLinkToken(other, expires)[len(other)+1:]
+	assert.Equal(t, http.StatusForbidden, followApproveLink(router, reqID+"."+signature).Code)
+	assert.Equal(t, http.StatusForbidden, followApproveLink(router, "").Code)
+
+	otherSecret := approveLinkSecret
+	approveLinkSecret = "another-link-secret-0123"
+	forged := approveLinkToken(reqID, time.Now().Add(time.Hour))
+	approveLinkSecret = otherSecret
+	assert.Equal(t, http.StatusForbidden, followApproveLink(router, forged).Code)
+	assert.Equal(t, http.StatusForbidden, confirmApproveLink(router, forged).Code)
+
+	assert.False(t, getTestRequest(t, reqID).Approved)
+}
+
+func TestApproveLinkDisabled(t *testing.T) {
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+	assert.Equal(t, http.StatusNotFound, followApproveLink(router, reqID+".1.00").Code)
+	assert.Equal(t, http.StatusNotFound, confirmApproveLink(router, reqID+".1.00").Code)
+	assert.NotContains(t, notificationText(getTestRequest(t, reqID)), "approve-link")
+}
+
+func TestApproveLinkInNotification(t *testing.T) {
+	enableApproveLinks(t)
+	req := &Request{ID: "550e8400-e29b-41d4-a716-446655440000", ServerID: "darkstar", ExpiresAt: time.Now().Add(time.Minute)}
+	assert.Contains(t, notificationText(req), "Approve now: https://szlaban.example.com/admin/approve-link?token="+url.QueryEscape(approveLinkToken(req.ID, req.ExpiresAt)))
+}
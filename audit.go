@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audited events
+const (
+	auditRequestCreated   = "request_created"
+	auditApprovalRecorded = "approval_recorded"
+	auditRequestApproved  = "request_approved"
+	auditRequestDenied    = "request_denied"
+	auditKeyReleased      = "key_released"
+	auditRequestExpired   = "request_expired"
+	auditRequestEvicted   = "request_evicted"
+	auditRequestRevoked   = "request_revoked"
+	auditRequestPurged    = "request_purged"
+	auditRequestExtended  = "request_extended"
+	auditKeyRotated       = "key_rotated"
+	// Approved by the AUTO_APPROVE_SERVERS policy rather than an admin
+	auditRequestAutoApproved = "request_auto_approved"
+)
+
+// AuditLogger records security-relevant events. Implementations must be safe
+// for concurrent use.
+type AuditLogger interface {
+	Log(event auditEvent) error
+}
+
+// auditEvent is a single audit log entry
+type auditEvent struct {
+	Time           time.Time `json:"time"`
+	Event          string    `json:"event"`
+	RequestID      string    `json:"request_id"`
+	ServerID       string    `json:"server_id"`
+	IP             string    `json:"ip"`                 // IP of the server that requested the key
+	Admin          string    `json:"admin,omitempty"`    // X-Admin-Id of the acting admin
+	AdminIP        string    `json:"admin_ip,omitempty"` // IP the admin action came from
+	ReasonCode     string    `json:"reason_code,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	TLSVersion     string    `json:"tls_version,omitempty"`
+	TLSCipherSuite string    `json:"tls_cipher_suite,omitempty"`
+	ClientCertCN   string    `json:"client_cert_cn,omitempty"`
+}
+
+// auditLog receives every audit event, stdout unless AUDIT_LOG_PATH is set
+var auditLog AuditLogger = newJSONAuditLogger(os.Stdout)
+
+// jsonAuditLogger writes one JSON object per line
+type jsonAuditLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newJSONAuditLogger(w io.Writer) *jsonAuditLogger {
+	return &jsonAuditLogger{w: w}
+}
+
+// openAuditLogFile appends audit events to the file at path, creating it if needed
+func openAuditLogFile(path string) (*jsonAuditLogger, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening AUDIT_LOG_PATH: %v", err)
+	}
+	return newJSONAuditLogger(f), nil
+}
+
+func (l *jsonAuditLogger) Log(event auditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
+
+// audit records event for req. admin is the admin call that acted on it, nil
+// for server calls and background cleanup. Callers hold the request's lock so
+// its entries are written in the order the changes were made. Lifecycle events
+// are also pushed to /admin/stream, and those ending a request kept in
+// /admin/history.
+func audit(event string, req *Request, admin *gin.Context) {
+	entry := auditEvent{
+		Time:           time.Now().UTC(),
+		Event:          event,
+		RequestID:      req.ID,
+		ServerID:       req.ServerID,
+		IP:             req.IP,
+		TLSVersion:     req.TLSVersion,
+		TLSCipherSuite: req.TLSCipherSuite,
+		ClientCertCN:   req.ClientCertCN,
+	}
+	switch event {
+	case auditRequestCreated:
+		entry.Reason = req.Reason
+	case auditRequestDenied:
+		entry.ReasonCode = req.DenyReasonCode
+		entry.Reason = req.DenyReason
+	}
+	if admin != nil {
+		entry.Admin = adminID(admin)
+		entry.AdminIP = admin.ClientIP()
+	}
+	if event == auditRequestAutoApproved {
+		entry.Admin = autoApproveAdmin
+	}
+	if err := auditLog.Log(entry); err != nil {
+		slog.Error("audit log write failed", "request_id", req.ID, "error", err)
+	}
+	publishRequestEvent(event, req)
+	recordHistory(event, req, admin)
+}
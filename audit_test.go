@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureAudit redirects the audit log into a buffer for the duration of the test
+func captureAudit(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	original := auditLog
+	auditLog = newJSONAuditLogger(&buf)
+	t.Cleanup(func() { auditLog = original })
+	return &buf
+}
+
+// auditEvents decodes the captured JSON lines
+func auditEvents(t *testing.T, buf *bytes.Buffer) []auditEvent {
+	t.Helper()
+	var events []auditEvent
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var event auditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), scanner.Text())
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAuditLogEvents(t *testing.T) {
+	isolatePendingRequests(t)
+	buf := captureAudit(t)
+	router := setupRouter()
+
+	adminCall := func(path string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		req.Header.Set(adminIDHeader, "alice")
+		req.RemoteAddr = "10.0.0.9:4000"
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	approvedID := createTestRequest(t, router, "test-server")
+	adminCall("/admin/approve/" + approvedID)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"req_id": approvedID})
+	req, _ := http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	deniedID := createTestRequest(t, router, "other-server")
+	adminCall("/admin/deny/" + deniedID + "?reason_code=security_hold")
+
+	expiredID := createTestRequest(t, router, "old-server")
+	updateTestRequest(t, expiredID, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	cleanupExpiredRequests()
+
+	events := auditEvents(t, buf)
+	kinds := make([]string, len(events))
+	for i, event := range events {
+		kinds[i] = event.Event
+		assert.False(t, event.Time.IsZero())
+	}
+	require.Equal(t, []string{
+		auditRequestCreated, auditRequestApproved, auditKeyReleased,
+		auditRequestCreated, auditRequestDenied,
+		auditRequestCreated, auditRequestExpired,
+	}, kinds)
+
+	created, approved, released := events[0], events[1], events[2]
+	assert.Equal(t, approvedID, created.RequestID)
+	assert.Equal(t, "test-server", created.ServerID)
+	assert.Empty(t, created.Admin, "server calls carry no admin")
+
+	assert.Equal(t, approvedID, approved.RequestID)
+	assert.Equal(t, "alice", approved.Admin)
+	assert.Equal(t, "10.0.0.9", approved.AdminIP)
+	assert.Equal(t, approvedID, released.RequestID)
+
+	denied := events[4]
+	assert.Equal(t, deniedID, denied.RequestID)
+	assert.Equal(t, "other-server", denied.ServerID)
+	assert.Equal(t, "alice", denied.Admin)
+	assert.Equal(t, "security_hold", denied.ReasonCode)
+
+	expired := events[6]
+	assert.Equal(t, expiredID, expired.RequestID)
+	assert.Empty(t, expired.Admin)
+}
+
+func TestAuditLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := openAuditLogFile(path)
+	require.NoError(t, err)
+
+	req := &Request{ID: "id-1", ServerID: "darkstar", IP: "10.0.0.1", TLSVersion: "TLS 1.3"}
+	original := auditLog
+	auditLog = logger
+	defer func() { auditLog = original }()
+	audit(auditRequestCreated, req, nil)
+	audit(auditKeyReleased, req, nil)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	events := auditEvents(t, bytes.NewBuffer(data))
+	require.Len(t, events, 2)
+	assert.Equal(t, auditKeyReleased, events[1].Event)
+	assert.Equal(t, "TLS 1.3", events[1].TLSVersion)
+
+	_, err = openAuditLogFile(filepath.Join(t.TempDir(), "missing", "audit.log"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package main
+
+import "net"
+
+// autoApproveAdmin is recorded as the approver of requests approved by policy
+const autoApproveAdmin = "auto-approve"
+
+// autoApproveServers lists the server_ids whose requests are approved without
+// an admin, set from AUTO_APPROVE_SERVERS. Empty disables auto-approval.
+var autoApproveServers map[string]bool
+
+// autoApproveNetworks additionally limits auto-approval to requests from these
+// networks, set from AUTO_APPROVE_NETWORKS. Empty accepts any address.
+var autoApproveNetworks []*net.IPNet
+
+// autoApproves reports whether the policy approves req without an admin: its
+// server is listed and, when networks are configured, it came from one
+func autoApproves(req *Request) bool {
+	if !autoApproveServers[req.ServerID] {
+		return false
+	}
+	if len(autoApproveNetworks) == 0 {
+		return true
+	}
+	ip := net.ParseIP(req.IP)
+	return ip != nil && ipAllowed(autoApproveNetworks, ip)
+}
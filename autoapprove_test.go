@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableAutoApprove sets the auto-approval policy for the test
+func enableAutoApprove(t *testing.T, servers, networks string) {
+	t.Helper()
+	originalServers, originalNetworks := autoApproveServers, autoApproveNetworks
+	var err error
+	autoApproveServers, err = parseServerIDList("AUTO_APPROVE_SERVERS", servers)
+	require.NoError(t, err)
+	autoApproveNetworks, err = parseCIDRList("AUTO_APPROVE_NETWORKS", networks)
+	require.NoError(t, err)
+	t.Cleanup(func() { autoApproveServers, autoApproveNetworks = originalServers, originalNetworks })
+}
+
+func TestAutoApprove(t *testing.T) {
+	isolatePendingRequests(t)
+	enableAutoApprove(t, "test-server", "")
+	buf := captureAudit(t)
+	normal := newRecordingNotifier()
+	setNotifiers(t, normal, nil)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	code, response := fetchTestKey(t, router, reqID)
+	require.Equal(t, http.StatusOK, code, "an auto-approved server gets the key immediately")
+	assert.Equal(t, "test-decryption-key", response["key"])
+	expectNotNotified(t, normal)
+
+	var events []string
+	for _, event := range auditEvents(t, buf) {
+		events = append(events, event.Event)
+		if event.Event == auditRequestAutoApproved {
+			assert.Equal(t, autoApproveAdmin, event.Admin)
+		}
+	}
+	assert.Equal(t, []string{auditRequestCreated, auditRequestAutoApproved, auditKeyReleased}, events)
+
+	manual := createTestRequest(t, router, "other-server")
+	code, _ = fetchTestKey(t, router, manual)
+	assert.Equal(t, http.StatusForbidden, code, "other servers still need an admin")
+	assert.False(t, getTestRequest(t, manual).Approved)
+	expectNotified(t, normal, "other-server")
+}
+
+func TestAutoApproveNetworks(t *testing.T) {
+	enableAutoApprove(t, "test-server", "10.0.0.0/8")
+
+	assert.True(t, autoApproves(&Request{ServerID: "test-server", IP: "10.1.2.3"}))
+	assert.False(t, autoApproves(&Request{ServerID: "test-server", IP: "192.168.1.1"}), "listed server from another network")
+	assert.False(t, autoApproves(&Request{ServerID: "other-server", IP: "10.1.2.3"}), "unlisted server from the network")
+}
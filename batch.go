@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultMaxBatchSize is used when MAX_BATCH_SIZE is not set
+const defaultMaxBatchSize = 10
+
+// maxBatchSize is the most server_ids one request-keys call may ask keys for
+var maxBatchSize = defaultMaxBatchSize
+
+// batchItem is one request of a batch in the request-keys response
+type batchItem struct {
+	RequestID string `json:"request_id"`
+	ServerID  string `json:"server_id"`
+	ShortCode string `json:"approval_code,omitempty"`
+	Approved  bool   `json:"approved,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"` // an already pending request, with DEDUP_REQUESTS
+}
+
+// handleServerRequestKeys creates one request per server_id in a single call,
+// for a server bringing up several services. Each request is approved and
+// fetched on its own like those of request-key; they share a batch_id admins
+// may approve or deny them by as a group. The batch is stored all or nothing.
+func handleServerRequestKeys(c *gin.Context) {
+	span := startSpan(c, spanRequestKey)
+	defer endSpan(c, span)
+
+	var json struct {
+		ServerIDs []string `json:"server_ids" binding:"required"`
+		keyRequestOptions
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if len(json.ServerIDs) == 0 || len(json.ServerIDs) > maxBatchSize {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("server_ids must list 1 to %d servers", maxBatchSize))
+		return
+	}
+	seen := make(map[string]bool, len(json.ServerIDs))
+	for _, serverID := range json.ServerIDs {
+		if !checkServerID(c, serverID) {
+			return
+		}
+		if seen[serverID] {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Duplicate server_id %s", serverID))
+			return
+		}
+		seen[serverID] = true
+	}
+	if !allowKeyRequest(c, json.ServerIDs...) {
+		return
+	}
+	template, ok := newKeyRequest(c, json.keyRequestOptions)
+	if !ok {
+		return
+	}
+	batchID := uuid.NewString()
+	span.SetAttributes(attribute.String("szlaban.batch_id", batchID))
+
+	// Every lock, the checks and the saves cover the whole batch
+	requestLocks.LockAll()
+	requests, err := store.List()
+	if err != nil {
+		requestLocks.UnlockAll()
+		respondStoreError(c, err)
+		return
+	}
+	items := make([]batchItem, 0, len(json.ServerIDs))
+	var created []*Request
+	for _, serverID := range json.ServerIDs {
+		request := *template
+		request.ServerID = serverID
+		request.BatchID = batchID
+		if duplicate := findPendingDuplicate(requests, &request); dedupRequests && duplicate != nil {
+			items = append(items, batchItem{RequestID: duplicate.ID, ServerID: serverID, ShortCode: duplicate.ShortCode, Duplicate: true})
+			continue
+		}
+		applyAutoApproval(&request)
+
+		reqID, _, ok, err := generateRequestID(lockHeld)
+		if err != nil {
+			requestLocks.UnlockAll()
+			respondStoreError(c, err)
+			return
+		}
+		if !ok {
+			requestLocks.UnlockAll()
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Could not allocate a request ID")
+			return
+		}
+		request.ID = reqID
+		setRequestTrace(&request, span)
+		// Codes must also differ from those just given to the rest of the batch
+		if shortCodes && !assignShortCode(append(requests, created...), &request) {
+			requestLocks.UnlockAll()
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Could not allocate an approval code")
+			return
+		}
+		created = append(created, &request)
+		items = append(items, batchItem{RequestID: reqID, ServerID: serverID, ShortCode: request.ShortCode, Approved: request.Approved})
+	}
+	if maxPending > 0 && len(created) > 0 && countLivePending(requests)+countLivePending(created) > maxPending {
+		requestLocks.UnlockAll()
+		respondError(c, http.StatusServiceUnavailable, errCodeTooManyPending,
+			fmt.Sprintf("Too many pending requests, the limit is %d. Retry later.", maxPending))
+		return
+	}
+	evicted, ok := planEviction(requests, created)
+	if !ok {
+		requestLocks.UnlockAll()
+		respondNothingToEvict(c)
+		return
+	}
+	for i, request := range created {
+		if err := store.Save(request); err != nil {
+			// Remove the part of the batch already stored
+			for _, saved := range created[:i] {
+				if err := store.Delete(saved.ID); err != nil {
+					slog.Error("removing partially stored batch failed", "request_id", saved.ID, "batch_id", batchID, "error", err)
+				}
+			}
+			requestLocks.UnlockAll()
+			respondStoreError(c, err)
+			return
+		}
+	}
+	for _, request := range created {
+		auditNewRequest(request)
+	}
+	if err := evictRequests(evicted); err != nil {
+		// The requests themselves were stored, eviction is best effort
+		slog.Error("evicting pending requests failed", "error", err)
+	}
+	requestLocks.UnlockAll()
+
+	updatePendingGauge()
+	for _, request := range created {
+		announceNewRequest(request)
+	}
+
+	status := http.StatusAccepted
+	if len(created) == 0 {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"message":               fmt.Sprintf("%d request(s) received, each awaits approval on its own. Requests will expire in %s.", len(created), template.ExpiresAt.Sub(template.CreatedAt)),
+		"batch_id":              batchID,
+		"requests":              items,
+		"created_at":            template.CreatedAt,
+		"expires_at":            template.ExpiresAt,
+		"poll_interval_seconds": int(currentPollInterval().Seconds()),
+	})
+}
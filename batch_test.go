@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestTestBatch posts body to request-keys and returns the response
+func requestTestBatch(router *gin.Engine, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/request-keys", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBatchRequestKeys(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	w := requestTestBatch(router, `{"server_ids":["test-server","web-01","db-01"],"reason":"rack 4 bring-up"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var response struct {
+		BatchID  string      `json:"batch_id"`
+		Requests []batchItem `json:"requests"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotEmpty(t, response.BatchID)
+	require.Len(t, response.Requests, 3)
+
+	ids := map[string]string{}
+	for _, item := range response.Requests {
+		stored := getTestRequest(t, item.RequestID)
+		require.NotNil(t, stored)
+		assert.Equal(t, item.ServerID, stored.ServerID)
+		assert.Equal(t, response.BatchID, stored.BatchID)
+		assert.Equal(t, "rack 4 bring-up", stored.Reason)
+		assert.False(t, stored.Approved)
+		ids[item.ServerID] = item.RequestID
+	}
+	assert.Len(t, ids, 3, "each server gets its own request")
+	unrelated := createTestRequest(t, router, "web-01")
+
+	// The listing can be narrowed to the batch
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/requests?batch_id="+response.BatchID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	var listed []requestSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed, 3)
+
+	// Approving one request of the batch releases only its key
+	adminCall(router, "GET", "/admin/approve/"+ids["test-server"], adminSecretKey)
+	code, key := fetchTestKey(t, router, ids["test-server"])
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test-decryption-key", key["key"])
+	for _, serverID := range []string{"web-01", "db-01"} {
+		code, pending := fetchTestKey(t, router, ids[serverID])
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, "pending", pending["status"])
+	}
+
+	// The rest of the batch is approved as a group, other requests are left alone
+	w = httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]any{"batch_id": response.BatchID, "confirm": true})
+	req, _ = http.NewRequest("POST", "/admin/approve-all", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var approved struct {
+		Approved []string `json:"approved"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &approved))
+	assert.ElementsMatch(t, []string{ids["web-01"], ids["db-01"]}, approved.Approved)
+	assert.True(t, getTestRequest(t, ids["db-01"]).Approved)
+	assert.False(t, getTestRequest(t, unrelated).Approved)
+}
+
+func TestBatchRequestKeysValidation(t *testing.T) {
+	isolatePendingRequests(t)
+	originalSize := maxBatchSize
+	maxBatchSize = 2
+	defer func() { maxBatchSize = originalSize }()
+	router := setupRouter()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty", `{"server_ids":[]}`},
+		{"too many", `{"server_ids":["web-01","web-02","web-03"]}`},
+		{"duplicate", `{"server_ids":["web-01","web-01"]}`},
+		{"invalid server_id", `{"server_ids":["web-01","web 02"]}`},
+		{"invalid ttl", `{"server_ids":["web-01"],"ttl":"soon"}`},
+		{"missing server_ids", `{"reason":"x"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := requestTestBatch(router, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, errCodeBadRequest, responseError(t, w).Code)
+		})
+	}
+
+	requests, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, requests, "a rejected batch stores nothing")
+}
+
+func TestBatchRequestKeysMaxPending(t *testing.T) {
+	isolatePendingRequests(t)
+	originalMax := maxPending
+	maxPending = 2
+	defer func() { maxPending = originalMax }()
+	router := setupRouter()
+
+	createTestRequest(t, router, "test-server")
+	w := requestTestBatch(router, `{"server_ids":["web-01","db-01"]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, errCodeTooManyPending, responseError(t, w).Code)
+
+	requests, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, requests, 1, "the batch is stored all or nothing")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Callback delivery attempts and the delay before the first retry, which
+// doubles on every further attempt
+const callbackAttempts = 3
+
+var callbackBackoff = time.Second
+
+// Headers authenticating a callback: the unix time it was sent and the hex
+// HMAC-SHA256 of "<timestamp>.<body>" under CALLBACK_SECRET
+const (
+	callbackTimestampHeader = "X-Szlaban-Timestamp"
+	callbackSignatureHeader = "X-Szlaban-Signature"
+)
+
+// callbackSecret signs callbacks so servers can trust the decision, set from
+// CALLBACK_SECRET. Empty sends them unsigned.
+var callbackSecret string
+
+// signCallback returns the signature of a callback body sent at timestamp
+func signCallback(secret, timestamp string, body []byte) string {
+	return signBody(secret, append([]byte(timestamp+"."), body...))
+}
+
+// allowPrivateCallbacks permits callback URLs on loopback and private
+// addresses, set from ALLOW_PRIVATE_CALLBACKS
+var allowPrivateCallbacks bool
+
+// errPrivateCallback rejects callbacks to addresses szlaban must not reach
+var errPrivateCallback = errors.New("callback address is loopback, private or link-local")
+
+// isPrivateAddress reports whether ip is loopback, private, link-local or unspecified
+func isPrivateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// validateCallbackURL accepts only absolute https URLs that do not point at
+// localhost or a private address. Host names are checked again when
+// connecting, so DNS cannot later redirect the callback to a private address.
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute URL")
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("callback_url must use https")
+	}
+	if allowPrivateCallbacks {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPrivateCallback
+	}
+	if ip := net.ParseIP(host); ip != nil && isPrivateAddress(ip) {
+		return errPrivateCallback
+	}
+	return nil
+}
+
+// callbackClient delivers callbacks, refusing to connect to private addresses
+// unless ALLOW_PRIVATE_CALLBACKS is set. Its timeout follows OUTBOUND_TIMEOUT.
+var callbackClient = &http.Client{
+	Timeout: defaultOutboundTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); !allowPrivateCallbacks && (ip == nil || isPrivateAddress(ip)) {
+					return errPrivateCallback
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	// Redirects could lead anywhere, the callback URL was validated, not its target
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// callbackPayload is the decision posted to a request's callback URL. It
+// never includes the key, which is only released through get-key.
+type callbackPayload struct {
+	RequestID  string `json:"request_id"`
+	ServerID   string `json:"server_id"`
+	Status     string `json:"status"` // approved, denied or evicted
+	ReasonCode string `json:"reason_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	ReleaseAt  string `json:"release_at,omitempty"`
+}
+
+// sendCallbackAsync posts the decision on req to its callback URL in the
+// background, retrying with backoff. It does nothing without a callback URL.
+func sendCallbackAsync(req *Request) {
+	if req.CallbackURL == "" {
+		return
+	}
+	payload := callbackPayload{RequestID: req.ID, ServerID: req.ServerID, Status: "approved"}
+	if req.Denied {
+		payload.Status = "denied"
+		payload.ReasonCode = req.DenyReasonCode
+		payload.Reason = req.DenyReason
+	} else if !req.ReleaseAt.IsZero() {
+		payload.ReleaseAt = req.ReleaseAt.Format(time.RFC3339)
+	}
+	postCallbackAsync(req.CallbackURL, payload)
+}
+
+// sendEvictedCallbackAsync tells the server of req, if it has a callback URL,
+// that req was evicted undecided and it has to request a key again
+func sendEvictedCallbackAsync(req *Request) {
+	if req.CallbackURL == "" {
+		return
+	}
+	postCallbackAsync(req.CallbackURL, callbackPayload{RequestID: req.ID, ServerID: req.ServerID, Status: "evicted"})
+}
+
+// postCallbackAsync delivers payload to callbackURL in the background
+func postCallbackAsync(callbackURL string, payload callbackPayload) {
+	go func() {
+		ctx, cancel := asyncContext()
+		defer cancel()
+		err := deliverCallback(ctx, callbackURL, payload)
+		if err != nil {
+			slog.Warn("callback failed", "request_id", payload.RequestID, "attempts", callbackAttempts, "error", err)
+			return
+		}
+		slog.Info("callback delivered", "request_id", payload.RequestID, "status", payload.Status)
+	}()
+}
+
+// deliverCallback posts payload to callbackURL until it gets a 2xx response or
+// runs out of attempts
+func deliverCallback(ctx context.Context, callbackURL string, payload callbackPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := callbackBackoff
+	for attempt := 1; ; attempt++ {
+		err = postCallback(ctx, callbackURL, body)
+		if err == nil || attempt == callbackAttempts || errors.Is(err, errPrivateCallback) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func postCallback(ctx context.Context, callbackURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if callbackSecret != "" {
+		// Signed per attempt, so a retry carries a fresh timestamp
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(callbackTimestampHeader, timestamp)
+		req.Header.Set(callbackSignatureHeader, signCallback(callbackSecret, timestamp, body))
+	}
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"szlaban/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{name: "Public", url: "https://callbacks.example.com/szlaban"},
+		{name: "PlainHTTP", url: "http://callbacks.example.com/szlaban", wantErr: true},
+		{name: "Relative", url: "/szlaban", wantErr: true},
+		{name: "Localhost", url: "https://localhost:8443/cb", wantErr: true},
+		{name: "Loopback", url: "https://127.0.0.1/cb", wantErr: true},
+		{name: "LoopbackIPv6", url: "https://[::1]/cb", wantErr: true},
+		{name: "Private", url: "https://10.1.2.3/cb", wantErr: true},
+		{name: "PrivateClassC", url: "https://192.168.0.10/cb", wantErr: true},
+		{name: "CloudMetadata", url: "https://169.254.169.254/latest", wantErr: true},
+		{name: "PrivateAllowed", url: "https://10.1.2.3/cb", allowPrivate: true},
+		{name: "PlainHTTPStillRejected", url: "http://10.1.2.3/cb", allowPrivate: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowPrivateCallbacks = tt.allowPrivate
+			defer func() { allowPrivateCallbacks = false }()
+
+			err := validateCallbackURL(tt.url)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRequestKeyRejectsPrivateCallback(t *testing.T) {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/request-key",
+		bytes.NewBufferString(`{"server_id": "test-server", "callback_url": "https://10.0.0.1/decision"}`))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "callback_url")
+}
+
+func TestCallbackOnApproval(t *testing.T) {
+	isolatePendingRequests(t)
+
+	var attempts atomic.Int32
+	payloads := make(chan string, 1)
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first delivery to exercise the retry
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		payloads <- string(body)
+	}))
+	defer receiver.Close()
+
+	originalClient, originalBackoff := callbackClient, callbackBackoff
+	callbackClient, callbackBackoff, allowPrivateCallbacks = receiver.Client(), 10*time.Millisecond, true
+	defer func() {
+		callbackClient, callbackBackoff, allowPrivateCallbacks = originalClient, originalBackoff, false
+	}()
+
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"server_id": "test-server", "callback_url": receiver.URL + "/decision"})
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	reqID := created["request_id"].(string)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	select {
+	case payload := <-payloads:
+		var decision callbackPayload
+		require.NoError(t, json.Unmarshal([]byte(payload), &decision))
+		assert.Equal(t, reqID, decision.RequestID)
+		assert.Equal(t, "approved", decision.Status)
+		assert.NotContains(t, payload, "test-decryption-key", "the key is never sent to the callback")
+		assert.Equal(t, int32(2), attempts.Load())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback received")
+	}
+}
+
+func TestCallbackRefusesPrivateAddressOnConnect(t *testing.T) {
+	var called atomic.Bool
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}))
+	defer receiver.Close()
+
+	// The default client checks the resolved address, not just the URL
+	err := deliverCallback(context.Background(), receiver.URL, callbackPayload{RequestID: "id"})
+	assert.ErrorIs(t, err, errPrivateCallback)
+	assert.False(t, called.Load())
+}
+
+func TestCallbackSignature(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan delivery, 1)
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{r.Header.Clone(), body}
+	}))
+	defer receiver.Close()
+
+	originalClient, originalSecret := callbackClient, callbackSecret
+	callbackClient, callbackSecret, allowPrivateCallbacks = receiver.Client(), "callback-secret-0123", true
+	defer func() {
+		callbackClient, callbackSecret, allowPrivateCallbacks = originalClient, originalSecret, false
+	}()
+
+	require.NoError(t, deliverCallback(context.Background(), receiver.URL, callbackPayload{RequestID: "id", ServerID: "darkstar", Status: "approved"}))
+	got := <-deliveries
+	assert.NotEmpty(t, got.header.Get(callbackSignatureHeader))
+	assert.Equal(t, callbackSignatureHeader, client.CallbackSignatureHeader)
+	assert.Equal(t, callbackTimestampHeader, client.CallbackTimestampHeader)
+
+	assert.NoError(t, client.VerifyCallback("callback-secret-0123", got.header, got.body, 0), "a valid payload verifies")
+	var decision client.Decision
+	require.NoError(t, json.Unmarshal(got.body, &decision))
+	assert.Equal(t, "approved", decision.Status)
+
+	tampered := bytes.Replace(got.body, []byte("approved"), []byte("denied"), 1)
+	assert.ErrorIs(t, client.VerifyCallback("callback-secret-0123", got.header, tampered, 0), client.ErrInvalidSignature)
+	assert.ErrorIs(t, client.VerifyCallback("another-secret-0123", got.header, got.body, 0), client.ErrInvalidSignature)
+	assert.ErrorIs(t, client.VerifyCallback("callback-secret-0123", http.Header{}, got.body, 0), client.ErrInvalidSignature)
+
+	// A replayed callback signed long ago is refused
+	stale := http.Header{}
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	stale.Set(client.CallbackTimestampHeader, timestamp)
+	stale.Set(client.CallbackSignatureHeader, signCallback("callback-secret-0123", timestamp, got.body))
+	assert.ErrorIs(t, client.VerifyCallback("callback-secret-0123", stale, got.body, 0), client.ErrStaleCallback)
+}
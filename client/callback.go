@@ -0,0 +1,69 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers szlaban signs decision callbacks with when CALLBACK_SECRET is set
+const (
+	CallbackTimestampHeader = "X-Szlaban-Timestamp"
+	CallbackSignatureHeader = "X-Szlaban-Signature"
+)
+
+// DefaultCallbackMaxAge is how old a callback VerifyCallback accepts by default
+const DefaultCallbackMaxAge = 5 * time.Minute
+
+var (
+	// ErrInvalidSignature means a callback is unsigned or was not signed with
+	// the secret, or its body was altered
+	ErrInvalidSignature = errors.New("szlaban: invalid callback signature")
+	// ErrStaleCallback means a validly signed callback is too old, or from the
+	// future, and may be a replay
+	ErrStaleCallback = errors.New("szlaban: callback timestamp outside the allowed window")
+)
+
+// Decision is the body of a callback, posted once a request is decided
+type Decision struct {
+	RequestID  string `json:"request_id"`
+	ServerID   string `json:"server_id"`
+	Status     string `json:"status"` // approved, denied or evicted
+	ReasonCode string `json:"reason_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	ReleaseAt  string `json:"release_at,omitempty"`
+}
+
+// VerifyCallback checks that a callback with header and the raw body was
+// signed with secret, CALLBACK_SECRET of szlaban, at most maxAge ago (zero
+// uses DefaultCallbackMaxAge). Verify before decoding the body. Within maxAge
+// a captured callback can be replayed, so act on each request_id once.
+func VerifyCallback(secret string, header http.Header, body []byte, maxAge time.Duration) error {
+	timestamp := header.Get(CallbackTimestampHeader)
+	signature, err := hex.DecodeString(header.Get(CallbackSignatureHeader))
+	if timestamp == "" || err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if maxAge == 0 {
+		maxAge = DefaultCallbackMaxAge
+	}
+	if age := time.Since(time.Unix(sent, 0)); age > maxAge || age < -maxAge {
+		return ErrStaleCallback
+	}
+	return nil
+}
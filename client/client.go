@@ -0,0 +1,197 @@
+// Package client calls a szlaban server: servers request and fetch their key,
+// admins approve or deny the requests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned for the outcomes a caller acts on, match them with errors.Is
+var (
+	// ErrNotApproved means the request is still pending, or approved with a
+	// release time in the future: poll again later
+	ErrNotApproved = errors.New("szlaban: request not approved yet")
+	// ErrDenied means an admin denied the request
+	ErrDenied = errors.New("szlaban: request denied")
+	// ErrExpired means the request expired before it was decided or fetched
+	ErrExpired = errors.New("szlaban: request expired")
+	// ErrConsumed means the key was already fetched and may only be fetched once
+	ErrConsumed = errors.New("szlaban: key already fetched")
+	// ErrNotFound means szlaban knows no such request, or no key for the server
+	ErrNotFound = errors.New("szlaban: not found")
+	// ErrUnauthorized means the server or admin key was rejected
+	ErrUnauthorized = errors.New("szlaban: unauthorized")
+	// ErrInvalidRequestID means a request ID is not a UUID, nothing was sent
+	ErrInvalidRequestID = errors.New("szlaban: invalid request ID")
+)
+
+// StatusError is returned for any other unsuccessful response. Code is the
+// error code of the response, empty if it had none.
+type StatusError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("szlaban: %d %s: %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Code, e.Message)
+	}
+	return fmt.Sprintf("szlaban: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client calls the szlaban server at BaseURL. Servers need ServerKey, admins
+// AdminKey; a client may hold both.
+type Client struct {
+	BaseURL    string       // e.g. https://szlaban.example.com
+	ServerKey  string       // SERVER_SECRET_KEY, for RequestKey and GetKey
+	AdminKey   string       // an admin key, for Approve and Deny
+	HTTPClient *http.Client // nil uses http.DefaultClient
+}
+
+// RequestKey asks for the key of serverID and returns the ID of the request,
+// to be approved by an admin before GetKey releases the key
+func (c *Client) RequestKey(ctx context.Context, serverID string) (string, error) {
+	var response struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/server/request-key", c.ServerKey, map[string]string{"server_id": serverID}, &response); err != nil {
+		return "", err
+	}
+	if _, err := uuid.Parse(response.RequestID); err != nil {
+		return "", fmt.Errorf("%w in response: %q", ErrInvalidRequestID, response.RequestID)
+	}
+	return response.RequestID, nil
+}
+
+// GetKey returns the key once reqID is approved. Until then it fails with
+// ErrNotApproved, and with ErrDenied, ErrExpired, ErrConsumed or ErrNotFound
+// when the key will never be released.
+func (c *Client) GetKey(ctx context.Context, reqID string) (string, error) {
+	if _, err := uuid.Parse(reqID); err != nil {
+		return "", ErrInvalidRequestID
+	}
+	var response struct {
+		Key string `json:"key"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/server/get-key", c.ServerKey, map[string]string{"req_id": reqID}, &response); err != nil {
+		return "", err
+	}
+	return response.Key, nil
+}
+
+// Approve approves reqID. With REQUIRED_APPROVALS above one it records this
+// admin's approval and succeeds before the request is fully approved.
+func (c *Client) Approve(ctx context.Context, reqID string) error {
+	return c.decide(ctx, "/admin/approve/", reqID)
+}
+
+// Deny denies reqID
+func (c *Client) Deny(ctx context.Context, reqID string) error {
+	return c.decide(ctx, "/admin/deny/", reqID)
+}
+
+func (c *Client) decide(ctx context.Context, path, reqID string) error {
+	if _, err := uuid.Parse(reqID); err != nil {
+		return ErrInvalidRequestID
+	}
+	return c.call(ctx, http.MethodGet, path+url.PathEscape(reqID), c.AdminKey, nil, nil)
+}
+
+// call sends body as JSON, if not nil, with the bearer key and decodes a
+// successful JSON response into out, if not nil
+func (c *Client) call(ctx context.Context, method, path, key string, body, out any) error {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return responseError(resp.StatusCode, data)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("szlaban: decoding response: %w", err)
+	}
+	return nil
+}
+
+// codeErrors maps the error codes of the server to the errors callers act on
+var codeErrors = map[string]error{
+	"unauthorized":       ErrUnauthorized,
+	"request_not_found":  ErrNotFound,
+	"key_not_configured": ErrNotFound,
+	"request_denied":     ErrDenied,
+	"not_approved":       ErrNotApproved,
+	"not_yet_released":   ErrNotApproved,
+	"key_consumed":       ErrConsumed,
+	"request_expired":    ErrExpired,
+}
+
+// responseError maps an unsuccessful response to the matching error by the
+// code of its {"error": {"code", "message"}} body. Bodies without one, such as
+// those of a proxy in front of szlaban, are mapped by status code.
+func responseError(statusCode int, data []byte) error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) != nil || body.Error.Code == "" {
+		body.Error.Message = strings.TrimSpace(string(data))
+	}
+
+	err, ok := codeErrors[body.Error.Code]
+	switch {
+	case ok:
+	case body.Error.Code == "" && statusCode == http.StatusUnauthorized:
+		err = ErrUnauthorized
+	case body.Error.Code == "" && statusCode == http.StatusNotFound:
+		err = ErrNotFound
+	default:
+		return &StatusError{StatusCode: statusCode, Code: body.Error.Code, Message: body.Error.Message}
+	}
+	if body.Error.Message == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, body.Error.Message)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"szlaban/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient serves the router in-process and returns a client with the
+// server and admin keys
+func newTestClient(t *testing.T) *client.Client {
+	t.Helper()
+	originalServerKey := serverSecretKey
+	serverSecretKey = "test-server-key-0123"
+	t.Cleanup(func() { serverSecretKey = originalServerKey })
+	server := httptest.NewServer(setupRouter())
+	t.Cleanup(server.Close)
+	return &client.Client{BaseURL: server.URL, ServerKey: serverSecretKey, AdminKey: adminSecretKey}
+}
+
+func TestClientApproveFlow(t *testing.T) {
+	isolatePendingRequests(t)
+	sdk := newTestClient(t)
+	ctx := context.Background()
+
+	reqID, err := sdk.RequestKey(ctx, "test-server")
+	require.NoError(t, err)
+
+	_, err = sdk.GetKey(ctx, reqID)
+	assert.ErrorIs(t, err, client.ErrNotApproved)
+
+	require.NoError(t, sdk.Approve(ctx, reqID))
+	key, err := sdk.GetKey(ctx, reqID)
+	require.NoError(t, err)
+	assert.Equal(t, "test-decryption-key", key)
+}
+
+func TestClientErrors(t *testing.T) {
+	isolatePendingRequests(t)
+	sdk := newTestClient(t)
+	ctx := context.Background()
+
+	reqID, err := sdk.RequestKey(ctx, "test-server")
+	require.NoError(t, err)
+	require.NoError(t, sdk.Deny(ctx, reqID))
+	_, err = sdk.GetKey(ctx, reqID)
+	assert.ErrorIs(t, err, client.ErrDenied)
+
+	expired, err := sdk.RequestKey(ctx, "test-server")
+	require.NoError(t, err)
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	_, err = sdk.GetKey(ctx, expired)
+	assert.ErrorIs(t, err, client.ErrExpired)
+	assert.ErrorIs(t, sdk.Approve(ctx, expired), client.ErrExpired)
+
+	_, err = sdk.GetKey(ctx, "00000000-0000-0000-0000-000000000000")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	assert.ErrorIs(t, sdk.Approve(ctx, "00000000-0000-0000-0000-000000000000"), client.ErrNotFound)
+
+	_, err = sdk.GetKey(ctx, "not-a-uuid")
+	assert.ErrorIs(t, err, client.ErrInvalidRequestID)
+	assert.ErrorIs(t, sdk.Deny(ctx, "not-a-uuid"), client.ErrInvalidRequestID)
+
+	unauthorized := *sdk
+	unauthorized.AdminKey = "wrong-admin-key"
+	assert.ErrorIs(t, unauthorized.Approve(ctx, reqID), client.ErrUnauthorized)
+
+	var statusErr *client.StatusError
+	assert.ErrorAs(t, sdk.Approve(ctx, reqID), &statusErr, "approving a denied request conflicts")
+	assert.Equal(t, 409, statusErr.StatusCode)
+	assert.Equal(t, "already_decided", statusErr.Code)
+}
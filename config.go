@@ -0,0 +1,510 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configuration parsed once at startup by loadConfig
+var (
+	approvalTimeout    time.Duration
+	cleanupInterval    = defaultCleanupInterval
+	discoveryHeartbeat = defaultDiscoveryHeartbeat
+	evictionThreshold  int           // soft limit on pending requests for the eviction policy
+	extendDuration     time.Duration // default extension, 0 extends by APPROVAL_TIMEOUT
+	shutdownTimeout    = defaultShutdownTimeout
+	maxBodyBytes       = defaultMaxBodyBytes // largest request body accepted, in bytes
+	maxLongPoll        = defaultMaxLongPoll
+	maxPending         int // hard limit on live pending requests, 0 disables it
+	maxRequestTTL      = defaultMaxRequestTTL
+	maxRequestLifetime = defaultMaxRequestLifetime
+	oneTimeKey         bool // approved requests are removed once their key is fetched
+	requiredApprovals  = 1  // distinct admin approvals needed before a key is released
+	serverKeys         = newKeyStore(map[string]string{})
+	tlsConfig          *tls.Config // nil serves plain HTTP
+)
+
+// defaultMaxRequestTTL is used when MAX_REQUEST_TTL is not set
+const defaultMaxRequestTTL = time.Hour
+
+// defaultMaxRequestLifetime is used when MAX_REQUEST_LIFETIME is not set
+const defaultMaxRequestLifetime = 24 * time.Hour
+
+// defaultMaxBodyBytes is used when MAX_BODY_BYTES is not set
+const defaultMaxBodyBytes int64 = 64 << 10
+
+// minSecretKeyLength is the shortest admin or server secret key accepted
+const minSecretKeyLength = 16
+
+// validateConfig checks the required settings and returns every problem found,
+// so they can all be reported at once before the server starts
+func validateConfig() []error {
+	var errs []error
+	switch adminAuthMode {
+	case "", adminAuthSecret:
+		if len(adminSecretKeys) == 0 {
+			errs = append(errs, fmt.Errorf("ADMIN_SECRET_KEY or ADMIN_SECRET_KEYS is required"))
+		}
+		for i, key := range adminSecretKeys {
+			if len(key) < minSecretKeyLength {
+				errs = append(errs, fmt.Errorf("admin secret key %d is shorter than %d characters", i+1, minSecretKeyLength))
+			}
+		}
+	case adminAuthJWT:
+		if adminJWTPubKey == "" {
+			errs = append(errs, fmt.Errorf("ADMIN_JWT_PUBKEY is required when ADMIN_AUTH_MODE is %s", adminAuthJWT))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid ADMIN_AUTH_MODE %q, must be %s or %s", adminAuthMode, adminAuthSecret, adminAuthJWT))
+	}
+	if serverSecretKey == "" {
+		errs = append(errs, fmt.Errorf("SERVER_SECRET_KEY is required"))
+	} else if len(serverSecretKey) < minSecretKeyLength {
+		errs = append(errs, fmt.Errorf("SERVER_SECRET_KEY is shorter than %d characters", minSecretKeyLength))
+	}
+	if bindAddress == "" && !socketActivated() {
+		errs = append(errs, fmt.Errorf("BIND_ADDRESS is required without socket activation"))
+	}
+	if raw := os.Getenv("APPROVAL_TIMEOUT"); raw == "" {
+		errs = append(errs, fmt.Errorf("APPROVAL_TIMEOUT is required"))
+	} else if timeout, err := time.ParseDuration(raw); err != nil || timeout <= 0 {
+		errs = append(errs, fmt.Errorf("APPROVAL_TIMEOUT %q is not a positive duration", raw))
+	}
+	return errs
+}
+
+// loadConfig parses and validates the environment configuration so that
+// mistakes are reported at startup rather than on the first request
+func loadConfig() error {
+	raw := os.Getenv("APPROVAL_TIMEOUT")
+	if raw == "" {
+		return fmt.Errorf("APPROVAL_TIMEOUT is required")
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid APPROVAL_TIMEOUT %q: %v", raw, err)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("APPROVAL_TIMEOUT must be positive, got %q", raw)
+	}
+	approvalTimeout = timeout
+
+	if raw := os.Getenv("MAX_REQUEST_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid MAX_REQUEST_TTL %q", raw)
+		}
+		maxRequestTTL = ttl
+	}
+
+	if raw := os.Getenv("EXTEND_DURATION"); raw != "" {
+		by, err := time.ParseDuration(raw)
+		if err != nil || by <= 0 {
+			return fmt.Errorf("invalid EXTEND_DURATION %q", raw)
+		}
+		extendDuration = by
+	}
+
+	if raw := os.Getenv("MAX_REQUEST_LIFETIME"); raw != "" {
+		lifetime, err := time.ParseDuration(raw)
+		if err != nil || lifetime <= 0 {
+			return fmt.Errorf("invalid MAX_REQUEST_LIFETIME %q", raw)
+		}
+		maxRequestLifetime = lifetime
+	}
+
+	if raw := os.Getenv("DISCOVERY_HEARTBEAT"); raw != "" {
+		heartbeat, err := time.ParseDuration(raw)
+		if err != nil || heartbeat <= 0 {
+			return fmt.Errorf("invalid DISCOVERY_HEARTBEAT %q", raw)
+		}
+		discoveryHeartbeat = heartbeat
+	}
+
+	if raw := os.Getenv("SHUTDOWN_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q", raw)
+		}
+		shutdownTimeout = timeout
+	}
+
+	if raw := os.Getenv("CLEANUP_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid CLEANUP_INTERVAL %q", raw)
+		}
+		cleanupInterval = interval
+	}
+
+	if raw := os.Getenv("MAX_LONGPOLL"); raw != "" {
+		wait, err := time.ParseDuration(raw)
+		if err != nil || wait < 0 {
+			return fmt.Errorf("invalid MAX_LONGPOLL %q", raw)
+		}
+		maxLongPoll = wait
+	}
+
+	if raw := os.Getenv("MAX_BATCH_SIZE"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid MAX_BATCH_SIZE %q", raw)
+		}
+		maxBatchSize = size
+	}
+
+	if raw := os.Getenv("MAX_BODY_BYTES"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid MAX_BODY_BYTES %q", raw)
+		}
+		maxBodyBytes = limit
+	}
+
+	if raw := os.Getenv("HISTORY_SIZE"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < 0 {
+			return fmt.Errorf("invalid HISTORY_SIZE %q", raw)
+		}
+		requestHistory = newHistoryBuffer(size)
+	}
+
+	if raw := os.Getenv("REQUIRED_APPROVALS"); raw != "" {
+		approvals, err := strconv.Atoi(raw)
+		if err != nil || approvals <= 0 {
+			return fmt.Errorf("invalid REQUIRED_APPROVALS %q", raw)
+		}
+		// Admin secret keys are shared, X-Admin-Id is whatever the caller sends
+		if approvals > 1 && adminAuthMode != adminAuthJWT {
+			return fmt.Errorf("REQUIRED_APPROVALS above 1 requires ADMIN_AUTH_MODE=%s, shared admin keys cannot tell admins apart", adminAuthJWT)
+		}
+		requiredApprovals = approvals
+	}
+
+	if adminAuthMode == adminAuthJWT {
+		if adminJWTKey, err = loadJWTPublicKey(adminJWTPubKey); err != nil {
+			return err
+		}
+	}
+
+	if tlsConfig, err = loadTLSConfig(tlsCertFile, tlsKeyFile, tlsMinVersion); err != nil {
+		return err
+	}
+
+	if raw := os.Getenv("ENABLE_H2C"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid ENABLE_H2C %q", raw)
+		}
+		if enabled && tlsConfig != nil {
+			return fmt.Errorf("ENABLE_H2C is for plain HTTP, HTTPS negotiates HTTP/2 by itself")
+		}
+		enableH2C = enabled
+	}
+
+	if raw := os.Getenv("HTTP_KEEP_ALIVE"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid HTTP_KEEP_ALIVE %q", raw)
+		}
+		httpKeepAlive = enabled
+	}
+
+	if raw := os.Getenv("READ_HEADER_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid READ_HEADER_TIMEOUT %q", raw)
+		}
+		readHeaderTimeout = timeout
+	}
+
+	if raw := os.Getenv("IDLE_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid IDLE_TIMEOUT %q", raw)
+		}
+		idleTimeout = timeout
+	}
+
+	if readTimeout, err = parseLongPollTimeout("READ_TIMEOUT", os.Getenv("READ_TIMEOUT")); err != nil {
+		return err
+	}
+	if writeTimeout, err = parseLongPollTimeout("WRITE_TIMEOUT", os.Getenv("WRITE_TIMEOUT")); err != nil {
+		return err
+	}
+
+	if raw := os.Getenv("SHORT_CODES"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid SHORT_CODES %q", raw)
+		}
+		shortCodes = enabled
+	}
+
+	if raw := os.Getenv("ONE_TIME_KEY"); raw != "" {
+		oneTime, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid ONE_TIME_KEY %q", raw)
+		}
+		oneTimeKey = oneTime
+	}
+
+	if raw := os.Getenv("DEDUP_REQUESTS"); raw != "" {
+		dedup, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid DEDUP_REQUESTS %q", raw)
+		}
+		dedupRequests = dedup
+	}
+
+	if raw := os.Getenv("HARDEN_ENUMERATION"); raw != "" {
+		harden, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid HARDEN_ENUMERATION %q", raw)
+		}
+		hardenEnumeration = harden
+	}
+
+	if raw := os.Getenv("ENUMERATION_DELAY"); raw != "" {
+		delay, err := time.ParseDuration(raw)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("invalid ENUMERATION_DELAY %q", raw)
+		}
+		enumerationDelay = delay
+	}
+
+	corsAllowedOrigins = parseCORSOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+
+	if knownServerIDs, err = parseKnownServerIDs(os.Getenv("KNOWN_SERVER_IDS")); err != nil {
+		return err
+	}
+
+	if adminScopes, err = loadAdminScopes(os.Getenv("ADMIN_SCOPES_FILE")); err != nil {
+		return err
+	}
+
+	if approveLinkSecret = os.Getenv("APPROVE_LINK_SECRET"); approveLinkSecret != "" {
+		if len(approveLinkSecret) < minSecretKeyLength {
+			return fmt.Errorf("APPROVE_LINK_SECRET is shorter than %d characters", minSecretKeyLength)
+		}
+		approveLinkBaseURL = strings.TrimRight(os.Getenv("APPROVE_LINK_BASE_URL"), "/")
+		if base, err := url.Parse(approveLinkBaseURL); err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" {
+			return fmt.Errorf("APPROVE_LINK_BASE_URL, the public http(s) URL of szlaban, is required with APPROVE_LINK_SECRET")
+		}
+	}
+
+	if raw := os.Getenv("ADMIN_TOTP_SECRET"); raw != "" {
+		if adminTOTPSecret, err = parseTOTPSecret(raw); err != nil {
+			return err
+		}
+		// An approval link is a credential on its own, it would bypass the code
+		if approveLinkSecret != "" {
+			return fmt.Errorf("APPROVE_LINK_SECRET cannot be used with ADMIN_TOTP_SECRET, approval links carry no TOTP code")
+		}
+	}
+
+	if raw := os.Getenv("ADMIN_TOTP_SKIP_DENY"); raw != "" {
+		skip, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid ADMIN_TOTP_SKIP_DENY %q", raw)
+		}
+		totpSkipDeny = skip
+	}
+
+	if autoApproveServers, err = parseServerIDList("AUTO_APPROVE_SERVERS", os.Getenv("AUTO_APPROVE_SERVERS")); err != nil {
+		return err
+	}
+	if autoApproveNetworks, err = parseCIDRList("AUTO_APPROVE_NETWORKS", os.Getenv("AUTO_APPROVE_NETWORKS")); err != nil {
+		return err
+	}
+
+	if serverIPAllowlist, err = parseCIDRList("SERVER_IP_ALLOWLIST", os.Getenv("SERVER_IP_ALLOWLIST")); err != nil {
+		return err
+	}
+	if raw := os.Getenv("TRUSTED_PROXIES"); raw != "" {
+		proxies, err := parseCIDRList("TRUSTED_PROXIES", raw)
+		if err != nil {
+			return err
+		}
+		trustedProxies = []string{}
+		for _, network := range proxies {
+			trustedProxies = append(trustedProxies, network.String())
+		}
+	}
+
+	if callbackSecret = os.Getenv("CALLBACK_SECRET"); callbackSecret != "" && len(callbackSecret) < minSecretKeyLength {
+		return fmt.Errorf("CALLBACK_SECRET is shorter than %d characters", minSecretKeyLength)
+	}
+	if raw := os.Getenv("ALLOW_PRIVATE_CALLBACKS"); raw != "" {
+		allow, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid ALLOW_PRIVATE_CALLBACKS %q", raw)
+		}
+		allowPrivateCallbacks = allow
+	}
+
+	if raw := os.Getenv("REQUEST_RATE"); raw != "" {
+		burst, window, err := parseRate(raw)
+		if err != nil {
+			return fmt.Errorf("invalid REQUEST_RATE: %v", err)
+		}
+		requestLimiter = newRateLimiter(burst, window)
+	}
+
+	if path := os.Getenv("AUDIT_LOG_PATH"); path != "" {
+		logger, err := openAuditLogFile(path)
+		if err != nil {
+			return err
+		}
+		auditLog = logger
+	}
+
+	if raw := os.Getenv("EVICTION_THRESHOLD"); raw != "" {
+		threshold, err := strconv.Atoi(raw)
+		if err != nil || threshold <= 0 {
+			return fmt.Errorf("invalid EVICTION_THRESHOLD %q", raw)
+		}
+		evictionThreshold = threshold
+	}
+	if raw := os.Getenv("MAX_PENDING"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid MAX_PENDING %q", raw)
+		}
+		maxPending = limit
+	}
+	if evictionPolicy != "" && evictionPolicy != evictionPolicyNone && evictionPolicy != evictionPolicyOldest {
+		return fmt.Errorf("invalid EVICTION_POLICY %q, must be %s or %s", evictionPolicy, evictionPolicyNone, evictionPolicyOldest)
+	}
+	if evictionPolicy == evictionPolicyOldest && evictionThreshold <= 0 {
+		return fmt.Errorf("EVICTION_POLICY=%s needs EVICTION_THRESHOLD", evictionPolicyOldest)
+	}
+
+	switch raw := os.Getenv("SERVER_SIGNATURES"); raw {
+	case "":
+		serverSignatures = signaturesOff
+	case signaturesOff, signaturesOptional, signaturesRequired:
+		serverSignatures = raw
+	default:
+		return fmt.Errorf("invalid SERVER_SIGNATURES %q, must be %s, %s or %s", raw, signaturesOff, signaturesOptional, signaturesRequired)
+	}
+	if signingSecrets, err = loadSigningSecrets(os.Getenv("SIGNING_SECRETS_FILE"), os.Environ()); err != nil {
+		return err
+	}
+	if serverSignatures != signaturesOff && len(signingSecrets) == 0 {
+		return fmt.Errorf("SERVER_SIGNATURES=%s needs SIGNING_SECRETS_FILE or SIGNING_SECRET_<server_id>", serverSignatures)
+	}
+
+	keys, err := loadKeyStore(os.Getenv("KEYS_FILE"), os.Environ())
+	if err != nil {
+		return err
+	}
+	if raw := os.Getenv("MASTER_KEY"); raw != "" {
+		if masterKey, err = parseMasterKey(raw); err != nil {
+			return err
+		}
+		if err := checkEncryptedKeys(keys, masterKey); err != nil {
+			return err
+		}
+	}
+	serverKeys = keys
+
+	switch keyProviderName {
+	case "", keyProviderStatic:
+		keyProvider = nil
+	case keyProviderVault:
+		vault, err := newVaultKeyProvider(vaultAddr, vaultToken, vaultPathPrefix)
+		if err != nil {
+			return err
+		}
+		keyProvider = vault
+	default:
+		return fmt.Errorf("invalid KEY_PROVIDER %q, must be %s or %s", keyProviderName, keyProviderStatic, keyProviderVault)
+	}
+
+	if raw := os.Getenv("OUTBOUND_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid OUTBOUND_TIMEOUT %q", raw)
+		}
+		outboundClient.Timeout = timeout
+		callbackClient.Timeout = timeout
+	}
+
+	notifyCooldown = 0
+	if raw := os.Getenv("NOTIFY_COOLDOWN"); raw != "" {
+		if notifyCooldown, err = time.ParseDuration(raw); err != nil || notifyCooldown < 0 {
+			return fmt.Errorf("invalid NOTIFY_COOLDOWN %q", raw)
+		}
+	}
+
+	var notifiers multiNotifier
+	if url := os.Getenv("SLACK_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, newSlackNotifier(url))
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		chatID := os.Getenv("TELEGRAM_CHAT_ID")
+		if chatID == "" {
+			return fmt.Errorf("TELEGRAM_CHAT_ID is required with TELEGRAM_BOT_TOKEN")
+		}
+		telegramWebhookSecret = os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+		if len(telegramWebhookSecret) < minSecretKeyLength {
+			return fmt.Errorf("TELEGRAM_WEBHOOK_SECRET of at least %d characters is required with TELEGRAM_BOT_TOKEN", minSecretKeyLength)
+		}
+		if telegramAdmins, err = parseTelegramAdmins(os.Getenv("TELEGRAM_ADMINS")); err != nil {
+			return err
+		}
+		notifiers = append(notifiers, newTelegramNotifier(token, chatID))
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		from, to := os.Getenv("SMTP_FROM"), parseAddressList(os.Getenv("SMTP_TO"))
+		if from == "" || len(to) == 0 {
+			return fmt.Errorf("SMTP_FROM and SMTP_TO are required with SMTP_HOST")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = defaultSMTPPort
+		}
+		startTLS := true
+		if raw := os.Getenv("SMTP_STARTTLS"); raw != "" {
+			if startTLS, err = strconv.ParseBool(raw); err != nil {
+				return fmt.Errorf("invalid SMTP_STARTTLS %q", raw)
+			}
+		}
+		notifiers = append(notifiers, newSMTPNotifier(host, port, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASS"), from, to, startTLS))
+	}
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		timeout := outboundClient.Timeout
+		if raw := os.Getenv("WEBHOOK_TIMEOUT"); raw != "" {
+			if timeout, err = time.ParseDuration(raw); err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid WEBHOOK_TIMEOUT %q", raw)
+			}
+		}
+		contentType := os.Getenv("WEBHOOK_CONTENT_TYPE")
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		webhook, err := newWebhookNotifier(url, os.Getenv("WEBHOOK_TEMPLATE"), contentType, timeout)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	if key := os.Getenv("PAGERDUTY_ROUTING_KEY"); key != "" {
+		highPriorityNotifier = newPagerDutyNotifier(key)
+	}
+	switch len(notifiers) {
+	case 0:
+	case 1:
+		notifier = notifiers[0]
+	default:
+		notifier = notifiers
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigApprovalTimeout(t *testing.T) {
+	originalTimeout, originalKeys := approvalTimeout, serverKeys
+	defer func() { approvalTimeout, serverKeys = originalTimeout, originalKeys }()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "Valid", value: "2m", want: 2 * time.Minute},
+		{name: "Empty", value: "", wantErr: true},
+		{name: "Malformed", value: "5 min", wantErr: true},
+		{name: "Negative", value: "-1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APPROVAL_TIMEOUT", tt.value)
+			err := loadConfig()
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "APPROVAL_TIMEOUT")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, approvalTimeout)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	originalAdmin, originalServer, originalBind := adminSecretKeys, serverSecretKey, bindAddress
+	defer func() { adminSecretKeys, serverSecretKey, bindAddress = originalAdmin, originalServer, originalBind }()
+
+	const goodKey = "0123456789abcdef"
+	tests := []struct {
+		name       string
+		adminKeys  []string
+		serverKey  string
+		bind       string
+		timeout    string
+		wantErrors []string
+	}{
+		{name: "Valid", adminKeys: []string{goodKey}, serverKey: goodKey, bind: ":8080", timeout: "5m"},
+		{
+			name:       "AllMissing",
+			wantErrors: []string{"ADMIN_SECRET_KEY", "SERVER_SECRET_KEY is required", "BIND_ADDRESS", "APPROVAL_TIMEOUT is required"},
+		},
+		{
+			name:      "ShortKeys",
+			adminKeys: []string{goodKey, "short"}, serverKey: "short", bind: ":8080", timeout: "5m",
+			wantErrors: []string{"admin secret key 2 is shorter", "SERVER_SECRET_KEY is shorter"},
+		},
+		{
+			name:      "MalformedTimeout",
+			adminKeys: []string{goodKey}, serverKey: goodKey, bind: ":8080", timeout: "five minutes",
+			wantErrors: []string{"APPROVAL_TIMEOUT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminSecretKeys, serverSecretKey, bindAddress = tt.adminKeys, tt.serverKey, tt.bind
+			t.Setenv("APPROVAL_TIMEOUT", tt.timeout)
+
+			errs := validateConfig()
+			if assert.Len(t, errs, len(tt.wantErrors)) {
+				for i, want := range tt.wantErrors {
+					assert.Contains(t, errs[i].Error(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// notifyCooldown suppresses notifications for a server_id within this long of
+// its last one, so a misbehaving server cannot flood the admins. Set from
+// NOTIFY_COOLDOWN, zero announces every request.
+var notifyCooldown time.Duration
+
+// cooldownState is when a server was last announced and how many of its
+// requests were suppressed since
+type cooldownState struct {
+	last       time.Time
+	suppressed int
+}
+
+// notifyCooldowns tracks the notification cooldown of every server
+type notifyCooldowns struct {
+	mu      sync.Mutex
+	servers map[string]*cooldownState
+}
+
+var notificationCooldowns = &notifyCooldowns{servers: make(map[string]*cooldownState)}
+
+// allow reports whether a request of serverID may be announced at now and,
+// if so, how many were suppressed since the last announcement. A suppressed
+// request is counted towards the next one.
+func (n *notifyCooldowns) allow(serverID string, now time.Time) (bool, int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	state, ok := n.servers[serverID]
+	if !ok {
+		n.servers[serverID] = &cooldownState{last: now}
+		return true, 0
+	}
+	if now.Sub(state.last) < notifyCooldown {
+		state.suppressed++
+		return false, 0
+	}
+	suppressed := state.suppressed
+	state.last, state.suppressed = now, 0
+	return true, suppressed
+}
+
+// prune forgets servers whose cooldown has passed, along with any count of
+// suppressed requests, which are still listed in /admin/requests
+func (n *notifyCooldowns) prune() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for serverID, state := range n.servers {
+		if time.Since(state.last) >= notifyCooldown {
+			delete(n.servers, serverID)
+		}
+	}
+}
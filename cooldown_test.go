@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setNotifyCooldown sets the cooldown for the test with a fresh server history
+func setNotifyCooldown(t *testing.T, cooldown time.Duration) {
+	t.Helper()
+	originalCooldown, originalCooldowns := notifyCooldown, notificationCooldowns
+	notifyCooldown, notificationCooldowns = cooldown, &notifyCooldowns{servers: make(map[string]*cooldownState)}
+	t.Cleanup(func() { notifyCooldown, notificationCooldowns = originalCooldown, originalCooldowns })
+}
+
+// expectNotNotified fails if n is told of any request shortly
+func expectNotNotified(t *testing.T, n *recordingNotifier) {
+	t.Helper()
+	select {
+	case got := <-n.sent:
+		t.Fatalf("unexpected notification for %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotifyCooldown(t *testing.T) {
+	isolatePendingRequests(t)
+	setNotifyCooldown(t, time.Minute)
+	normal := newRecordingNotifier()
+	setNotifiers(t, normal, nil)
+	router := setupRouter()
+
+	createTestRequest(t, router, "flapping")
+	expectNotified(t, normal, "flapping")
+	second := createTestRequest(t, router, "flapping")
+	require.NotEmpty(t, second, "the request itself is still created")
+	expectNotNotified(t, normal)
+
+	createTestRequest(t, router, "other")
+	expectNotified(t, normal, "other")
+}
+
+func TestNotifyCooldownCoalesces(t *testing.T) {
+	setNotifyCooldown(t, time.Minute)
+	start := time.Now()
+
+	ok, _ := notificationCooldowns.allow("flapping", start)
+	assert.True(t, ok)
+	for i := 1; i <= 3; i++ {
+		ok, _ = notificationCooldowns.allow("flapping", start.Add(time.Duration(i)*time.Second))
+		assert.False(t, ok)
+	}
+	ok, suppressed := notificationCooldowns.allow("flapping", start.Add(2*time.Minute))
+	assert.True(t, ok, "announced again once the cooldown passed")
+	assert.Equal(t, 3, suppressed)
+
+	req := &Request{ID: "id", ServerID: "flapping", SuppressedNotifications: suppressed}
+	assert.Contains(t, notificationText(req), "3 more request(s) from this server were not announced during the 1m0s cooldown")
+}
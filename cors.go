@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsAllowedOrigins lists the browser origins allowed to call the admin
+// endpoints, set from CORS_ALLOWED_ORIGINS. Empty disables CORS entirely.
+var corsAllowedOrigins []string
+
+// Methods and headers the admin endpoints accept from a browser
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, " + adminIDHeader + ", " + requestIDHeader + ", " + totpHeader
+	corsMaxAge         = "600"
+)
+
+// parseCORSOrigins splits a comma-separated origin list, dropping empty
+// entries and trailing slashes so origins compare exactly
+func parseCORSOrigins(list string) []string {
+	origins := []string{}
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// corsMiddleware adds CORS headers for allowed origins. It runs before
+// authentication so preflights, which carry no credentials, and 401s are
+// readable by the browser. Disallowed origins get no CORS headers at all.
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(corsAllowedOrigins) == 0 {
+			c.Next()
+			return
+		}
+		c.Header("Vary", "Origin")
+		origin := c.GetHeader("Origin")
+		if origin == "" || !slices.Contains(corsAllowedOrigins, origin) {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Expose-Headers", totalCountHeader+", "+requestIDHeader)
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", corsAllowedMethods)
+			c.Header("Access-Control-Allow-Headers", corsAllowedHeaders)
+			c.Header("Access-Control-Max-Age", corsMaxAge)
+		}
+		c.Next()
+	}
+}
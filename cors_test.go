@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCORSOrigins(t *testing.T) {
+	assert.Equal(t, []string{}, parseCORSOrigins(""))
+	assert.Equal(t, []string{"https://a.example.com", "http://localhost:3000"},
+		parseCORSOrigins(" https://a.example.com/ ,,http://localhost:3000"))
+}
+
+func TestCORS(t *testing.T) {
+	const allowedOrigin = "https://dashboard.example.com"
+
+	tests := []struct {
+		name        string
+		origins     []string
+		method      string
+		origin      string
+		preflight   bool
+		wantCode    int
+		wantOrigin  string
+		wantMethods string
+	}{
+		{
+			name:       "allowed origin",
+			origins:    []string{allowedOrigin},
+			method:     "GET",
+			origin:     allowedOrigin,
+			wantCode:   http.StatusOK,
+			wantOrigin: allowedOrigin,
+		},
+		{
+			name:     "disallowed origin",
+			origins:  []string{allowedOrigin},
+			method:   "GET",
+			origin:   "https://evil.example.com",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:        "preflight from allowed origin",
+			origins:     []string{allowedOrigin},
+			method:      "OPTIONS",
+			origin:      allowedOrigin,
+			preflight:   true,
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  allowedOrigin,
+			wantMethods: corsAllowedMethods,
+		},
+		{
+			name:      "preflight from disallowed origin",
+			origins:   []string{allowedOrigin},
+			method:    "OPTIONS",
+			origin:    "https://evil.example.com",
+			preflight: true,
+			wantCode:  http.StatusNoContent,
+		},
+		{
+			name:     "no origins configured",
+			method:   "GET",
+			origin:   allowedOrigin,
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalOrigins := corsAllowedOrigins
+			corsAllowedOrigins = tt.origins
+			defer func() { corsAllowedOrigins = originalOrigins }()
+
+			router := setupRouter()
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "/admin/requests", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				// Preflights carry no credentials
+				req.Header.Set("Access-Control-Request-Method", "GET")
+				req.Header.Set("Access-Control-Request-Headers", "authorization")
+			} else {
+				req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.wantMethods, w.Header().Get("Access-Control-Allow-Methods"))
+			if tt.preflight && tt.wantOrigin != "" {
+				assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+			}
+			assert.NotEqual(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestCORSOnUnauthorizedResponse(t *testing.T) {
+	originalOrigins := corsAllowedOrigins
+	corsAllowedOrigins = []string{"https://dashboard.example.com"}
+	defer func() { corsAllowedOrigins = originalOrigins }()
+
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/requests", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	router.ServeHTTP(w, req)
+
+	// The dashboard can tell a bad key apart from a network error
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "https://dashboard.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,47 @@
+package main
+
+// idempotencyKeyHeader lets a retrying server scope deduplication to one
+// logical request rather than to every pending request of its server_id
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// dedupRequests makes request-key return an existing pending request of the
+// same server instead of creating another one, set from DEDUP_REQUESTS
+var dedupRequests bool
+
+// createChecksAll reports whether creating a request looks at every stored
+// request, for deduplication, MAX_PENDING, approval code collisions or eviction
+func createChecksAll() bool {
+	return dedupRequests || maxPending > 0 || shortCodes || evictionEnabled()
+}
+
+// lockForCreate takes the locks needed to store the new request reqID and
+// returns the function releasing them. Checks that look at every stored
+// request, see createChecksAll, need all of them.
+func lockForCreate(reqID string) func() {
+	if createChecksAll() {
+		requestLocks.LockAll()
+		return requestLocks.UnlockAll
+	}
+	requestLocks.Lock(reqID)
+	return func() { requestLocks.Unlock(reqID) }
+}
+
+// findPendingDuplicate returns a live, undecided request among requests from
+// the same server as req, or nil. When req carries an idempotency key only a
+// request created with the same key matches.
+func findPendingDuplicate(requests []*Request, req *Request) *Request {
+	var duplicate *Request
+	for _, existing := range requests {
+		if existing.ServerID != req.ServerID || existing.Approved || existing.Denied || isRequestExpired(existing) {
+			continue
+		}
+		if req.IdempotencyKey != "" && existing.IdempotencyKey != req.IdempotencyKey {
+			continue
+		}
+		// Prefer the oldest so every retry converges on the same request
+		if duplicate == nil || existing.CreatedAt.Before(duplicate.CreatedAt) {
+			duplicate = existing
+		}
+	}
+	return duplicate
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestDeduplication(t *testing.T) {
+	isolatePendingRequests(t)
+	dedupRequests = true
+	defer func() { dedupRequests = false }()
+
+	router := setupRouter()
+	requestKey := func(serverID, idempotencyKey string) (int, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id": "`+serverID+`"}`))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		if idempotencyKey != "" {
+			req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+		}
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		id, _ := response["request_id"].(string)
+		return w.Code, id
+	}
+
+	code, first := requestKey("test-server", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	code, retried := requestKey("test-server", "")
+	assert.Equal(t, http.StatusOK, code, "a duplicate is not a new request")
+	assert.Equal(t, first, retried)
+
+	code, other := requestKey("other-server", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.NotEqual(t, first, other)
+
+	// An idempotency key only matches requests created with the same key
+	code, keyed := requestKey("keyed-server", "boot-42")
+	assert.Equal(t, http.StatusAccepted, code)
+	code, again := requestKey("keyed-server", "boot-42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, keyed, again)
+	code, fresh := requestKey("keyed-server", "boot-43")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.NotEqual(t, keyed, fresh)
+
+	// Decided or expired requests are not reused
+	updateTestRequest(t, first, func(req *Request) { req.Approved = true })
+	code, next := requestKey("test-server", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.NotEqual(t, first, next)
+	updateTestRequest(t, next, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	code, last := requestKey("test-server", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.NotEqual(t, next, last)
+}
+
+func TestRequestDeduplicationDisabled(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	first := createTestRequest(t, router, "test-server")
+	second := createTestRequest(t, router, "test-server")
+	assert.NotEqual(t, first, second)
+}
+
+func TestDeduplicatedPollInterval(t *testing.T) {
+	isolatePendingRequests(t)
+	dedupRequests = true
+	defer func() { dedupRequests = false }()
+
+	router := setupRouter()
+	createTestRequest(t, router, "test-server")
+
+	// The hint comes from the cached count, like every other poll interval
+	original := storedRequests.Load()
+	storedRequests.Store(500)
+	defer storedRequests.Store(original)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id": "test-server"}`))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"poll_interval_seconds":5`)
+}
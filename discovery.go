@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// defaultDiscoveryHeartbeat is used when DISCOVERY_HEARTBEAT is not set
+const defaultDiscoveryHeartbeat = 30 * time.Second
+
+// discoveryInstance is the payload sent to the discovery service
+type discoveryInstance struct {
+	Address string `json:"address"`
+	Version string `json:"version"`
+}
+
+// sendDiscovery sends the instance to the discovery service with the given
+// method, bounded by OUTBOUND_TIMEOUT so a hung service cannot stall heartbeats
+func sendDiscovery(ctx context.Context, method, url string, instance discoveryInstance) error {
+	body, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("discovery service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// runDiscovery registers the instance, sends heartbeats until ctx is done and
+// then deregisters. Failures are logged and never stop the server.
+func runDiscovery(ctx context.Context, url string, interval time.Duration, instance discoveryInstance) {
+	if err := sendDiscovery(ctx, http.MethodPost, url, instance); err != nil {
+		slog.Warn("discovery register failed", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The parent context is gone, give deregistration its own deadline
+			deregisterCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := sendDiscovery(deregisterCtx, http.MethodDelete, url, instance); err != nil {
+				slog.Warn("discovery deregister failed", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := sendDiscovery(ctx, http.MethodPost, url, instance); err != nil {
+				slog.Warn("discovery heartbeat failed", "error", err)
+			}
+		}
+	}
+}
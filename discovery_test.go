@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDiscovery(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+		last    discoveryInstance
+	)
+	discovery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, r.Method)
+		json.NewDecoder(r.Body).Decode(&last)
+	}))
+	defer discovery.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runDiscovery(ctx, discovery.URL, 20*time.Millisecond, discoveryInstance{Address: "10.0.0.1:8080", Version: "test"})
+		close(done)
+	}()
+
+	time.Sleep(70 * time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, len(methods), 3, "expected register, heartbeats and deregister")
+	assert.Equal(t, http.MethodPost, methods[0])
+	assert.Equal(t, http.MethodDelete, methods[len(methods)-1])
+	assert.Equal(t, "10.0.0.1:8080", last.Address)
+	assert.Equal(t, "test", last.Version)
+}
+
+func TestRunDiscoveryUnavailable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		// Nothing listens here, every call fails and must only be logged
+		runDiscovery(ctx, "http://127.0.0.1:1", 10*time.Millisecond, discoveryInstance{Address: ":8080"})
+		close(done)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runDiscovery did not return after cancellation")
+	}
+}
+
+func TestSendDiscoveryTimeout(t *testing.T) {
+	originalTimeout := outboundClient.Timeout
+	outboundClient.Timeout = 100 * time.Millisecond
+	defer func() { outboundClient.Timeout = originalTimeout }()
+	server := slowServer(t, 5*time.Second)
+
+	// A hung discovery service must not block the heartbeat loop
+	start := time.Now()
+	err := sendDiscovery(context.Background(), http.MethodPut, server.URL, discoveryInstance{Address: ":8080"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "the heartbeat aborts at OUTBOUND_TIMEOUT")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultEnumerationDelay is used when ENUMERATION_DELAY is not set
+const defaultEnumerationDelay = 100 * time.Millisecond
+
+var (
+	// hardenEnumeration keeps holders of the server key from learning which
+	// request IDs exist by probing: unknown IDs are answered like pending
+	// requests and lookups by ID take at least enumerationDelay
+	hardenEnumeration bool
+	enumerationDelay  = defaultEnumerationDelay
+)
+
+// normalizeTiming holds back the end of a lookup by request ID until
+// enumerationDelay has passed since the call arrived, so the time taken does
+// not tell a stored request from an unknown one. net/http buffers the small
+// responses of these endpoints until the handler returns. It only applies with
+// HARDEN_ENUMERATION.
+func normalizeTiming() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hardenEnumeration {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+		time.Sleep(time.Until(start.Add(enumerationDelay)))
+	}
+}
+
+// hiddenNotFound reports whether reqID, which is not stored, must be answered
+// as if it were pending. IDs that recently expired or were consumed keep their
+// answer, only a caller who was given them can know them.
+func hiddenNotFound(reqID string) bool {
+	return hardenEnumeration && !recentlyExpired.contains(reqID) && !recentlyConsumed.contains(reqID)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probeTestRequest posts body to a server endpoint and returns the response
+// along with how long the call took
+func probeTestRequest(router *gin.Engine, path string, body map[string]string) (*httptest.ResponseRecorder, time.Duration) {
+	w := httptest.NewRecorder()
+	jsonBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	return w, time.Since(start)
+}
+
+func TestHardenEnumeration(t *testing.T) {
+	isolatePendingRequests(t)
+	originalHarden, originalDelay := hardenEnumeration, enumerationDelay
+	hardenEnumeration, enumerationDelay = true, 50*time.Millisecond
+	defer func() { hardenEnumeration, enumerationDelay = originalHarden, originalDelay }()
+	router := setupRouter()
+
+	pending := createTestRequest(t, router, "test-server")
+	unknown := uuid.NewString()
+
+	t.Run("get-key", func(t *testing.T) {
+		known, knownTook := probeTestRequest(router, "/server/get-key", map[string]string{"req_id": pending})
+		probed, probedTook := probeTestRequest(router, "/server/get-key", map[string]string{"req_id": unknown})
+		assert.Equal(t, http.StatusForbidden, known.Code)
+		assert.Equal(t, known.Code, probed.Code)
+		assert.JSONEq(t, known.Body.String(), probed.Body.String())
+		assert.Equal(t, errCodeNotApproved, responseError(t, probed).Code)
+		assert.GreaterOrEqual(t, knownTook, enumerationDelay)
+		assert.GreaterOrEqual(t, probedTook, enumerationDelay)
+	})
+
+	t.Run("long poll", func(t *testing.T) {
+		known, knownTook := probeTestRequest(router, "/server/get-key?wait=100ms", map[string]string{"req_id": pending})
+		probed, probedTook := probeTestRequest(router, "/server/get-key?wait=100ms", map[string]string{"req_id": unknown})
+		assert.Equal(t, http.StatusAccepted, known.Code)
+		assert.Equal(t, known.Code, probed.Code)
+		assert.JSONEq(t, known.Body.String(), probed.Body.String())
+		assert.GreaterOrEqual(t, knownTook, 100*time.Millisecond)
+		assert.GreaterOrEqual(t, probedTook, 100*time.Millisecond, "an unknown ID waits like a pending one")
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		known, _ := probeTestRequest(router, "/server/revoke", map[string]string{"req_id": pending, "server_id": "other-server"})
+		probed, _ := probeTestRequest(router, "/server/revoke", map[string]string{"req_id": unknown, "server_id": "other-server"})
+		assert.Equal(t, http.StatusNotFound, known.Code)
+		assert.Equal(t, known.Code, probed.Code)
+		assert.JSONEq(t, known.Body.String(), probed.Body.String())
+		assert.NotNil(t, getTestRequest(t, pending), "another server's request is left alone")
+	})
+}
+
+func TestHardenEnumerationOff(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+	require.False(t, hardenEnumeration)
+
+	pending := createTestRequest(t, router, "test-server")
+
+	code, response := fetchTestKey(t, router, pending)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "pending", response["status"])
+	assert.Contains(t, response, "approvals_remaining")
+
+	w, _ := probeTestRequest(router, "/server/get-key", map[string]string{"req_id": uuid.NewString()})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, errCodeRequestNotFound, responseError(t, w).Code)
+
+	w, _ = probeTestRequest(router, "/server/revoke", map[string]string{"req_id": pending, "server_id": "other-server"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, errCodeForbidden, responseError(t, w).Code)
+}
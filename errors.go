@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of the error envelope. They are part of the API, clients switch
+// on them rather than on the message, so existing codes must not change.
+const (
+	errCodeBadRequest             = "bad_request"
+	errCodeInvalidRequestID       = "invalid_request_id"
+	errCodeConfirmationRequired   = "confirmation_required"
+	errCodeBodyTooLarge           = "body_too_large"
+	errCodeUnauthorized           = "unauthorized"
+	errCodeTOTPRequired           = "totp_required"
+	errCodeInvalidTOTP            = "invalid_totp"
+	errCodeForbidden              = "forbidden"
+	errCodeOutOfScope             = "out_of_scope"
+	errCodeNotFound               = "not_found"
+	errCodeRequestNotFound        = "request_not_found"
+	errCodeRequestExpired         = "request_expired"
+	errCodeRequestDenied          = "request_denied"
+	errCodeNotApproved            = "not_approved"
+	errCodeNotYetReleased         = "not_yet_released"
+	errCodeKeyConsumed            = "key_consumed"
+	errCodeKeyNotConfigured       = "key_not_configured"
+	errCodeAlreadyDecided         = "already_decided"
+	errCodeAlreadyApproved        = "already_approved"
+	errCodeMultipleCandidates     = "multiple_candidates"
+	errCodeInvalidLink            = "invalid_link"
+	errCodeLinkExpired            = "link_expired"
+	errCodeLinkUsed               = "link_used"
+	errCodeConflict               = "conflict"
+	errCodeRateLimited            = "rate_limited"
+	errCodeTooManyPending         = "too_many_pending"
+	errCodeStoreUnavailable       = "store_unavailable"
+	errCodeKeyProviderUnavailable = "key_provider_unavailable"
+	errCodeInternal               = "internal_error"
+)
+
+// apiError is the "error" member of every error response, a stable code for
+// clients and a message for people
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// respondError writes the error envelope {"error": {"code", "message"}}.
+// Responses carrying more fields, such as the get-key status, add them next to
+// "error" themselves.
+func respondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{"error": apiError{Code: code, Message: message}})
+}
+
+// respondDecision answers an admin decision: errors, those with a code, in the
+// error envelope and successes with the plain-text message
+func respondDecision(c *gin.Context, status int, code, message string) {
+	if code != "" {
+		respondError(c, status, code, message)
+		return
+	}
+	c.String(status, message)
+}
+
+// respondNoRoute answers calls to unknown paths in the error envelope
+func respondNoRoute(c *gin.Context) {
+	respondError(c, http.StatusNotFound, errCodeNotFound, "No such endpoint")
+}
+
+// respondPanic answers a call whose handler panicked, without its details
+func respondPanic(c *gin.Context, _ any) {
+	respondError(c, http.StatusInternalServerError, errCodeInternal, "Internal server error")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// responseError decodes the error envelope of the response recorded by w
+func responseError(t *testing.T, w *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+	return body.Error
+}
+
+func TestErrorCodes(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	expired := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	unknown := uuid.NewString()
+	server, admin := "Bearer "+serverSecretKey, "Bearer "+adminSecretKey
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		authHeader string
+		wantStatus int
+		wantCode   string
+	}{
+		{"expired", "POST", "/server/get-key", `{"req_id":"` + expired + `"}`, server, http.StatusGone, errCodeRequestExpired},
+		{"not found", "POST", "/server/get-key", `{"req_id":"` + unknown + `"}`, server, http.StatusNotFound, errCodeRequestNotFound},
+		{"admin not found", "GET", "/admin/approve/" + unknown, "", admin, http.StatusNotFound, errCodeRequestNotFound},
+		{"unknown endpoint", "GET", "/admin-panel", "", "", http.StatusNotFound, errCodeNotFound},
+		{"unauthorized", "GET", "/admin/requests", "", "Bearer wrong-admin-key", http.StatusUnauthorized, errCodeUnauthorized},
+		{"missing authorization", "POST", "/server/get-key", `{"req_id":"` + unknown + `"}`, "", http.StatusUnauthorized, errCodeUnauthorized},
+		{"malformed body", "POST", "/server/request-key", `{"server_id"`, server, http.StatusBadRequest, errCodeBadRequest},
+		{"invalid reason code", "GET", "/admin/deny/" + unknown + "?reason_code=bogus", "", admin, http.StatusBadRequest, errCodeBadRequest},
+		{"invalid request ID", "POST", "/server/get-key", `{"req_id":"abc"}`, server, http.StatusBadRequest, errCodeInvalidRequestID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+			apiErr := responseError(t, w)
+			assert.Equal(t, tt.wantCode, apiErr.Code)
+			assert.NotEmpty(t, apiErr.Message)
+		})
+	}
+}
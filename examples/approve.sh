@@ -1,5 +1,5 @@
 #!/usr/bin/env sh
-API_KEY="admin"
+API_KEY="change-me-admin-key"
 PASS_HOST="http://localhost:8080"
 
 # Request a secret from the server
@@ -1,18 +1,18 @@
 #!/usr/bin/env sh
-API_KEY="server"
+API_KEY="change-me-server-key"
 PASS_HOST="http://localhost:8080"
 SERVER_ID="darkstar"
 
-# Request a secret from the server
+# Call a server endpoint with a JSON body, unknown fields are rejected
 req() {
   curl -s -X POST \
-    -d '{"server_id":"'"$SERVER_ID"'", "req_id": "'"${REQ_ID:-nil}"'"}' \
+    -d "$2" \
     -H "Content-Type: application/json" \
     -H 'Authorization: Bearer '"$API_KEY"'' \
     $PASS_HOST/server/$1
 }
 
-REQ_ID=$(req request-key | jq -r '.request_id')
+REQ_ID=$(req request-key '{"server_id":"'"$SERVER_ID"'"}' | jq -r '.request_id')
 
 echo "Request ID: $REQ_ID"
 echo "Waiting for request to be approved..."
@@ -20,7 +20,7 @@ echo "Approve with ./approve.sh $REQ_ID"
 
 # trying to get the secret from the server for 5 minutes then exit
 while true; do
-    RESPONSE=$(req get-key)
+    RESPONSE=$(req get-key '{"req_id":"'"$REQ_ID"'"}')
     KEY=$(echo $RESPONSE | jq -r '.key')
     
     if [ "$KEY" != "null" ]; then
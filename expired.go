@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// expiredRetention is how long the IDs of removed expired requests are
+// remembered, so late polls get 410 Gone rather than 404 Not Found
+const expiredRetention = time.Hour
+
+// expiredIDs records when recently removed expired requests were removed
+type expiredIDs struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// recentlyExpired holds the expired requests removed within expiredRetention
+var recentlyExpired = &expiredIDs{ids: make(map[string]time.Time)}
+
+// recentlyConsumed holds the requests removed after their key was fetched in
+// ONE_TIME_KEY mode, so a repeated fetch gets 410 Gone
+var recentlyConsumed = &expiredIDs{ids: make(map[string]time.Time)}
+
+func (e *expiredIDs) add(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ids[id] = time.Now()
+}
+
+// contains reports whether id belonged to a request that expired and was removed
+func (e *expiredIDs) contains(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	removed, ok := e.ids[id]
+	return ok && time.Since(removed) < expiredRetention
+}
+
+// prune forgets IDs removed longer than expiredRetention ago
+func (e *expiredIDs) prune() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, removed := range e.ids {
+		if time.Since(removed) >= expiredRetention {
+			delete(e.ids, id)
+		}
+	}
+}
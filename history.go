@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHistorySize is used when HISTORY_SIZE is not set
+const defaultHistorySize = 1000
+
+// historyOutcomes maps the audited events that end a request to the outcome
+// recorded in /admin/history
+var historyOutcomes = map[string]string{
+	auditKeyReleased:    "fetched",
+	auditRequestDenied:  "denied",
+	auditRequestExpired: "expired",
+	auditRequestEvicted: "evicted",
+	auditRequestRevoked: "revoked",
+	auditRequestPurged:  "purged",
+}
+
+// historyEntry is one completed request
+type historyEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	ServerID  string    `json:"server_id"`
+	Outcome   string    `json:"outcome"`
+	Admin     string    `json:"admin,omitempty"` // acting admin, or the approvers of a fetched key
+	IP        string    `json:"ip"`
+}
+
+// historyBuffer keeps the most recent entries in a fixed-size ring, dropping
+// the oldest once full
+type historyBuffer struct {
+	mu      sync.Mutex
+	entries []historyEntry
+	next    int  // slot the next entry is written to
+	full    bool // every slot holds an entry
+}
+
+// requestHistory records completed requests, sized by HISTORY_SIZE
+var requestHistory = newHistoryBuffer(defaultHistorySize)
+
+func newHistoryBuffer(size int) *historyBuffer {
+	return &historyBuffer{entries: make([]historyEntry, size)}
+}
+
+func (h *historyBuffer) add(entry historyEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the recorded entries, newest first
+func (h *historyBuffer) list() []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	count := h.next
+	if h.full {
+		count = len(h.entries)
+	}
+	entries := make([]historyEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		entries = append(entries, h.entries[(h.next-i+len(h.entries))%len(h.entries)])
+	}
+	return entries
+}
+
+// recordHistory adds req to the history if event completed it. admin is the
+// admin call that acted on it, nil for server calls and background cleanup.
+func recordHistory(event string, req *Request, admin *gin.Context) {
+	outcome, ok := historyOutcomes[event]
+	if !ok {
+		return
+	}
+	// Without ONE_TIME_KEY a server may fetch the key again and again, only
+	// the first fetch completes the request
+	if event == auditKeyReleased && !req.FetchedAt.IsZero() {
+		return
+	}
+	entry := historyEntry{
+		Time:      time.Now().UTC(),
+		RequestID: req.ID,
+		ServerID:  req.ServerID,
+		Outcome:   outcome,
+		IP:        req.IP,
+	}
+	if admin != nil {
+		entry.Admin = adminID(admin)
+	} else if event == auditKeyReleased {
+		entry.Admin = strings.Join(req.ApprovedBy, ",")
+	}
+	requestHistory.add(entry)
+}
+
+// handleAdminHistory returns the recently completed requests in the admin's
+// scope, newest first
+func handleAdminHistory(c *gin.Context) {
+	entries := slices.DeleteFunc(requestHistory.list(), func(entry historyEntry) bool {
+		return !inAdminScope(c, entry.ServerID)
+	})
+	c.JSON(http.StatusOK, entries)
+}
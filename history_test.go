@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHistory(t *testing.T) {
+	original := requestHistory
+	requestHistory = newHistoryBuffer(10)
+	defer func() { requestHistory = original }()
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	req.Header.Set(adminIDHeader, "alice")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	code, _ := fetchTestKey(t, router, reqID)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = fetchTestKey(t, router, reqID)
+	require.Equal(t, http.StatusOK, code, "without ONE_TIME_KEY the key can be fetched again")
+
+	denied := createTestRequest(t, router, "other-server")
+	adminCall(router, "GET", "/admin/deny/"+denied, adminSecretKey)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/history", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var history []historyEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 2, "a repeated fetch is recorded once")
+	assert.Equal(t, denied, history[0].RequestID, "newest first")
+	assert.Equal(t, "denied", history[0].Outcome)
+
+	fetched := history[1]
+	assert.Equal(t, reqID, fetched.RequestID)
+	assert.Equal(t, "fetched", fetched.Outcome)
+	assert.Equal(t, "test-server", fetched.ServerID)
+	assert.Equal(t, "alice", fetched.Admin)
+	assert.Equal(t, getTestRequest(t, reqID).IP, fetched.IP)
+	assert.False(t, fetched.Time.IsZero())
+}
+
+func TestHistoryBufferBounded(t *testing.T) {
+	history := newHistoryBuffer(3)
+	assert.Empty(t, history.list())
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		history.add(historyEntry{RequestID: id})
+	}
+	var ids []string
+	for _, entry := range history.list() {
+		ids = append(ids, entry.RequestID)
+	}
+	assert.Equal(t, []string{"e", "d", "c"}, ids, "the oldest entries are dropped")
+
+	disabled := newHistoryBuffer(0)
+	disabled.add(historyEntry{RequestID: "a"})
+	assert.Empty(t, disabled.list())
+}
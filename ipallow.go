@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverIPAllowlist lists the networks allowed to call the server endpoints,
+// set from SERVER_IP_ALLOWLIST. Empty allows every address.
+var serverIPAllowlist []*net.IPNet
+
+// trustedProxies lists the proxies whose X-Forwarded-For gin believes when
+// working out the client IP, set from TRUSTED_PROXIES. gin itself trusts every
+// peer, which would let any caller forge its stored IP, so by default only a
+// proxy on the same host is believed. An empty list ignores X-Forwarded-For.
+var trustedProxies = defaultTrustedProxies
+
+// defaultTrustedProxies is used when TRUSTED_PROXIES is not set
+var defaultTrustedProxies = []string{"127.0.0.1", "::1"}
+
+// parseCIDRList parses comma-separated CIDRs, accepting a bare IP as a single
+// address network. name is the setting reported in errors.
+func parseCIDRList(name, list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s entry %q", name, entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q", name, entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ipAllowed reports whether ip lies in one of networks
+func ipAllowed(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAllowedServerIP rejects server calls from client IPs outside
+// SERVER_IP_ALLOWLIST, even with the right secret. The client IP only follows
+// X-Forwarded-For from TRUSTED_PROXIES.
+func requireAllowedServerIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(serverIPAllowlist) == 0 {
+			c.Next()
+			return
+		}
+		if ip := net.ParseIP(c.ClientIP()); ip == nil || !ipAllowed(serverIPAllowlist, ip) {
+			slog.Warn("rejected server call not in SERVER_IP_ALLOWLIST", "client_ip", c.ClientIP())
+			respondError(c, http.StatusForbidden, errCodeForbidden, "Client IP not allowed")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	networks, err := parseCIDRList("SERVER_IP_ALLOWLIST", " 10.0.0.0/8, 192.0.2.7 ,,2001:db8::/32")
+	require.NoError(t, err)
+	var got []string
+	for _, network := range networks {
+		got = append(got, network.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::/32"}, got)
+
+	empty, err := parseCIDRList("SERVER_IP_ALLOWLIST", "")
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+
+	for _, invalid := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0"} {
+		_, err := parseCIDRList("SERVER_IP_ALLOWLIST", invalid)
+		assert.ErrorContains(t, err, "SERVER_IP_ALLOWLIST", invalid)
+	}
+}
+
+func TestServerIPAllowlist(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowlist  string
+		proxies    []string
+		remoteAddr string
+		forwarded  string
+		wantCode   int
+	}{
+		{name: "InRange", allowlist: "10.0.0.0/8", remoteAddr: "10.1.2.3:4000", wantCode: http.StatusAccepted},
+		{name: "OutOfRange", allowlist: "10.0.0.0/8", remoteAddr: "192.0.2.1:4000", wantCode: http.StatusForbidden},
+		{name: "EmptyAllowlist", allowlist: "", remoteAddr: "192.0.2.1:4000", wantCode: http.StatusAccepted},
+		{
+			name: "ForwardedByTrustedProxy", allowlist: "10.0.0.0/8", proxies: []string{"192.0.2.1"},
+			remoteAddr: "192.0.2.1:4000", forwarded: "10.1.2.3", wantCode: http.StatusAccepted,
+		},
+		{
+			name: "ForwardedByUntrustedProxy", allowlist: "10.0.0.0/8", proxies: []string{"192.0.2.99"},
+			remoteAddr: "192.0.2.1:4000", forwarded: "10.1.2.3", wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolatePendingRequests(t)
+			allowlist, err := parseCIDRList("SERVER_IP_ALLOWLIST", tt.allowlist)
+			require.NoError(t, err)
+			originalAllowlist, originalProxies := serverIPAllowlist, trustedProxies
+			serverIPAllowlist, trustedProxies = allowlist, tt.proxies
+			defer func() { serverIPAllowlist, trustedProxies = originalAllowlist, originalProxies }()
+
+			router := setupRouter()
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id":"test-server"}`))
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestForgedForwardedForIgnored(t *testing.T) {
+	isolatePendingRequests(t)
+	assert.Equal(t, defaultTrustedProxies, trustedProxies, "loopback proxies are trusted by default")
+
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id":"test-server"}`))
+	req.RemoteAddr = "192.0.2.1:4000"
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", "203.0.113.66")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	requests, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "192.0.2.1", requests[0].IP, "the real remote address is recorded")
+}
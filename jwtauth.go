@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Admin authentication modes selectable via ADMIN_AUTH_MODE
+const (
+	adminAuthSecret = "secret"
+	adminAuthJWT    = "jwt"
+)
+
+// adminJWTKey verifies admin tokens in jwt mode, loaded from ADMIN_JWT_PUBKEY
+var adminJWTKey crypto.PublicKey
+
+// adminContextKey holds the verified identity of the admin in the gin context,
+// set from a JWT or the Telegram user pressing a button
+const adminContextKey = "szlaban.admin"
+
+// adminClaims are the claims of an admin token. The admin claim names the
+// admin and replaces the X-Admin-Id header, which a token holder cannot override.
+type adminClaims struct {
+	Admin string `json:"admin"`
+	jwt.RegisteredClaims
+}
+
+// loadJWTPublicKey parses a PEM public key given inline or as a file path
+func loadJWTPublicKey(value string) (crypto.PublicKey, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, fmt.Errorf("reading ADMIN_JWT_PUBKEY: %v", err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("ADMIN_JWT_PUBKEY is not a PEM public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ADMIN_JWT_PUBKEY: %v", err)
+	}
+	if jwtMethods(key) == nil {
+		return nil, fmt.Errorf("ADMIN_JWT_PUBKEY must be an RSA, ECDSA or Ed25519 key")
+	}
+	return key, nil
+}
+
+// jwtMethods returns the signing algorithms accepted for key, so a token
+// cannot pick a weaker or mismatched algorithm itself
+func jwtMethods(key crypto.PublicKey) []string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	default:
+		return nil
+	}
+}
+
+// verifyAdminJWT checks the token's signature, expiry and admin claim and
+// returns the admin it identifies
+func verifyAdminJWT(token string) (string, error) {
+	var claims adminClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return adminJWTKey, nil
+	}, jwt.WithValidMethods(jwtMethods(adminJWTKey)), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	admin := strings.TrimSpace(claims.Admin)
+	if admin == "" {
+		return "", fmt.Errorf("token has no admin claim")
+	}
+	return admin, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateJWTKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func signAdminJWT(t *testing.T, key *ecdsa.PrivateKey, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	require.NoError(t, err)
+	return token
+}
+
+// enableJWTAuth switches the admin endpoints to JWT mode for the test
+func enableJWTAuth(t *testing.T, publicPEM string) {
+	t.Helper()
+	key, err := loadJWTPublicKey(publicPEM)
+	require.NoError(t, err)
+	originalMode, originalKey := adminAuthMode, adminJWTKey
+	adminAuthMode, adminJWTKey = adminAuthJWT, key
+	t.Cleanup(func() { adminAuthMode, adminJWTKey = originalMode, originalKey })
+}
+
+func TestLoadJWTPublicKey(t *testing.T) {
+	_, publicPEM := generateJWTKey(t)
+
+	_, err := loadJWTPublicKey(publicPEM)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "admin.pub")
+	require.NoError(t, os.WriteFile(path, []byte(publicPEM), 0o600))
+	_, err = loadJWTPublicKey(path)
+	assert.NoError(t, err)
+
+	_, err = loadJWTPublicKey("-----BEGIN PUBLIC KEY-----\nnot a key\n-----END PUBLIC KEY-----\n")
+	assert.Error(t, err)
+	_, err = loadJWTPublicKey(filepath.Join(t.TempDir(), "missing.pub"))
+	assert.Error(t, err)
+}
+
+func TestAdminJWTAuth(t *testing.T) {
+	isolatePendingRequests(t)
+	signingKey, publicPEM := generateJWTKey(t)
+	otherKey, _ := generateJWTKey(t)
+	enableJWTAuth(t, publicPEM)
+
+	valid := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	expired := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{"valid token", signAdminJWT(t, signingKey, adminClaims{Admin: "alice", RegisteredClaims: valid}), http.StatusOK},
+		{"expired token", signAdminJWT(t, signingKey, adminClaims{Admin: "alice", RegisteredClaims: expired}), http.StatusUnauthorized},
+		{"bad signature", signAdminJWT(t, otherKey, adminClaims{Admin: "alice", RegisteredClaims: valid}), http.StatusUnauthorized},
+		{"missing admin claim", signAdminJWT(t, signingKey, adminClaims{RegisteredClaims: valid}), http.StatusUnauthorized},
+		{"missing expiry", signAdminJWT(t, signingKey, adminClaims{Admin: "alice"}), http.StatusUnauthorized},
+		{"shared secret", adminSecretKey, http.StatusUnauthorized},
+	}
+
+	router := setupRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/admin/requests", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestAdminJWTIdentity(t *testing.T) {
+	isolatePendingRequests(t)
+	signingKey, publicPEM := generateJWTKey(t)
+	enableJWTAuth(t, publicPEM)
+	originalApprovals := requiredApprovals
+	requiredApprovals = 2
+	defer func() { requiredApprovals = originalApprovals }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+	token := signAdminJWT(t, signingKey, adminClaims{
+		Admin:            "alice",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+
+	// The token identifies the admin, a spoofed header cannot count twice
+	for _, header := range []string{"", "mallory"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(adminIDHeader, header)
+		router.ServeHTTP(w, req)
+		if header == "" {
+			assert.Equal(t, http.StatusAccepted, w.Code)
+		} else {
+			assert.Equal(t, http.StatusConflict, w.Code)
+		}
+	}
+	assert.Equal(t, []string{"alice"}, getTestRequest(t, reqID).ApprovedBy)
+}
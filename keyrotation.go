@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// generatedKeyBytes is the size of keys generated on rotation, before base64
+const generatedKeyBytes = 32
+
+// Set replaces the key configured for serverID
+func (s *keyStore) Set(serverID, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[serverID] = key
+}
+
+// generateKey returns a random base64 key for a rotation without a given key
+func generateKey() (string, error) {
+	key := make([]byte, generatedKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// auditKeyRotation records that the admin of c replaced the key of serverID.
+// The key itself is never logged.
+func auditKeyRotation(serverID string, c *gin.Context) {
+	entry := auditEvent{
+		Time:     time.Now().UTC(),
+		Event:    auditKeyRotated,
+		ServerID: serverID,
+		Admin:    adminID(c),
+		AdminIP:  c.ClientIP(),
+	}
+	if err := auditLog.Log(entry); err != nil {
+		slog.Error("audit log write failed", "server_id", serverID, "error", err)
+	}
+}
+
+// handleAdminRotateKey replaces the static key released to a server, either
+// with the given key or a generated one returned once in the response. Keys
+// already fetched are unaffected, later approved fetches get the new key. The
+// rotation lives in memory, KEYS_FILE or KEY_<server_id> must be updated too
+// before a restart. It is refused with a shared store, the other instances
+// would keep serving the old key.
+func handleAdminRotateKey(c *gin.Context) {
+	serverID := c.Param("server_id")
+	var json struct {
+		Key      string `json:"key"`
+		Generate bool   `json:"generate"`
+		Confirm  bool   `json:"confirm"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if !json.Confirm {
+		respondError(c, http.StatusBadRequest, errCodeConfirmationRequired, "Key rotation requires \"confirm\": true")
+		return
+	}
+	if (json.Key == "") == !json.Generate {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Give either a key or \"generate\": true")
+		return
+	}
+	if err := validateServerID(serverID); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid server_id: %v", err))
+		return
+	}
+	if !inAdminScope(c, serverID) {
+		respondOutOfScope(c)
+		return
+	}
+	if keyProvider != nil {
+		respondError(c, http.StatusConflict, errCodeConflict, "Keys are served by KEY_PROVIDER, rotate them there")
+		return
+	}
+	if storeShared() {
+		respondError(c, http.StatusConflict, errCodeConflict, "Keys cannot be rotated on one instance of a shared store, update KEYS_FILE or KEY_<server_id> on every instance")
+		return
+	}
+	if _, ok := serverKeys.Get(serverID); !ok {
+		respondError(c, http.StatusNotFound, errCodeKeyNotConfigured, "No key configured for server")
+		return
+	}
+
+	key := json.Key
+	if json.Generate {
+		var err error
+		if key, err = generateKey(); err != nil {
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Could not generate a key")
+			return
+		}
+	}
+	stored := key
+	if masterKey != nil {
+		var err error
+		if stored, err = encryptKey(masterKey, serverID, key); err != nil {
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Could not encrypt the key")
+			return
+		}
+	}
+	serverKeys.Set(serverID, stored)
+	auditKeyRotation(serverID, c)
+
+	slog.Warn("key rotated", "server_id", serverID, "admin", adminID(c), "client_ip", c.ClientIP())
+	response := gin.H{"message": fmt.Sprintf("Key for %s rotated.", serverID), "server_id": serverID}
+	if json.Generate {
+		response["key"] = key
+	}
+	c.JSON(http.StatusOK, response)
+}
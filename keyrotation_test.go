@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rotateKeyCall puts body to the key rotation endpoint of serverID
+func rotateKeyCall(router http.Handler, serverID, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/admin/keys/"+serverID, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// isolateServerKeys gives the test its own copy of the static keys
+func isolateServerKeys(t *testing.T) {
+	t.Helper()
+	original := serverKeys
+	serverKeys = newKeyStore(map[string]string{"test-server": "test-decryption-key"})
+	t.Cleanup(func() { serverKeys = original })
+}
+
+func TestRotateKey(t *testing.T) {
+	isolatePendingRequests(t)
+	isolateServerKeys(t)
+	buf := captureAudit(t)
+	router := setupRouter()
+
+	w := rotateKeyCall(router, "test-server", `{"key":"rotated-key"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "rotation requires confirmation")
+
+	w = rotateKeyCall(router, "test-server", `{"key":"rotated-key","confirm":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "rotated-key", "a given key is not echoed")
+
+	reqID := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+	code, response := fetchTestKey(t, router, reqID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "rotated-key", response["key"])
+
+	events := auditEvents(t, buf)
+	require.NotEmpty(t, events)
+	assert.Equal(t, auditKeyRotated, events[0].Event)
+	assert.Equal(t, "test-server", events[0].ServerID)
+	assert.NotContains(t, buf.String(), "rotated-key", "the key is never audit logged")
+}
+
+func TestRotateKeyGenerate(t *testing.T) {
+	isolateServerKeys(t)
+	router := setupRouter()
+
+	w := rotateKeyCall(router, "test-server", `{"generate":true,"confirm":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response["key"], "a generated key is returned once")
+
+	key, ok := serverKeys.Get("test-server")
+	require.True(t, ok)
+	assert.Equal(t, response["key"], key)
+}
+
+func TestRotateKeyRejected(t *testing.T) {
+	isolateServerKeys(t)
+	router := setupRouter()
+
+	tests := []struct {
+		name     string
+		serverID string
+		body     string
+		wantCode int
+	}{
+		{"key and generate", "test-server", `{"key":"k","generate":true,"confirm":true}`, http.StatusBadRequest},
+		{"neither key nor generate", "test-server", `{"confirm":true}`, http.StatusBadRequest},
+		{"unknown server", "rogue", `{"key":"k","confirm":true}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, rotateKeyCall(router, tt.serverID, tt.body).Code)
+		})
+	}
+	key, _ := serverKeys.Get("test-server")
+	assert.Equal(t, "test-decryption-key", key, "rejected rotations leave the key alone")
+
+	originalProvider := keyProvider
+	keyProvider = &vaultKeyProvider{}
+	defer func() { keyProvider = originalProvider }()
+	assert.Equal(t, http.StatusConflict, rotateKeyCall(router, "test-server", `{"key":"k","confirm":true}`).Code)
+}
+
+func TestRotateKeySharedStore(t *testing.T) {
+	shared, _ := newTestRedisStore(t)
+	original := store
+	store = shared
+	defer func() { store = original }()
+	router := setupRouter()
+
+	w := rotateKeyCall(router, "test-server", `{"key":"k","confirm":true}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "every instance")
+	key, _ := serverKeys.Get("test-server")
+	assert.Equal(t, "test-decryption-key", key, "the other instances would keep the old key")
+}
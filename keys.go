@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// keyEnvPrefix is the prefix of environment variables holding per-server keys,
+// KEY_<server_id>=<key>
+const keyEnvPrefix = "KEY_"
+
+// keyEnvReserved are settings sharing keyEnvPrefix, they configure no server
+var keyEnvReserved = []string{"KEY_PROVIDER"}
+
+// Key providers selectable via KEY_PROVIDER
+const (
+	keyProviderStatic = "static"
+	keyProviderVault  = "vault"
+)
+
+// errKeyNotFound is returned by a KeyProvider that has no key for the server
+var errKeyNotFound = errors.New("no key configured for server")
+
+// KeyProvider supplies the key released to a server once its request is approved
+type KeyProvider interface {
+	Key(ctx context.Context, serverID string) (string, error)
+}
+
+// keyProvider is the source of released keys, nil uses the static serverKeys
+var keyProvider KeyProvider
+
+// releasedKey returns the key for serverID from the configured provider.
+// Static keys are kept encrypted when MASTER_KEY is set and only decrypted here.
+func releasedKey(ctx context.Context, serverID string) (string, error) {
+	if keyProvider == nil {
+		key, err := serverKeys.Key(serverID)
+		if err != nil || masterKey == nil {
+			return key, err
+		}
+		return decryptKey(masterKey, serverID, key)
+	}
+	return keyProvider.Key(ctx, serverID)
+}
+
+// checkEncryptedKeys verifies every static key decrypts under master, so a
+// wrong MASTER_KEY or a plaintext entry fails at startup, not on release
+func checkEncryptedKeys(keys *keyStore, master []byte) error {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	for serverID, key := range keys.keys {
+		if _, err := decryptKey(master, serverID, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyStore maps server IDs to the decryption keys released to them
+type keyStore struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+func newKeyStore(keys map[string]string) *keyStore {
+	return &keyStore{keys: keys}
+}
+
+// Get returns the key configured for serverID
+func (s *keyStore) Get(serverID string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[serverID]
+	return key, ok
+}
+
+// Key implements KeyProvider for the static keys
+func (s *keyStore) Key(serverID string) (string, error) {
+	key, ok := s.Get(serverID)
+	if !ok {
+		return "", errKeyNotFound
+	}
+	return key, nil
+}
+
+// loadKeyStore builds the key store from an optional JSON file mapping
+// server_id to key and from KEY_<server_id> entries in environ, except the
+// keyEnvReserved settings. Environment entries take precedence over the file.
+func loadKeyStore(path string, environ []string) (*keyStore, error) {
+	environ = slices.DeleteFunc(slices.Clone(environ), func(entry string) bool {
+		name, _, _ := strings.Cut(entry, "=")
+		return slices.Contains(keyEnvReserved, name)
+	})
+	keys, err := loadServerSecrets(path, keyEnvPrefix, "keys file", "key", environ)
+	if err != nil {
+		return nil, err
+	}
+	return newKeyStore(keys), nil
+}
+
+// loadServerSecrets reads per-server values from an optional JSON file mapping
+// server_id to value and from <prefix><server_id> entries in environ, which
+// take precedence. file and value name them in errors.
+func loadServerSecrets(path, prefix, file, value string, environ []string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", file, err)
+		}
+		if err := json.Unmarshal(data, &secrets); err != nil {
+			return nil, fmt.Errorf("parsing %s %s: %v", file, path, err)
+		}
+	}
+
+	for _, entry := range environ {
+		name, secret, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if serverID := strings.TrimPrefix(name, prefix); serverID != "" {
+			secrets[serverID] = secret
+		}
+	}
+
+	for serverID, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("empty %s configured for server %q", value, serverID)
+		}
+	}
+
+	return secrets, nil
+}
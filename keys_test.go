@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"darkstar": "file-key", "nova": "nova-key"}`), 0o600))
+
+	store, err := loadKeyStore(path, []string{"KEY_darkstar=env-key", "KEY_pulsar=pulsar-key", "HOME=/root"})
+	assert.NoError(t, err)
+
+	key, ok := store.Get("darkstar")
+	assert.True(t, ok)
+	assert.Equal(t, "env-key", key, "environment overrides the keys file")
+
+	key, ok = store.Get("nova")
+	assert.True(t, ok)
+	assert.Equal(t, "nova-key", key)
+
+	key, ok = store.Get("pulsar")
+	assert.True(t, ok)
+	assert.Equal(t, "pulsar-key", key)
+
+	_, ok = store.Get("unknown-server")
+	assert.False(t, ok)
+}
+
+func TestLoadKeyStoreSkipsKeyProvider(t *testing.T) {
+	store, err := loadKeyStore("", []string{"KEY_PROVIDER=static", "KEY_darkstar=env-key"})
+	assert.NoError(t, err)
+
+	_, ok := store.Get("PROVIDER")
+	assert.False(t, ok, "KEY_PROVIDER selects the provider, it is no server key")
+	_, ok = store.Get("darkstar")
+	assert.True(t, ok)
+}
+
+func TestLoadKeyStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed.json")
+	assert.NoError(t, os.WriteFile(malformed, []byte(`{"darkstar": `), 0o600))
+
+	_, err := loadKeyStore(filepath.Join(dir, "missing.json"), nil)
+	assert.Error(t, err)
+
+	_, err = loadKeyStore(malformed, nil)
+	assert.Error(t, err)
+
+	_, err = loadKeyStore("", []string{"KEY_darkstar="})
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Environment variables of systemd socket activation, see sd_listen_fds(3)
+const (
+	listenFDsEnv     = "LISTEN_FDS"
+	listenPIDEnv     = "LISTEN_PID"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart is the first descriptor passed by socket activation
+var listenFDsStart = 3
+
+// socketActivated reports whether a listening socket was passed to this
+// process by systemd socket activation, or anything following its protocol.
+// A LISTEN_PID of another process means the sockets are not ours.
+func socketActivated() bool {
+	if os.Getenv(listenFDsEnv) == "" {
+		return false
+	}
+	pid := os.Getenv(listenPIDEnv)
+	return pid == "" || pid == strconv.Itoa(os.Getpid())
+}
+
+// inheritedListener returns the listener passed as the first socket
+// activation descriptor. Further sockets are ignored.
+func inheritedListener() (net.Listener, error) {
+	count, err := strconv.Atoi(os.Getenv(listenFDsEnv))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid %s %q", listenFDsEnv, os.Getenv(listenFDsEnv))
+	}
+	if count > 1 {
+		slog.Warn("several sockets passed, serving on the first", "count", count)
+	}
+	// Processes we start must not take the sockets for theirs
+	os.Unsetenv(listenFDsEnv)
+	os.Unsetenv(listenPIDEnv)
+	os.Unsetenv(listenFDNamesEnv)
+
+	file := os.NewFile(uintptr(listenFDsStart), "listen-fd")
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("using passed socket %d: %w", listenFDsStart, err)
+	}
+	return ln, nil
+}
+
+// openListener returns the socket passed by socket activation, letting systemd
+// hold the port across restarts and bind privileged ports for us, and
+// otherwise listens on BIND_ADDRESS
+func openListener() (net.Listener, error) {
+	if socketActivated() {
+		return inheritedListener()
+	}
+	return net.Listen("tcp", bindAddress)
+}
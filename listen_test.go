@@ -0,0 +1,77 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passListener hands the socket of a new TCP listener to the process the way
+// socket activation does, and returns its address
+func passListener(t *testing.T) string {
+	t.Helper()
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	file, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	// A descriptor of our own, inheritedListener closes it once imported
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	file.Close()
+	tcp.Close()
+
+	original := listenFDsStart
+	listenFDsStart = fd
+	t.Cleanup(func() { listenFDsStart = original })
+	t.Setenv(listenFDsEnv, "1")
+	t.Setenv(listenPIDEnv, strconv.Itoa(os.Getpid()))
+	return tcp.Addr().String()
+}
+
+func TestSocketActivation(t *testing.T) {
+	address := passListener(t)
+	originalBind := bindAddress
+	bindAddress = ""
+	defer func() { bindAddress = originalBind }()
+
+	require.True(t, socketActivated())
+	ln, err := openListener()
+	require.NoError(t, err)
+	assert.Equal(t, address, ln.Addr().String())
+	assert.Empty(t, os.Getenv(listenFDsEnv), "the sockets are not passed on")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan error, 1)
+	go func() { serverDone <- runServer(ctx, newHTTPServer(setupRouter().Handler()), ln, time.Second) }()
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get("http://" + address + "/pingz")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "pong")
+
+	cancel()
+	require.NoError(t, <-serverDone)
+}
+
+func TestSocketActivationOtherProcess(t *testing.T) {
+	t.Setenv(listenFDsEnv, "1")
+	t.Setenv(listenPIDEnv, strconv.Itoa(os.Getpid()+1))
+	assert.False(t, socketActivated(), "sockets passed to another process are not ours")
+
+	t.Setenv(listenFDsEnv, "")
+	assert.False(t, socketActivated())
+}
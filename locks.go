@@ -0,0 +1,63 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// defaultLockShards is the number of shards requests are spread over
+const defaultLockShards = 32
+
+// shardedLock spreads per-request locking over a fixed set of RWMutexes keyed
+// by a hash of the request ID, so operations on different requests rarely
+// contend. Operations spanning all requests take every shard, in order.
+type shardedLock struct {
+	shards []sync.RWMutex
+}
+
+func newShardedLock(shards int) *shardedLock {
+	return &shardedLock{shards: make([]sync.RWMutex, shards)}
+}
+
+// shardIndex maps a request ID onto one of n shards
+func shardIndex(id string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(n))
+}
+
+func (l *shardedLock) shard(id string) *sync.RWMutex {
+	return &l.shards[shardIndex(id, len(l.shards))]
+}
+
+func (l *shardedLock) Lock(id string)    { l.shard(id).Lock() }
+func (l *shardedLock) Unlock(id string)  { l.shard(id).Unlock() }
+func (l *shardedLock) RLock(id string)   { l.shard(id).RLock() }
+func (l *shardedLock) RUnlock(id string) { l.shard(id).RUnlock() }
+
+// LockAll takes every shard for writing, always in the same order so that
+// concurrent callers cannot deadlock
+func (l *shardedLock) LockAll() {
+	for i := range l.shards {
+		l.shards[i].Lock()
+	}
+}
+
+func (l *shardedLock) UnlockAll() {
+	for i := len(l.shards) - 1; i >= 0; i-- {
+		l.shards[i].Unlock()
+	}
+}
+
+// RLockAll takes every shard for reading
+func (l *shardedLock) RLockAll() {
+	for i := range l.shards {
+		l.shards[i].RLock()
+	}
+}
+
+func (l *shardedLock) RUnlockAll() {
+	for i := len(l.shards) - 1; i >= 0; i-- {
+		l.shards[i].RUnlock()
+	}
+}
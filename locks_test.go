@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardIndex(t *testing.T) {
+	id := "550e8400-e29b-41d4-a716-446655440000"
+	assert.Equal(t, shardIndex(id, defaultLockShards), shardIndex(id, defaultLockShards), "stable for the same ID")
+	for i := 0; i < 100; i++ {
+		index := shardIndex(fmt.Sprintf("id-%d", i), defaultLockShards)
+		assert.GreaterOrEqual(t, index, 0)
+		assert.Less(t, index, defaultLockShards)
+	}
+}
+
+// approveAndGetKey approves reqID and fetches its key, returning both status codes
+func approveAndGetKey(router *gin.Engine, reqID string) (int, int) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	approveCode := w.Code
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/server/get-key", bytes.NewBufferString(`{"req_id": "`+reqID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return approveCode, w.Code
+}
+
+// Run with -race to check concurrent handlers on distinct requests
+func TestConcurrentApproveGetKey(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	ids := make([]string, 50)
+	for i := range ids {
+		ids[i] = createTestRequest(t, router, "test-server")
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			approveCode, getKeyCode := approveAndGetKey(router, id)
+			assert.Equal(t, http.StatusOK, approveCode)
+			assert.Equal(t, http.StatusOK, getKeyCode)
+		}(id)
+	}
+	// Store-wide operations run alongside the per-request ones
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cleanupExpiredRequests()
+		createTestRequest(t, router, "test-server")
+	}()
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.True(t, getTestRequest(t, id).Approved)
+	}
+}
+
+// latencyStore adds a fixed delay to every call, like a networked backend,
+// so that time spent holding a lock dominates the benchmark
+type latencyStore struct {
+	Store
+	delay time.Duration
+}
+
+func (s latencyStore) Save(req *Request) error {
+	time.Sleep(s.delay)
+	return s.Store.Save(req)
+}
+
+func (s latencyStore) Get(id string) (*Request, bool, error) {
+	time.Sleep(s.delay)
+	return s.Store.Get(id)
+}
+
+// Compares a single lock, equivalent to the former global mutex, with sharding
+func BenchmarkApproveGetKey(b *testing.B) {
+	originalWriter := gin.DefaultWriter
+	gin.DefaultWriter = io.Discard
+	defer func() { gin.DefaultWriter = originalWriter }()
+
+	for _, shards := range []int{1, defaultLockShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			originalLocks, originalStore := requestLocks, store
+			requestLocks, store = newShardedLock(shards), latencyStore{newMemoryStore(), 50 * time.Microsecond}
+			defer func() { requestLocks, store = originalLocks, originalStore }()
+			router := setupRouter()
+
+			ids := make([]string, 1024)
+			for i := range ids {
+				ids[i] = newRequestID()
+				store.Save(&Request{ID: ids[i], ServerID: "test-server", CreatedAt: time.Now()})
+			}
+
+			var counter atomic.Uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					approveAndGetKey(router, ids[counter.Add(1)%uint64(len(ids))])
+				}
+			})
+		})
+	}
+}
+
+// Run with -race: concurrent fetches of a one-time key must release it once
+func TestConcurrentOneTimeGetKey(t *testing.T) {
+	isolatePendingRequests(t)
+	originalOneTime := oneTimeKey
+	oneTimeKey = true
+	defer func() { oneTimeKey = originalOneTime }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+
+	var released, refused atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			switch code, _ := fetchTestKey(t, router, reqID); code {
+			case http.StatusOK:
+				released.Add(1)
+			case http.StatusGone:
+				refused.Add(1)
+			default:
+				t.Errorf("unexpected status %d", code)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), released.Load(), "the key is returned exactly once")
+	assert.Equal(t, int32(49), refused.Load())
+}
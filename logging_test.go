@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs makes setupLogging write to a buffer for the duration of the
+// test, restoring the default logger and the standard log output afterwards
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	original := slog.Default()
+	require.NoError(t, setupLogging(&buf))
+	t.Cleanup(func() {
+		slog.SetDefault(original)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return &buf
+}
+
+// logRecords decodes the captured JSON log lines
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), scanner.Text())
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	buf := captureLogs(t)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve-link?token=secret-token", nil)
+	req.Header.Set(requestIDHeader, "trace-123")
+	router.ServeHTTP(w, req)
+
+	var accessRecords []map[string]any
+	for _, record := range logRecords(t, buf) {
+		if record["msg"] == "http request" {
+			accessRecords = append(accessRecords, record)
+		}
+	}
+	require.Len(t, accessRecords, 1)
+	record := accessRecords[0]
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/admin/approve-link", record["path"])
+	assert.Equal(t, float64(w.Code), record["status"])
+	assert.Equal(t, "WARN", record["level"], "rejected calls log at warn")
+	assert.Equal(t, "trace-123", record["request_id"])
+	assert.Contains(t, record, "latency")
+	assert.Contains(t, record, "client_ip")
+	assert.NotContains(t, buf.String(), "secret-token", "the query is never logged")
+}
+
+func TestAccessLogLevel(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "info")
+	buf := captureLogs(t)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pingz", nil)
+	router.ServeHTTP(w, req)
+	assert.NotContains(t, buf.String(), "/pingz", "health probes log at debug")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/requests", nil)
+	req.Header.Set("Authorization", "Bearer wrong-key")
+	router.ServeHTTP(w, req)
+	assert.NotContains(t, buf.String(), "wrong-key", "credentials are never logged")
+	assert.Contains(t, buf.String(), `"msg":"rejected admin key"`)
+}
+
+func TestNewLogger(t *testing.T) {
+	_, err := newLogger(&bytes.Buffer{}, "xml", "")
+	assert.ErrorContains(t, err, "invalid LOG_FORMAT")
+	_, err = newLogger(&bytes.Buffer{}, "", "loud")
+	assert.ErrorContains(t, err, "invalid LOG_LEVEL")
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatText, "warn")
+	require.NoError(t, err)
+	logger.Info("dropped")
+	logger.Warn("kept")
+	assert.NotContains(t, buf.String(), "dropped")
+	assert.Contains(t, buf.String(), "msg=kept")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxLongPoll is used when MAX_LONGPOLL is not set
+const defaultMaxLongPoll = 60 * time.Second
+
+// decisionWaiters lets get-key long-polls block until an admin approves or
+// denies the request they are waiting on
+type decisionWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+var decisions = &decisionWaiters{waiters: make(map[string]map[chan struct{}]struct{})}
+
+// subscribe returns a channel that is closed when a decision is made on id
+func (d *decisionWaiters) subscribe(id string) chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch := make(chan struct{})
+	if d.waiters[id] == nil {
+		d.waiters[id] = make(map[chan struct{}]struct{})
+	}
+	d.waiters[id][ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes ch, it is safe to call after the channel was notified
+func (d *decisionWaiters) unsubscribe(id string, ch chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.waiters[id], ch)
+	if len(d.waiters[id]) == 0 {
+		delete(d.waiters, id)
+	}
+}
+
+// notify wakes every waiter on id
+func (d *decisionWaiters) notify(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch := range d.waiters[id] {
+		close(ch)
+	}
+	delete(d.waiters, id)
+}
+
+// count returns the number of active waiters on id
+func (d *decisionWaiters) count(id string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.waiters[id])
+}
+
+// parseLongPollWait parses the requested wait, capped at maxLongPoll
+func parseLongPollWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait %q", raw)
+	}
+	return min(wait, maxLongPoll), nil
+}
+
+// waitForDecision blocks until decision is closed, the wait or the request's
+// expiry elapses, or the client goes away. It returns false if the client is gone.
+func waitForDecision(ctx context.Context, decision <-chan struct{}, wait, untilExpiry time.Duration) bool {
+	waitTimer := time.NewTimer(wait)
+	defer waitTimer.Stop()
+	expiryTimer := time.NewTimer(untilExpiry)
+	defer expiryTimer.Stop()
+
+	select {
+	case <-decision:
+	case <-waitTimer.C:
+	case <-expiryTimer.C:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// longPollGetKey calls get-key with the given wait and returns the response
+// along with how long it blocked
+func longPollGetKey(router *gin.Engine, reqID, wait string) (*httptest.ResponseRecorder, time.Duration) {
+	w := httptest.NewRecorder()
+	jsonBody, _ := json.Marshal(map[string]string{"req_id": reqID})
+	req, _ := http.NewRequest("POST", "/server/get-key?wait="+wait, bytes.NewBuffer(jsonBody))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	return w, time.Since(start)
+}
+
+func TestLongPollApproved(t *testing.T) {
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+	}()
+
+	w, elapsed := longPollGetKey(router, reqID, "10s")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "test-decryption-key")
+	assert.Less(t, elapsed, 5*time.Second, "long-poll should return as soon as the request is approved")
+	assert.Zero(t, decisions.count(reqID))
+}
+
+func TestLongPollDenied(t *testing.T) {
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		adminCall(router, "GET", "/admin/deny/"+reqID, adminSecretKey)
+	}()
+
+	w, elapsed := longPollGetKey(router, reqID, "10s")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "denied")
+	assert.Less(t, elapsed, 5*time.Second)
+}
+
+func TestLongPollStillPending(t *testing.T) {
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	w, elapsed := longPollGetKey(router, reqID, "200ms")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), "pending")
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.Zero(t, decisions.count(reqID))
+}
+
+func TestLongPollCappedByMaxLongPoll(t *testing.T) {
+	originalMax := maxLongPoll
+	maxLongPoll = 100 * time.Millisecond
+	defer func() { maxLongPoll = originalMax }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	w, elapsed := longPollGetKey(router, reqID, "1h")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Less(t, elapsed, 5*time.Second)
+}
+
+func TestLongPollExpires(t *testing.T) {
+	router := setupRouter()
+	w := signedServerCall(router, "/server/request-key", `{"server_id":"test-server","ttl":"200ms"}`, "")
+	var created map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	reqID := created["request_id"].(string)
+
+	w, elapsed := longPollGetKey(router, reqID, "10s")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Less(t, elapsed, 5*time.Second, "long-poll should return when the request expires")
+}
+
+func TestLongPollInvalidWait(t *testing.T) {
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	w, _ := longPollGetKey(router, reqID, "soon")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWaitForDecisionClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, waitForDecision(ctx, make(chan struct{}), time.Hour, time.Hour))
+}
@@ -1,56 +1,398 @@
 package main
 
 import (
+	"context"
 	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	adminSecretKey  = os.Getenv("ADMIN_SECRET_KEY")
-	serverSecretKey = os.Getenv("SERVER_SECRET_KEY")
-	bindAddress     = os.Getenv("BIND_ADDRESS")
-	approvalTimeout = os.Getenv("APPROVAL_TIMEOUT")
+	adminSecretKey   = os.Getenv("ADMIN_SECRET_KEY")
+	adminSecretKeys  = parseAdminSecretKeys(os.Getenv("ADMIN_SECRET_KEYS"), adminSecretKey)
+	adminAuthMode    = os.Getenv("ADMIN_AUTH_MODE")
+	adminJWTPubKey   = os.Getenv("ADMIN_JWT_PUBKEY")
+	serverSecretKey  = os.Getenv("SERVER_SECRET_KEY")
+	bindAddress      = os.Getenv("BIND_ADDRESS")
+	denyReasonCodes  = parseDenyReasonCodes(os.Getenv("DENY_REASON_CODES"))
+	discoveryURL     = os.Getenv("DISCOVERY_URL")
+	evictionPolicy   = os.Getenv("EVICTION_POLICY")
+	metricsSecretKey = os.Getenv("METRICS_SECRET_KEY")
+	storeBackend     = os.Getenv("STORE_BACKEND")
+	sqlitePath       = os.Getenv("SQLITE_PATH")
+	redisAddr        = os.Getenv("REDIS_ADDR")
+	redisPassword    = os.Getenv("REDIS_PASSWORD")
+	tlsCertFile      = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile       = os.Getenv("TLS_KEY_FILE")
+	tlsMinVersion    = os.Getenv("TLS_MIN_VERSION")
+	keyProviderName  = os.Getenv("KEY_PROVIDER")
+	vaultAddr        = os.Getenv("VAULT_ADDR")
+	vaultToken       = os.Getenv("VAULT_TOKEN")
+	vaultPathPrefix  = os.Getenv("VAULT_PATH_PREFIX")
+	otlpEndpoint     = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
 )
 
+// Eviction policies selectable via EVICTION_POLICY
+const (
+	evictionPolicyNone   = "none"
+	evictionPolicyOldest = "oldest"
+)
+
+// parseAdminSecretKeys returns the accepted admin keys from the comma-separated
+// ADMIN_SECRET_KEYS, falling back to the single ADMIN_SECRET_KEY. Listing the old
+// and new key together allows rotation without downtime.
+func parseAdminSecretKeys(list, single string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 && single != "" {
+		keys = append(keys, single)
+	}
+	return keys
+}
+
+// defaultDenyReasonCodes is used when DENY_REASON_CODES is not set
+const defaultDenyReasonCodes = "not_recognized,off_schedule,security_hold,other"
+
+// parseDenyReasonCodes parses a comma-separated list of allowed denial reason codes
+func parseDenyReasonCodes(raw string) map[string]bool {
+	if raw == "" {
+		raw = defaultDenyReasonCodes
+	}
+	codes := make(map[string]bool)
+	for _, code := range strings.Split(raw, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes[code] = true
+		}
+	}
+	return codes
+}
+
 // Request represents a key request
 type Request struct {
+	ID        string
 	ServerID  string
 	Approved  bool
 	CreatedAt time.Time
-	IP        string // Added IP field to store the requester's IP address
+	ExpiresAt time.Time
+	IP        string    // Added IP field to store the requester's IP address
+	ReleaseAt time.Time // Approved keys are withheld until this time, zero releases immediately
+	Reason    string    // Justification given by the server, shown to admins
+	Priority  string    // low, normal or high, routes the notification
+
+	// Idempotency-Key header of the creating call, scopes deduplication
+	IdempotencyKey string
+
+	// Shared by the requests of one request-keys call, empty for request-key
+	BatchID string
+
+	// Labels given by the server to group requests, such as an environment
+	Tags []string
+
+	// Approval code admins may use instead of ID, set when SHORT_CODES is on
+	ShortCode string
+
+	// Set once the request's approval link was used, links work once
+	ApproveLinkUsed bool
+
+	// Span that created the request, later calls link to it when tracing
+	TraceID string
+	SpanID  string
+
+	// Optional https URL the decision is posted to, never the key
+	CallbackURL string
+
+	// Admins that approved so far, Approved is set once REQUIRED_APPROVALS is met
+	ApprovedBy []string
+
+	// Operational context from the approving admin, returned with the key
+	ApprovalNote string
+
+	// When the request was approved or denied, zero while undecided
+	DecidedAt time.Time
+
+	// When the key was first fetched, zero until then
+	FetchedAt time.Time
+
+	// Denied requests are kept until expiry so the server learns why
+	Denied         bool
+	DenyReason     string
+	DenyReasonCode string
+
+	// Requests of the server not announced since its last notification because
+	// of NOTIFY_COOLDOWN, set on the copy being announced and never stored
+	SuppressedNotifications int `json:"-"`
+
+	// Connection metadata for forensics, empty for plain HTTP
+	TLSVersion     string
+	TLSCipherSuite string
+	ClientCertCN   string
 }
 
-var (
-	mu              sync.Mutex
-	pendingRequests = make(map[string]*Request)
-)
+// setTLSMetadata records the TLS version, cipher suite and client certificate
+// CN of the connection on the request. A nil state (plain HTTP) leaves them empty.
+func (r *Request) setTLSMetadata(state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+	r.TLSVersion = tls.VersionName(state.Version)
+	r.TLSCipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) > 0 {
+		r.ClientCertCN = state.PeerCertificates[0].Subject.CommonName
+	}
+}
 
-// isRequestExpired checks if a request has expired
-func isRequestExpired(req *Request) bool {
-	approvalTimeout, err := time.ParseDuration(approvalTimeout)
-	if err != nil {
-		panic(err)
+// requestLocks serialises read-modify-write sequences on each stored request
+var requestLocks = newShardedLock(defaultLockShards)
+
+// newRequestID generates a secure random UUID for a request, replaceable in tests
+var newRequestID = func() string {
+	return uuid.New().String()
+}
+
+// maxRequestIDAttempts bounds regeneration when a generated ID is already in use
+const maxRequestIDAttempts = 5
+
+// generateRequestID returns an ID not used by any pending request, regenerating
+// on collision rather than overwriting an unrelated request. Each candidate is
+// checked under the locks lock takes for it, and the ID is returned with them
+// still held along with their release, so the caller's Save cannot race
+// another request given the same ID. Without an ID nothing is left locked.
+func generateRequestID(lock func(reqID string) func()) (string, func(), bool, error) {
+	for attempt := 0; attempt < maxRequestIDAttempts; attempt++ {
+		reqID := newRequestID()
+		unlock := lock(reqID)
+		_, exists, err := store.Get(reqID)
+		if err != nil {
+			unlock()
+			return "", nil, false, err
+		}
+		if !exists {
+			return reqID, unlock, true, nil
+		}
+		unlock()
+		slog.Warn("generated request ID collides with a pending request, regenerating", "request_id", reqID)
+	}
+	return "", nil, false, nil
+}
+
+// lockHeld is the lock of generateRequestID for callers already holding every lock
+func lockHeld(string) func() { return func() {} }
+
+// expiresAt returns when the request expires, fixed when it was created so later
+// APPROVAL_TIMEOUT changes do not move it. Requests stored before per-request
+// expiry existed fall back to the global approval timeout until cleanup pins it.
+func (r *Request) expiresAt() time.Time {
+	if r.ExpiresAt.IsZero() {
+		return r.CreatedAt.Add(approvalTimeout)
 	}
-	return time.Since(req.CreatedAt) > approvalTimeout
+	return r.ExpiresAt
+}
+
+// isRequestExpired checks if a request has expired against its stored expiry
+func isRequestExpired(req *Request) bool {
+	return time.Now().After(req.expiresAt())
 }
 
-// cleanupExpiredRequests removes expired requests
+// cleanupExpiredRequests removes expired requests and pins the expiry of
+// requests stored without one, so it no longer follows APPROVAL_TIMEOUT
 func cleanupExpiredRequests() {
-	mu.Lock()
-	defer mu.Unlock()
+	requestLocks.LockAll()
+	defer requestLocks.UnlockAll()
 
-	for id, req := range pendingRequests {
+	requests, err := store.List()
+	if err != nil {
+		slog.Error("cleanup listing requests failed", "error", err)
+		return
+	}
+	for _, req := range requests {
 		if isRequestExpired(req) {
-			delete(pendingRequests, id)
+			deleteExpiredRequest(req)
+			continue
+		}
+		if req.ExpiresAt.IsZero() {
+			req.ExpiresAt = req.expiresAt()
+			if err := store.Save(req); err != nil {
+				slog.Error("pinning request expiry failed", "request_id", req.ID, "error", err)
+			}
+		}
+	}
+	recentlyExpired.prune()
+	recentlyConsumed.prune()
+	notificationCooldowns.prune()
+	updatePendingGauge()
+}
+
+// deleteExpiredRequest removes an expired request found while handling a call.
+// Failures are only logged, the request is still reported as expired.
+func deleteExpiredRequest(req *Request) {
+	if err := store.Delete(req.ID); err != nil {
+		slog.Error("deleting expired request failed", "request_id", req.ID, "error", err)
+		return
+	}
+	recentlyExpired.add(req.ID)
+	audit(auditRequestExpired, req, nil)
+	requestsExpired.Inc()
+	if !req.Approved && !req.Denied {
+		observeDecision(req)
+	}
+	updatePendingGauge()
+}
+
+// storeUnavailableMessage is all a client learns of a failed store call
+const storeUnavailableMessage = "Storage temporarily unavailable, retry later"
+
+// storeRetryAfter is the Retry-After suggested when the store failed
+const storeRetryAfter = 5 * time.Second
+
+// logStoreError logs a storage failure on the call c, with credentials
+// redacted, and marks the store as failing for the readiness probe
+func logStoreError(c *gin.Context, err error) {
+	storeFailing.Store(true)
+	slog.Error("store error", "method", c.Request.Method, "path", c.Request.URL.Path, "error", redactStoreError(err))
+}
+
+// redactStoreError returns the message of err without the store credentials
+func redactStoreError(err error) string {
+	msg := err.Error()
+	if redisPassword != "" {
+		msg = strings.ReplaceAll(msg, redisPassword, "[redacted]")
+	}
+	return msg
+}
+
+// storeUnavailable logs a storage failure and returns the retryable 503 for
+// handlers returning their outcome, such as the admin decisions
+func storeUnavailable(c *gin.Context, err error) (int, string, string) {
+	logStoreError(c, err)
+	c.Header("Retry-After", retryAfterSeconds(storeRetryAfter))
+	return http.StatusServiceUnavailable, errCodeStoreUnavailable, storeUnavailableMessage
+}
+
+// respondStoreError logs a storage failure and responds with a retryable 503
+// without exposing backend details to the client
+func respondStoreError(c *gin.Context, err error) {
+	logStoreError(c, err)
+	c.Header("Retry-After", retryAfterSeconds(storeRetryAfter))
+	respondError(c, http.StatusServiceUnavailable, errCodeStoreUnavailable, storeUnavailableMessage)
+}
+
+// suggestedPollInterval returns how long clients should wait between polls
+// given the current number of pending requests, backing off under load
+func suggestedPollInterval(pending int) time.Duration {
+	switch {
+	case pending < 10:
+		return 1 * time.Second
+	case pending < 100:
+		return 2 * time.Second
+	case pending < 1000:
+		return 5 * time.Second
+	default:
+		return 10 * time.Second
+	}
+}
+
+// currentPollInterval is the poll interval hint for the cached request count
+func currentPollInterval() time.Duration {
+	return suggestedPollInterval(int(storedRequests.Load()))
+}
+
+// countLivePending returns how many requests are undecided and not expired.
+// Expired requests are ignored even before cleanup removes them.
+func countLivePending(requests []*Request) int {
+	pending := 0
+	for _, req := range requests {
+		if !req.Approved && !req.Denied && !isRequestExpired(req) {
+			pending++
+		}
+	}
+	return pending
+}
+
+// evictionEnabled reports whether EVICTION_POLICY evicts pending requests
+func evictionEnabled() bool {
+	return evictionPolicy == evictionPolicyOldest && evictionThreshold > 0
+}
+
+// planEviction picks the oldest undecided requests to evict so that, once
+// created are stored, no more than evictionThreshold undecided requests are
+// left. Decided and expired requests do not count. The requests being created
+// are never evicted, false means the threshold cannot be kept without them.
+func planEviction(requests, created []*Request) ([]*Request, bool) {
+	if !evictionEnabled() {
+		return nil, true
+	}
+	creating := make(map[string]bool, len(created))
+	for _, req := range created {
+		creating[req.ID] = true
+	}
+	pending := countLivePending(created)
+	var candidates []*Request
+	for _, req := range requests {
+		if creating[req.ID] || req.Approved || req.Denied || isRequestExpired(req) {
+			continue
+		}
+		pending++
+		candidates = append(candidates, req)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].CreatedAt.Before(candidates[j].CreatedAt)
+	})
+
+	var evicted []*Request
+	for _, req := range candidates {
+		if pending <= evictionThreshold {
+			break
+		}
+		evicted = append(evicted, req)
+		pending--
+	}
+	return evicted, pending <= evictionThreshold
+}
+
+// evictRequests removes the requests chosen by planEviction, waking their
+// long-polls and telling their servers through any callback URL. The caller
+// must hold every lock.
+func evictRequests(evicted []*Request) error {
+	for _, req := range evicted {
+		slog.Warn("evicted pending request", "request_id", req.ID, "server_id", req.ServerID, "threshold", evictionThreshold)
+		if err := store.Delete(req.ID); err != nil {
+			return err
 		}
+		audit(auditRequestEvicted, req, nil)
+		decisions.notify(req.ID)
+		sendEvictedCallbackAsync(req)
 	}
+	return nil
+}
+
+// respondNothingToEvict answers a creation the eviction policy cannot make room for
+func respondNothingToEvict(c *gin.Context) {
+	respondError(c, http.StatusServiceUnavailable, errCodeTooManyPending,
+		fmt.Sprintf("Too many pending requests and none can be evicted, the threshold is %d. Retry later.", evictionThreshold))
 }
 
 // requireSecretKey middleware validates the secret key in the Authorization header
@@ -58,17 +400,44 @@ func requireAdminSecretKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Authorization header is required")
 			c.Abort()
 			return
 		}
 
-		// Use constant time comparison to prevent timing attacks
-		if subtle.ConstantTimeCompare([]byte(authHeader), []byte("Bearer "+adminSecretKey)) != 1 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization key"})
+		if adminAuthMode == adminAuthJWT {
+			token, ok := strings.CutPrefix(authHeader, "Bearer ")
+			admin, err := verifyAdminJWT(token)
+			if !ok || err != nil {
+				slog.Warn("rejected admin token", "client_ip", c.ClientIP(), "error", err)
+				respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid authorization token")
+				c.Abort()
+				return
+			}
+			c.Set(adminContextKey, admin)
+			prefixes, scoped := adminScopes.Admins[admin]
+			restrictAdmin(c, prefixes, scoped)
+			c.Next()
+			return
+		}
+
+		// Use constant time comparison against every key, without stopping at
+		// the first match, to prevent timing attacks. No keys rejects everything.
+		match := 0
+		matchedKey := ""
+		for _, key := range adminSecretKeys {
+			if subtle.ConstantTimeCompare([]byte(authHeader), []byte("Bearer "+key)) == 1 {
+				match, matchedKey = 1, key
+			}
+		}
+		if match != 1 {
+			slog.Warn("rejected admin key", "client_ip", c.ClientIP())
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid authorization key")
 			c.Abort()
 			return
 		}
+		prefixes, scoped := adminScopes.Keys[matchedKey]
+		restrictAdmin(c, prefixes, scoped)
 
 		c.Next()
 	}
@@ -79,13 +448,14 @@ func requireServerSecretKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Authorization header is required")
 			c.Abort()
 			return
 		}
 		// Use constant time comparison to prevent timing attacks
 		if subtle.ConstantTimeCompare([]byte(authHeader), []byte("Bearer "+serverSecretKey)) != 1 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization key"})
+			slog.Warn("rejected server key", "client_ip", c.ClientIP())
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid authorization key")
 			c.Abort()
 			return
 		}
@@ -94,167 +464,1453 @@ func requireServerSecretKey() gin.HandlerFunc {
 }
 
 func handleAdminApproveRequest(c *gin.Context) {
-	reqID := c.Param("req_id")
+	span := startSpan(c, spanApprove)
+	defer endSpan(c, span)
 
-	// Validate UUID format
-	if _, err := uuid.Parse(reqID); err != nil {
-		c.String(http.StatusBadRequest, "Invalid request ID format")
+	reqID, ok := resolveRequestID(c, c.Param("req_id"))
+	if !ok {
 		return
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	if req, exists := pendingRequests[reqID]; exists {
-		if isRequestExpired(req) {
-			delete(pendingRequests, reqID)
-			c.String(http.StatusGone, "Request %s has expired.", reqID)
+	// Optional scheduled release, the key is withheld until then
+	var releaseAt time.Time
+	if raw := c.Query("release_at"); raw != "" {
+		var err error
+		if releaseAt, err = time.Parse(time.RFC3339, raw); err != nil {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid release_at format, expected RFC 3339")
 			return
 		}
-		req.Approved = true
-		c.String(http.StatusOK, "Request %s approved.", reqID)
-	} else {
-		c.String(http.StatusNotFound, "Request not found.")
+		if !releaseAt.After(time.Now()) {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "release_at must be in the future")
+			return
+		}
+	}
+
+	// Optional note for the server, returned alongside the key
+	note := sanitizeReason(c.Query("note"))
+	if utf8.RuneCountInString(note) > maxNoteLength {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Note exceeds %d characters", maxNoteLength))
+		return
+	}
+
+	approver := adminID(c)
+	if requiredApprovals > 1 && !verifiedAdmin(c) {
+		respondQuorumIdentityRequired(c)
+		return
+	}
+
+	status, code, message := approveRequest(c, span, reqID, approver, releaseAt, note)
+	respondDecision(c, status, code, message)
+}
+
+// approveRequest records approver's approval of the request and returns the
+// response status, error code, empty on success, and message. c is the admin
+// call, used for the audit log. A non-empty note replaces the one given by
+// earlier approvals.
+func approveRequest(c *gin.Context, span trace.Span, reqID, approver string, releaseAt time.Time, note string) (int, string, string) {
+	logKeyRequest(c, reqID)
+	requestLocks.Lock(reqID)
+	defer requestLocks.Unlock(reqID)
+
+	req, exists, err := store.Get(reqID)
+	if err != nil {
+		return storeUnavailable(c, err)
+	}
+	if !exists {
+		if recentlyExpired.contains(reqID) {
+			return http.StatusGone, errCodeRequestExpired, fmt.Sprintf("Request %s has expired.", reqID)
+		}
+		return http.StatusNotFound, errCodeRequestNotFound, "Request not found."
+	}
+	linkRequestSpan(span, req)
+	if !inAdminScope(c, req.ServerID) {
+		return http.StatusForbidden, errCodeOutOfScope, fmt.Sprintf("Request %s is outside your admin scope.", reqID)
+	}
+	if isRequestExpired(req) {
+		deleteExpiredRequest(req)
+		return http.StatusGone, errCodeRequestExpired, fmt.Sprintf("Request %s has expired.", reqID)
+	}
+	if req.Denied {
+		return http.StatusConflict, errCodeAlreadyDecided, fmt.Sprintf("Request %s has been denied.", reqID)
+	}
+	if req.Approved {
+		return http.StatusConflict, errCodeAlreadyApproved, fmt.Sprintf("Request %s has already been approved.", reqID)
+	}
+	// The key can only be fetched until the request expires
+	if !releaseAt.IsZero() && !releaseAt.Before(req.expiresAt()) {
+		return http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("release_at must be before request %s expires at %s.", reqID, req.expiresAt().Format(time.RFC3339))
+	}
+	remaining, ok := recordApproval(req, approver)
+	if !ok {
+		return http.StatusConflict, errCodeAlreadyApproved, fmt.Sprintf("Request %s has already been approved by %s.", reqID, approver)
+	}
+	// Only the approval completing the quorum schedules the release
+	if remaining == 0 && !releaseAt.IsZero() {
+		req.ReleaseAt = releaseAt
 	}
+	if note != "" {
+		req.ApprovalNote = note
+	}
+	if err := store.Save(req); err != nil {
+		return storeUnavailable(c, err)
+	}
+	if remaining > 0 {
+		audit(auditApprovalRecorded, req, c)
+		return http.StatusAccepted, "", fmt.Sprintf("Request %s approval recorded, %d more approval(s) required.", reqID, remaining)
+	}
+	audit(auditRequestApproved, req, c)
+	requestsApproved.Inc()
+	observeDecision(req)
+	decisions.notify(reqID)
+	sendCallbackAsync(req)
+	if !releaseAt.IsZero() {
+		return http.StatusOK, "", fmt.Sprintf("Request %s approved, key will be released at %s.", reqID, releaseAt.Format(time.RFC3339))
+	}
+	return http.StatusOK, "", fmt.Sprintf("Request %s approved.", reqID)
 }
 
 func handleAdminDenyRequest(c *gin.Context) {
-	reqID := c.Param("req_id")
+	reqID, ok := resolveRequestID(c, c.Param("req_id"))
+	if !ok {
+		return
+	}
 
-	// Validate UUID format
-	if _, err := uuid.Parse(reqID); err != nil {
-		c.String(http.StatusBadRequest, "Invalid request ID format")
+	// Optional structured reason code, "other" requires a free-text reason
+	reasonCode := c.Query("reason_code")
+	reason := c.Query("reason")
+	if reasonCode != "" && !denyReasonCodes[reasonCode] {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid reason code")
+		return
+	}
+	if reasonCode == "other" && strings.TrimSpace(reason) == "" {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Reason is required when reason code is other")
 		return
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
+	status, code, message := denyRequest(c, reqID, reasonCode, reason)
+	respondDecision(c, status, code, message)
+}
 
-	if req, exists := pendingRequests[reqID]; exists {
-		if isRequestExpired(req) {
-			delete(pendingRequests, reqID)
-			c.String(http.StatusGone, "Request %s has expired.", reqID)
-			return
+// denyRequest denies the request with the given reason and returns the
+// response status, error code, empty on success, and message. c is the admin
+// call, used for the audit log.
+func denyRequest(c *gin.Context, reqID, reasonCode, reason string) (int, string, string) {
+	logKeyRequest(c, reqID)
+	requestLocks.Lock(reqID)
+	defer requestLocks.Unlock(reqID)
+
+	req, exists, err := store.Get(reqID)
+	if err != nil {
+		return storeUnavailable(c, err)
+	}
+	if !exists {
+		if recentlyExpired.contains(reqID) {
+			return http.StatusGone, errCodeRequestExpired, fmt.Sprintf("Request %s has expired.", reqID)
 		}
-		delete(pendingRequests, reqID)
-		c.String(http.StatusOK, "Request %s denied and removed.", reqID)
-	} else {
-		c.String(http.StatusNotFound, "Request not found.")
+		return http.StatusNotFound, errCodeRequestNotFound, "Request not found."
+	}
+	if !inAdminScope(c, req.ServerID) {
+		return http.StatusForbidden, errCodeOutOfScope, fmt.Sprintf("Request %s is outside your admin scope.", reqID)
+	}
+	if isRequestExpired(req) {
+		deleteExpiredRequest(req)
+		return http.StatusGone, errCodeRequestExpired, fmt.Sprintf("Request %s has expired.", reqID)
+	}
+	req.Approved = false
+	req.Denied = true
+	req.DenyReason = reason
+	req.DenyReasonCode = reasonCode
+	req.DecidedAt = time.Now()
+	if err := store.Save(req); err != nil {
+		return storeUnavailable(c, err)
 	}
+	audit(auditRequestDenied, req, c)
+	requestsDenied.WithLabelValues(denyReasonLabel(req.DenyReasonCode)).Inc()
+	observeDecision(req)
+	decisions.notify(reqID)
+	sendCallbackAsync(req)
+	if reasonCode != "" {
+		return http.StatusOK, "", fmt.Sprintf("Request %s denied (reason code: %s).", reqID, reasonCode)
+	}
+	return http.StatusOK, "", fmt.Sprintf("Request %s denied.", reqID)
 }
 
-func handleServerRequestKey(c *gin.Context) {
-	var json struct {
-		ServerID string `json:"server_id"`
+// requestSummary is the admin-facing view of a request, it never includes the key
+type requestSummary struct {
+	RequestID string    `json:"request_id"`
+	ShortCode string    `json:"approval_code,omitempty"`
+	ServerID  string    `json:"server_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Approved  bool      `json:"approved"`
+	Denied    bool      `json:"denied"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason,omitempty"`
+	Priority  string    `json:"priority"`
+	BatchID   string    `json:"batch_id,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+}
+
+func newRequestSummary(req *Request) requestSummary {
+	return requestSummary{
+		RequestID: req.ID,
+		ShortCode: req.ShortCode,
+		ServerID:  req.ServerID,
+		CreatedAt: req.CreatedAt,
+		Approved:  req.Approved,
+		Denied:    req.Denied,
+		IP:        req.IP,
+		Reason:    req.Reason,
+		Priority:  req.priority(),
+		BatchID:   req.BatchID,
+		Tags:      req.Tags,
 	}
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+}
+
+// requestDetail is the admin-facing view of a single request, adding the
+// expiry and decision details the list leaves out
+type requestDetail struct {
+	requestSummary
+	ExpiresAt      time.Time  `json:"expires_at"`
+	Expired        bool       `json:"expired"`
+	ReleaseAt      *time.Time `json:"release_at,omitempty"`
+	ApprovedBy     []string   `json:"approved_by,omitempty"`
+	ApprovalNote   string     `json:"approval_note,omitempty"`
+	DenyReason     string     `json:"deny_reason,omitempty"`
+	DenyReasonCode string     `json:"deny_reason_code,omitempty"`
+}
+
+func handleAdminGetRequest(c *gin.Context) {
+	reqID, ok := resolveRequestID(c, c.Param("req_id"))
+	if !ok {
 		return
 	}
+	logKeyRequest(c, reqID)
 
-	// Generate a secure random UUID for the request
-	reqID := uuid.New().String()
+	requestLocks.RLock(reqID)
+	defer requestLocks.RUnlock(reqID)
 
-	mu.Lock()
-	pendingRequests[reqID] = &Request{
-		ServerID:  json.ServerID,
-		Approved:  false,
-		CreatedAt: time.Now(),
-		IP:        c.ClientIP(), // Store the client's IP address
+	req, exists, err := store.Get(reqID)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		if recentlyExpired.contains(reqID) {
+			respondError(c, http.StatusGone, errCodeRequestExpired, "Request has expired")
+			return
+		}
+		respondError(c, http.StatusNotFound, errCodeRequestNotFound, "Request not found")
+		return
+	}
+	if !inAdminScope(c, req.ServerID) {
+		respondOutOfScope(c)
+		return
 	}
-	mu.Unlock()
 
-	// Simulate sending a notification
-	c.JSON(http.StatusAccepted, gin.H{
-		"message":    "Request received. Awaiting approval. Request will expire in 5 minutes.",
-		"request_id": reqID,
-	})
+	detail := requestDetail{
+		requestSummary: newRequestSummary(req),
+		ExpiresAt:      req.expiresAt(),
+		Expired:        isRequestExpired(req),
+		ApprovedBy:     req.ApprovedBy,
+		ApprovalNote:   req.ApprovalNote,
+		DenyReason:     req.DenyReason,
+		DenyReasonCode: req.DenyReasonCode,
+	}
+	if !req.ReleaseAt.IsZero() {
+		detail.ReleaseAt = &req.ReleaseAt
+	}
+	c.JSON(http.StatusOK, detail)
 }
 
-func handleServerGetKey(c *gin.Context) {
+// handleAdminExtendRequest moves the expiry of an undecided request later, by
+// the body's "by" duration or EXTEND_DURATION, giving admins more time to
+// decide. A request may not live longer than MAX_REQUEST_LIFETIME in total.
+func handleAdminExtendRequest(c *gin.Context) {
+	reqID, ok := resolveRequestID(c, c.Param("req_id"))
+	if !ok {
+		return
+	}
+	logKeyRequest(c, reqID)
+
 	var json struct {
-		ReqID string `json:"req_id"`
+		By string `json:"by"`
 	}
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	if c.Request.ContentLength != 0 && !bindJSON(c, &json) {
 		return
 	}
+	by := extendDuration
+	if by == 0 {
+		by = approvalTimeout
+	}
+	if json.By != "" {
+		var err error
+		if by, err = time.ParseDuration(json.By); err != nil || by <= 0 {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid by, expected a positive duration such as 5m")
+			return
+		}
+	}
 
-	// Validate UUID format
-	if _, err := uuid.Parse(json.ReqID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID format"})
+	requestLocks.Lock(reqID)
+	defer requestLocks.Unlock(reqID)
+
+	req, exists, err := store.Get(reqID)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		if recentlyExpired.contains(reqID) {
+			respondError(c, http.StatusGone, errCodeRequestExpired, "Request has expired")
+			return
+		}
+		respondError(c, http.StatusNotFound, errCodeRequestNotFound, "Request not found")
+		return
+	}
+	if !inAdminScope(c, req.ServerID) {
+		respondOutOfScope(c)
+		return
+	}
+	if isRequestExpired(req) {
+		deleteExpiredRequest(req)
+		respondError(c, http.StatusGone, errCodeRequestExpired, "Request has expired")
+		return
+	}
+	if req.Approved || req.Denied {
+		respondError(c, http.StatusConflict, errCodeAlreadyDecided, "Request has already been decided")
 		return
 	}
+	expiresAt := req.expiresAt().Add(by)
+	if expiresAt.Sub(req.CreatedAt) > maxRequestLifetime {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Extension exceeds the maximum request lifetime of %s", maxRequestLifetime))
+		return
+	}
+	req.ExpiresAt = expiresAt
+	if err := store.Save(req); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	audit(auditRequestExtended, req, c)
+	c.JSON(http.StatusOK, gin.H{"request_id": req.ID, "expires_at": req.ExpiresAt})
+}
 
-	mu.Lock()
-	defer mu.Unlock()
+// Page size of /admin/requests when no limit is given, and the largest allowed
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
 
-	if req, exists := pendingRequests[json.ReqID]; exists {
-		if isRequestExpired(req) {
-			delete(pendingRequests, json.ReqID)
-			c.JSON(http.StatusGone, gin.H{"error": "Request has expired"})
-			return
+// totalCountHeader carries the number of matching requests across all pages
+const totalCountHeader = "X-Total-Count"
+
+// listPage parses the limit and offset query parameters of a listing
+func listPage(c *gin.Context) (limit, offset int, ok bool) {
+	limit = defaultListLimit
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > maxListLimit {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid limit, expected 1 to %d", maxListLimit))
+			return 0, 0, false
 		}
-		if req.Approved {
-			c.JSON(http.StatusOK, gin.H{"key": "your-decryption-key"})
-		} else {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Request not approved yet"})
+	}
+	if raw := c.Query("offset"); raw != "" {
+		var err error
+		if offset, err = strconv.Atoi(raw); err != nil || offset < 0 {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid offset, expected a non-negative integer")
+			return 0, 0, false
 		}
-	} else {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
 	}
+	return limit, offset, true
 }
 
-func setupRouter() *gin.Engine {
-	router := gin.New()
-
-	router.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		// your custom format
-		return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
-			param.ClientIP,
-			param.TimeStamp.Format(time.RFC1123),
-			param.Method,
-			param.Path,
-			param.Request.Proto,
-			param.StatusCode,
-			param.Latency,
-			param.Request.UserAgent(),
-			param.ErrorMessage,
-		)
-	}))
-
-	router.Use(gin.Recovery())
-
-	// Start cleanup goroutine
-	go func() {
-		for {
-			time.Sleep(60 * time.Second) // Run cleanup every minute
-			cleanupExpiredRequests()
+// handleAdminListRequests returns one page of the non-expired requests, newest
+// first, with the number matching across all pages in X-Total-Count
+func handleAdminListRequests(c *gin.Context) {
+	// Optional approved=true|false filter
+	var approvedFilter *bool
+	if raw := c.Query("approved"); raw != "" {
+		approved, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid approved filter, expected true or false")
+			return
 		}
-	}()
+		approvedFilter = &approved
+	}
+	// Optional batch_id filter, the requests of one request-keys call
+	batchID := c.Query("batch_id")
+	// Optional tag filter, the requests carrying that tag
+	tag := c.Query("tag")
+	limit, offset, ok := listPage(c)
+	if !ok {
+		return
+	}
 
-	// Protected endpoints require secret key
-	adminProtected := router.Group("/admin/", requireAdminSecretKey())
-	serverProtected := router.Group("/server/", requireServerSecretKey())
+	requestLocks.RLockAll()
+	defer requestLocks.RUnlockAll()
+
+	stored, err := store.List()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	requests := []requestSummary{}
+	for _, req := range stored {
+		if isRequestExpired(req) {
+			continue
+		}
+		if approvedFilter != nil && req.Approved != *approvedFilter {
+			continue
+		}
+		if batchID != "" && req.BatchID != batchID {
+			continue
+		}
+		if tag != "" && !slices.Contains(req.Tags, tag) {
+			continue
+		}
+		if !inAdminScope(c, req.ServerID) {
+			continue
+		}
+		requests = append(requests, newRequestSummary(req))
+	}
 
-	router.GET("/pingz", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+	// Newest first, ties broken by ID so pages are consistent across calls
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].CreatedAt.Equal(requests[j].CreatedAt) {
+			return requests[i].CreatedAt.After(requests[j].CreatedAt)
+		}
+		return requests[i].RequestID < requests[j].RequestID
 	})
 
-	// Endpoint to receive key requests
-	serverProtected.POST("/request-key", handleServerRequestKey)
-	// Endpoint to approve a request (protected)
-	adminProtected.GET("/approve/:req_id", handleAdminApproveRequest)
-	// Endpoint to deny a request (protected)
-	adminProtected.GET("/deny/:req_id", handleAdminDenyRequest)
-	// Endpoint to get the decryption key
-	serverProtected.POST("/get-key", handleServerGetKey)
+	c.Header(totalCountHeader, strconv.Itoa(len(requests)))
+	start := min(offset, len(requests))
+	end := min(start+limit, len(requests))
+	c.JSON(http.StatusOK, requests[start:end])
+}
 
-	return router
+// handleAdminPurgeRequests removes every request, whatever its state, under
+// every lock. It requires confirm=true and returns how many were removed.
+// The pending gauge follows the store, the counters stay monotonic: Prometheus
+// reads a counter that drops as a process restart.
+func handleAdminPurgeRequests(c *gin.Context) {
+	if confirm, _ := strconv.ParseBool(c.Query("confirm")); !confirm {
+		respondError(c, http.StatusBadRequest, errCodeConfirmationRequired, "Purging requires confirm=true")
+		return
+	}
+	if adminScoped(c) {
+		respondError(c, http.StatusForbidden, errCodeForbidden, "Purging requires an admin without a scope")
+		return
+	}
+
+	requestLocks.LockAll()
+	defer requestLocks.UnlockAll()
+
+	requests, err := store.List()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	purged := 0
+	for _, req := range requests {
+		if err := store.Delete(req.ID); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		audit(auditRequestPurged, req, c)
+		decisions.notify(req.ID)
+		purged++
+	}
+	updatePendingGauge()
+
+	slog.Warn("purged requests", "count", purged, "admin", adminID(c), "client_ip", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
 }
 
-func main() {
+func handleAdminApprovePrefix(c *gin.Context) {
+	var json struct {
+		Prefix  string `json:"prefix" binding:"required"`
+		Confirm bool   `json:"confirm"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if !json.Confirm {
+		respondError(c, http.StatusBadRequest, errCodeConfirmationRequired, "Bulk approval requires \"confirm\": true")
+		return
+	}
 
-	router := setupRouter()
-	router.Run(bindAddress) // Start server on port 8080
+	bulkApprove(c, func(req *Request) bool {
+		return strings.HasPrefix(req.ServerID, json.Prefix)
+	}, slog.String("prefix", json.Prefix))
+}
+
+// handleAdminApproveAll approves every pending request, or only those of
+// server_id and of batch_id when given
+func handleAdminApproveAll(c *gin.Context) {
+	var json struct {
+		ServerID string `json:"server_id"`
+		BatchID  string `json:"batch_id"`
+		Confirm  bool   `json:"confirm"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if !json.Confirm {
+		respondError(c, http.StatusBadRequest, errCodeConfirmationRequired, "Bulk approval requires \"confirm\": true")
+		return
+	}
+
+	bulkApprove(c, func(req *Request) bool {
+		return (json.ServerID == "" || req.ServerID == json.ServerID) && (json.BatchID == "" || req.BatchID == json.BatchID)
+	}, slog.String("server_id", json.ServerID), slog.String("batch_id", json.BatchID))
+}
+
+// handleAdminApproveByServer approves the pending request of server_id when it
+// is the only one, for admins who know the server but not the request ID.
+// Several candidates are listed for the admin to pick from instead.
+func handleAdminApproveByServer(c *gin.Context) {
+	span := startSpan(c, spanApprove)
+	defer endSpan(c, span)
+
+	var json struct {
+		ServerID string `json:"server_id" binding:"required"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if !inAdminScope(c, json.ServerID) {
+		respondOutOfScope(c)
+		return
+	}
+	approver := adminID(c)
+	if requiredApprovals > 1 && !verifiedAdmin(c) {
+		respondQuorumIdentityRequired(c)
+		return
+	}
+
+	requests, err := store.List()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	candidates := []string{}
+	for _, req := range requests {
+		if req.ServerID == json.ServerID && !req.Approved && !req.Denied && !isRequestExpired(req) {
+			candidates = append(candidates, req.ID)
+		}
+	}
+	sort.Strings(candidates)
+
+	switch len(candidates) {
+	case 0:
+		respondError(c, http.StatusNotFound, errCodeRequestNotFound, "No pending request for server")
+	case 1:
+		// approveRequest re-checks the request under its lock
+		status, code, message := approveRequest(c, span, candidates[0], approver, time.Time{}, "")
+		if code != "" {
+			respondError(c, status, code, message)
+			return
+		}
+		c.JSON(status, gin.H{"request_id": candidates[0], "message": message})
+	default:
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      apiError{Code: errCodeMultipleCandidates, Message: "Several pending requests for server, approve one by request_id"},
+			"candidates": candidates,
+		})
+	}
+}
+
+// bulkApprove approves every pending request matching match in one step under
+// every lock. With a quorum it counts as one approval by the calling admin.
+// filter describes the selection for the log.
+func bulkApprove(c *gin.Context, match func(*Request) bool, filter ...slog.Attr) {
+	approver := adminID(c)
+	if requiredApprovals > 1 && !verifiedAdmin(c) {
+		respondQuorumIdentityRequired(c)
+		return
+	}
+
+	requestLocks.LockAll()
+	defer requestLocks.UnlockAll()
+
+	requests, err := store.List()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	approved := []string{}
+	pending := []string{} // approval recorded, waiting for more admins
+	for _, req := range requests {
+		if isRequestExpired(req) {
+			deleteExpiredRequest(req)
+			continue
+		}
+		if !req.Approved && !req.Denied && match(req) && inAdminScope(c, req.ServerID) {
+			if _, ok := recordApproval(req, approver); !ok {
+				continue
+			}
+			if err := store.Save(req); err != nil {
+				respondStoreError(c, err)
+				return
+			}
+			if !req.Approved {
+				audit(auditApprovalRecorded, req, c)
+				pending = append(pending, req.ID)
+				continue
+			}
+			audit(auditRequestApproved, req, c)
+			requestsApproved.Inc()
+			observeDecision(req)
+			decisions.notify(req.ID)
+			sendCallbackAsync(req)
+			approved = append(approved, req.ID)
+		}
+	}
+	sort.Strings(approved)
+	sort.Strings(pending)
+
+	args := make([]any, 0, len(filter)+8)
+	for _, attr := range filter {
+		args = append(args, attr)
+	}
+	args = append(args, "approved", len(approved), "pending", len(pending), "admin", approver, "client_ip", c.ClientIP())
+	slog.Info("bulk approval", args...)
+	c.JSON(http.StatusOK, gin.H{"approved": approved, "count": len(approved), "pending_quorum": pending})
+}
+
+// handleAdminDenyAll denies every pending request, or only those of server_id
+// and of batch_id when given, in one step under every lock
+func handleAdminDenyAll(c *gin.Context) {
+	var json struct {
+		ServerID   string `json:"server_id"`
+		BatchID    string `json:"batch_id"`
+		ReasonCode string `json:"reason_code"`
+		Reason     string `json:"reason"`
+		Confirm    bool   `json:"confirm"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if !json.Confirm {
+		respondError(c, http.StatusBadRequest, errCodeConfirmationRequired, "Bulk denial requires \"confirm\": true")
+		return
+	}
+	if json.ReasonCode != "" && !denyReasonCodes[json.ReasonCode] {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid reason code")
+		return
+	}
+	if json.ReasonCode == "other" && strings.TrimSpace(json.Reason) == "" {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Reason is required when reason code is other")
+		return
+	}
+
+	requestLocks.LockAll()
+	defer requestLocks.UnlockAll()
+
+	requests, err := store.List()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	denied := []string{}
+	for _, req := range requests {
+		if isRequestExpired(req) {
+			deleteExpiredRequest(req)
+			continue
+		}
+		if req.Approved || req.Denied || (json.ServerID != "" && req.ServerID != json.ServerID) || (json.BatchID != "" && req.BatchID != json.BatchID) || !inAdminScope(c, req.ServerID) {
+			continue
+		}
+		req.Denied = true
+		req.DenyReason = json.Reason
+		req.DenyReasonCode = json.ReasonCode
+		req.DecidedAt = time.Now()
+		if err := store.Save(req); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		audit(auditRequestDenied, req, c)
+		requestsDenied.WithLabelValues(denyReasonLabel(req.DenyReasonCode)).Inc()
+		observeDecision(req)
+		decisions.notify(req.ID)
+		sendCallbackAsync(req)
+		denied = append(denied, req.ID)
+	}
+	sort.Strings(denied)
+
+	slog.Info("bulk denial", "server_id", json.ServerID, "batch_id", json.BatchID, "denied", len(denied), "admin", adminID(c), "client_ip", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"denied": denied, "count": len(denied)})
+}
+
+// keyRequestOptions are the request-key fields besides the server, shared by
+// single and batch requests
+type keyRequestOptions struct {
+	TTL         string   `json:"ttl"`
+	Reason      string   `json:"reason"`
+	CallbackURL string   `json:"callback_url"`
+	Priority    string   `json:"priority"`
+	Tags        []string `json:"tags"`
+}
+
+// checkServerID responds with 400 and returns false unless serverID is valid
+// and, with KNOWN_SERVER_IDS, known
+func checkServerID(c *gin.Context, serverID string) bool {
+	if err := validateServerID(serverID); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid server_id: %v", err))
+		return false
+	}
+	if len(knownServerIDs) > 0 && !knownServerIDs[serverID] {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Unknown server_id")
+		return false
+	}
+	return true
+}
+
+// allowKeyRequest takes a rate limit token for the calling IP and each of the
+// servers, responding with 429 and returning false if any has none left
+func allowKeyRequest(c *gin.Context, serverIDs ...string) bool {
+	if requestLimiter == nil {
+		return true
+	}
+	keys := []string{"ip:" + c.ClientIP()}
+	for _, serverID := range serverIDs {
+		keys = append(keys, "server:"+serverID)
+	}
+	if ok, wait := requestLimiter.allow(keys...); !ok {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		respondError(c, http.StatusTooManyRequests, errCodeRateLimited, "Too many requests, retry later")
+		return false
+	}
+	return true
+}
+
+// newKeyRequest validates opts and returns a request built from them and the
+// calling connection, without a server or ID yet. It responds with 400 and
+// returns false if an option is invalid.
+func newKeyRequest(c *gin.Context, opts keyRequestOptions) (*Request, bool) {
+	// Optional per-request expiry, defaults to the global approval timeout
+	ttl := approvalTimeout
+	if opts.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(opts.TTL); err != nil || ttl <= 0 {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid ttl, expected a positive duration such as 2m")
+			return nil, false
+		}
+		if ttl > maxRequestTTL {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("ttl exceeds the maximum of %s", maxRequestTTL))
+			return nil, false
+		}
+	}
+
+	reason := sanitizeReason(opts.Reason)
+	if utf8.RuneCountInString(reason) > maxReasonLength {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("reason exceeds %d characters", maxReasonLength))
+		return nil, false
+	}
+
+	if opts.CallbackURL != "" {
+		if err := validateCallbackURL(opts.CallbackURL); err != nil {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid callback_url: %v", err))
+			return nil, false
+		}
+	}
+
+	priority, err := parsePriority(opts.Priority)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid priority: %v", err))
+		return nil, false
+	}
+
+	tags, err := parseTags(opts.Tags)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid tags: %v", err))
+		return nil, false
+	}
+
+	now := time.Now()
+	request := &Request{
+		Approved:       false,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(ttl),
+		IP:             c.ClientIP(), // Store the client's IP address
+		Reason:         reason,
+		Priority:       priority,
+		Tags:           tags,
+		CallbackURL:    opts.CallbackURL,
+		IdempotencyKey: c.GetHeader(idempotencyKeyHeader),
+	}
+	request.setTLSMetadata(c.Request.TLS)
+	return request, true
+}
+
+// applyAutoApproval approves request on creation when the auto-approval
+// policy trusts its server and address, and reports whether it did
+func applyAutoApproval(request *Request) bool {
+	if !autoApproves(request) {
+		return false
+	}
+	request.Approved = true
+	request.ApprovedBy = []string{autoApproveAdmin}
+	request.DecidedAt = request.CreatedAt
+	return true
+}
+
+// auditNewRequest records the creation of the stored request, and its
+// approval if it was approved automatically. The caller holds its lock.
+func auditNewRequest(request *Request) {
+	audit(auditRequestCreated, request, nil)
+	if request.Approved {
+		audit(auditRequestAutoApproved, request, nil)
+	}
+}
+
+// announceNewRequest counts the stored request and notifies the admins, or for
+// an automatically approved request the server's callback
+func announceNewRequest(request *Request) {
+	requestsReceived.Inc()
+	if request.Approved {
+		slog.Info("request auto-approved", "request_id", request.ID, "server_id", request.ServerID, "client_ip", request.IP)
+		requestsApproved.Inc()
+		observeDecision(request)
+		sendCallbackAsync(request)
+		return
+	}
+	notifyAsync(request)
+}
+
+func handleServerRequestKey(c *gin.Context) {
+	span := startSpan(c, spanRequestKey)
+	defer endSpan(c, span)
+
+	var json struct {
+		ServerID string `json:"server_id" binding:"required"`
+		keyRequestOptions
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+	if !checkServerID(c, json.ServerID) || !allowKeyRequest(c, json.ServerID) {
+		return
+	}
+	request, ok := newKeyRequest(c, json.keyRequestOptions)
+	if !ok {
+		return
+	}
+	request.ServerID = json.ServerID
+
+	// Trusted servers matching the auto-approval policy skip the admins
+	autoApproved := applyAutoApproval(request)
+
+	// The ID stays locked until the request is saved
+	reqID, unlock, ok, err := generateRequestID(lockForCreate)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusInternalServerError, errCodeInternal, "Could not allocate a request ID")
+		return
+	}
+	request.ID = reqID
+	logKeyRequest(c, reqID)
+	setRequestTrace(request, span)
+	linkRequestSpan(span, request)
+
+	var evicted []*Request
+	if createChecksAll() {
+		requests, err := store.List()
+		if err != nil {
+			unlock()
+			respondStoreError(c, err)
+			return
+		}
+		if duplicate := findPendingDuplicate(requests, request); dedupRequests && duplicate != nil {
+			unlock()
+			logKeyRequest(c, duplicate.ID)
+			c.JSON(http.StatusOK, gin.H{
+				"message":               "Request already pending. Awaiting approval.",
+				"request_id":            duplicate.ID,
+				"server_id":             duplicate.ServerID,
+				"created_at":            duplicate.CreatedAt,
+				"expires_at":            duplicate.ExpiresAt,
+				"poll_interval_seconds": int(currentPollInterval().Seconds()),
+			})
+			return
+		}
+		if maxPending > 0 && countLivePending(requests) >= maxPending {
+			unlock()
+			respondError(c, http.StatusServiceUnavailable, errCodeTooManyPending,
+				fmt.Sprintf("Too many pending requests, the limit is %d. Retry later.", maxPending))
+			return
+		}
+		if shortCodes && !assignShortCode(requests, request) {
+			unlock()
+			respondError(c, http.StatusInternalServerError, errCodeInternal, "Could not allocate an approval code")
+			return
+		}
+		if evicted, ok = planEviction(requests, []*Request{request}); !ok {
+			unlock()
+			respondNothingToEvict(c)
+			return
+		}
+	}
+	if err := store.Save(request); err != nil {
+		unlock()
+		respondStoreError(c, err)
+		return
+	}
+	auditNewRequest(request)
+	if err := evictRequests(evicted); err != nil {
+		// The request itself was stored, eviction is best effort
+		slog.Error("evicting pending requests failed", "error", err)
+	}
+	unlock()
+
+	updatePendingGauge()
+	announceNewRequest(request)
+
+	message := fmt.Sprintf("Request received. Awaiting approval. Request will expire in %s.", request.ExpiresAt.Sub(request.CreatedAt))
+	if autoApproved {
+		message = "Request approved automatically. Fetch the key with get-key."
+	}
+
+	response := gin.H{
+		"message":               message,
+		"request_id":            reqID,
+		"server_id":             request.ServerID,
+		"created_at":            request.CreatedAt,
+		"expires_at":            request.ExpiresAt,
+		"poll_interval_seconds": int(currentPollInterval().Seconds()),
+	}
+	if request.ShortCode != "" {
+		response["approval_code"] = request.ShortCode
+	}
+	if autoApproved {
+		response["approved"] = true
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// handleServerRevoke lets a server withdraw a request it no longer needs. The
+// server_id must match the request so servers cannot revoke each other's.
+func handleServerRevoke(c *gin.Context) {
+	var json struct {
+		ReqID    string `json:"req_id" binding:"required"`
+		ServerID string `json:"server_id" binding:"required"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+
+	// Validate UUID format
+	if _, err := uuid.Parse(json.ReqID); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeInvalidRequestID, "Invalid request ID format")
+		return
+	}
+	logKeyRequest(c, json.ReqID)
+
+	requestLocks.Lock(json.ReqID)
+	defer requestLocks.Unlock(json.ReqID)
+
+	req, exists, err := store.Get(json.ReqID)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !exists {
+		respondError(c, http.StatusNotFound, errCodeRequestNotFound, "Request not found")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.ServerID), []byte(json.ServerID)) != 1 {
+		if hardenEnumeration {
+			// Another server's request must not be told apart from an unknown one
+			respondError(c, http.StatusNotFound, errCodeRequestNotFound, "Request not found")
+			return
+		}
+		respondError(c, http.StatusForbidden, errCodeForbidden, "Request belongs to a different server")
+		return
+	}
+	if isRequestExpired(req) {
+		deleteExpiredRequest(req)
+		respondError(c, http.StatusGone, errCodeRequestExpired, "Request has expired")
+		return
+	}
+
+	if err := store.Delete(req.ID); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	audit(auditRequestRevoked, req, nil)
+	updatePendingGauge()
+	decisions.notify(req.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Request revoked", "request_id": req.ID})
+}
+
+// urlRequestID returns the req_id named by the get-key path or query string,
+// for clients such as curl that call it without a body
+func urlRequestID(c *gin.Context) string {
+	if reqID := c.Param("req_id"); reqID != "" {
+		return reqID
+	}
+	return c.Query("req_id")
+}
+
+func handleServerGetKey(c *gin.Context) {
+	span := startSpan(c, spanGetKey)
+	defer endSpan(c, span)
+
+	var json struct {
+		ReqID string `json:"req_id"`
+		Wait  string `json:"wait"`
+	}
+	if !bindOptionalJSON(c, &json) {
+		return
+	}
+	// The body takes precedence over the URL, as for the signature
+	reqID := json.ReqID
+	if reqID == "" {
+		reqID = urlRequestID(c)
+	}
+	if reqID == "" {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Missing required field: req_id")
+		return
+	}
+
+	// Validate UUID format
+	if _, err := uuid.Parse(reqID); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeInvalidRequestID, "Invalid request ID format")
+		return
+	}
+	logKeyRequest(c, reqID)
+
+	// Optional long-poll, from the query string or the body
+	rawWait := c.Query("wait")
+	if rawWait == "" {
+		rawWait = json.Wait
+	}
+	wait, err := parseLongPollWait(rawWait)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid wait duration")
+		return
+	}
+	deadline := time.Now().Add(wait)
+
+	linked := false
+	for {
+		requestLocks.RLock(reqID)
+		req, exists, err := store.Get(reqID)
+		if err != nil {
+			requestLocks.RUnlock(reqID)
+			respondStoreError(c, err)
+			return
+		}
+		if exists && !linked {
+			linkRequestSpan(span, req)
+			linked = true
+		}
+		if exists && isRequestExpired(req) {
+			// Deleting an expired request needs the write lock, re-read under
+			// it in case a concurrent call already removed or extended it
+			requestLocks.RUnlock(reqID)
+			requestLocks.Lock(reqID)
+			if req, exists, err = store.Get(reqID); err != nil {
+				requestLocks.Unlock(reqID)
+				respondStoreError(c, err)
+				return
+			}
+			release := respondGetKey(c, reqID, req, exists)
+			requestLocks.Unlock(reqID)
+			if release != nil {
+				releaseKey(c, release)
+			}
+			return
+		}
+		// With HARDEN_ENUMERATION an unknown ID waits like a pending request,
+		// returning early would give it away
+		undecided := (exists && !req.Approved && !req.Denied) || (!exists && hiddenNotFound(reqID))
+		if !undecided || wait == 0 {
+			release := respondGetKey(c, reqID, req, exists)
+			requestLocks.RUnlock(reqID)
+			if release != nil {
+				releaseKey(c, release)
+			}
+			return
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			requestLocks.RUnlock(reqID)
+			c.JSON(http.StatusAccepted, gin.H{"status": "pending", "message": "Request not approved yet"})
+			return
+		}
+
+		// Subscribe before releasing the lock so a decision cannot be missed
+		decision := decisions.subscribe(reqID)
+		requestLocks.RUnlock(reqID)
+		untilExpiry := remaining
+		if exists {
+			untilExpiry = time.Until(req.expiresAt())
+		}
+		connected := waitForDecision(c.Request.Context(), decision, remaining, untilExpiry)
+		decisions.unsubscribe(reqID, decision)
+		if !connected {
+			return
+		}
+	}
+}
+
+// respondGetKey writes the get-key response for the current state of the
+// request, except for a key ready for release: it then writes nothing and
+// returns the request, for the caller to pass to releaseKey once it has
+// released the lock. The caller must hold the request's lock, for writing if
+// it expired.
+func respondGetKey(c *gin.Context, reqID string, req *Request, exists bool) *Request {
+	// Every outcome carries a distinct status so clients need not parse errors
+	if !exists && recentlyExpired.contains(reqID) {
+		c.JSON(http.StatusGone, gin.H{"error": apiError{Code: errCodeRequestExpired, Message: "Request has expired"}, "status": "expired"})
+		return nil
+	}
+	if !exists && recentlyConsumed.contains(reqID) {
+		c.JSON(http.StatusGone, gin.H{"error": apiError{Code: errCodeKeyConsumed, Message: "Key has already been fetched"}, "status": "consumed"})
+		return nil
+	}
+	if !exists && hiddenNotFound(reqID) {
+		respondNotApproved(c, nil)
+		return nil
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": apiError{Code: errCodeRequestNotFound, Message: "Request not found"}, "status": "not_found"})
+		return nil
+	}
+	if isRequestExpired(req) {
+		deleteExpiredRequest(req)
+		c.JSON(http.StatusGone, gin.H{"error": apiError{Code: errCodeRequestExpired, Message: "Request has expired"}, "status": "expired"})
+		return nil
+	}
+	if req.Denied {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       apiError{Code: errCodeRequestDenied, Message: "Request denied"},
+			"status":      "denied",
+			"reason":      req.DenyReason,
+			"reason_code": req.DenyReasonCode,
+		})
+	} else if req.Approved && time.Now().Before(req.ReleaseAt) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      apiError{Code: errCodeNotYetReleased, Message: "Key is withheld until release_at"},
+			"status":     "approved",
+			"release_at": req.ReleaseAt.Format(time.RFC3339),
+		})
+	} else if req.Approved {
+		return req
+	} else {
+		respondNotApproved(c, req)
+	}
+	return nil
+}
+
+// releaseKey fetches the key of the approved req and responds with it. It
+// runs without the request's lock, since the key provider may be a slow
+// network call. In ONE_TIME_KEY mode the request is consumed first, so only
+// one of several concurrent calls gets the key.
+func releaseKey(c *gin.Context, req *Request) {
+	key, err := releasedKey(c.Request.Context(), req.ServerID)
+	if errors.Is(err, errKeyNotFound) {
+		respondError(c, http.StatusNotFound, errCodeKeyNotConfigured, "No key configured for server")
+		return
+	}
+	if err != nil {
+		// Do not leak provider details to the client
+		slog.Error("fetching key failed", "server_id", req.ServerID, "error", err)
+		respondError(c, http.StatusBadGateway, errCodeKeyProviderUnavailable, "Key provider unavailable")
+		return
+	}
+	if oneTimeKey {
+		// Check and consume in one step before responding: the lock covers
+		// this instance, Take covers instances sharing the store
+		requestLocks.Lock(req.ID)
+		taken, err := store.Take(req.ID)
+		requestLocks.Unlock(req.ID)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		recentlyConsumed.add(req.ID)
+		updatePendingGauge()
+		if !taken {
+			c.JSON(http.StatusGone, gin.H{"error": apiError{Code: errCodeKeyConsumed, Message: "Key has already been fetched"}, "status": "consumed"})
+			return
+		}
+	} else if req.FetchedAt.IsZero() {
+		first, err := markFetched(req.ID)
+		if err != nil {
+			// The key is released all the same, only the stats miss the fetch
+			slog.Error("recording key fetch failed", "request_id", req.ID, "error", err)
+		} else if !first {
+			// A concurrent call fetched it first and recorded the history entry
+			req.FetchedAt = time.Now()
+		}
+	}
+	audit(auditKeyReleased, req, nil)
+	response := gin.H{"key": key, "status": "approved"}
+	if req.ApprovalNote != "" {
+		response["note"] = req.ApprovalNote
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// markFetched records the first fetch of the key of reqID on the stored
+// request and reports whether this was it. Requests that are gone or were
+// fetched before are left alone.
+func markFetched(reqID string) (bool, error) {
+	requestLocks.Lock(reqID)
+	defer requestLocks.Unlock(reqID)
+	req, exists, err := store.Get(reqID)
+	if err != nil || !exists || !req.FetchedAt.IsZero() {
+		return false, err
+	}
+	req.FetchedAt = time.Now()
+	return true, store.Save(req)
+}
+
+// respondNotApproved writes the get-key response for a pending request, req is
+// nil for an unknown ID hidden by HARDEN_ENUMERATION
+func respondNotApproved(c *gin.Context, req *Request) {
+	response := gin.H{
+		"error":                 apiError{Code: errCodeNotApproved, Message: "Request not approved yet"},
+		"status":                "pending",
+		"poll_interval_seconds": int(currentPollInterval().Seconds()),
+	}
+	// Only stored requests have approvals, hardened the count would single them out
+	if !hardenEnumeration {
+		response["approvals_remaining"] = approvalsRemaining(req)
+	}
+	c.JSON(http.StatusForbidden, response)
+}
+
+func setupRouter() *gin.Engine {
+	router := gin.New()
+	// ClientIP, and with it the stored request IP, the allowlist and the logs,
+	// only follows X-Forwarded-For from these proxies. Validated by loadConfig.
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		slog.Error("setting trusted proxies failed", "error", err)
+	}
+
+	// Pollers may reuse one HTTP/2 connection without TLS, e.g. behind a proxy
+	router.UseH2C = enableH2C
+
+	router.Use(correlateRequest())
+	router.Use(accessLog())
+
+	router.Use(gin.CustomRecovery(respondPanic))
+	router.Use(limitBodySize())
+	router.NoRoute(respondNoRoute)
+
+	// Protected endpoints require secret key
+	adminProtected := router.Group("/admin/", corsMiddleware(), requireAdminSecretKey())
+	serverProtected := router.Group("/server/", requireAllowedServerIP(), requireServerSecretKey(), requireServerSignature())
+
+	router.GET("/pingz", handlePing)
+	router.GET("/metrics", requireMetricsSecretKey(), metricsHandler())
+	// Health checkers and load balancers probe with HEAD
+	router.HEAD("/pingz", handlePing)
+	router.GET("/readyz", handleReady)
+	router.HEAD("/readyz", handleReady)
+	// Signed single-use approval links from notifications, the link is the
+	// credential. Opening one shows a confirmation page, its form approves.
+	router.GET("/admin/approve-link", handleApproveLinkPage)
+	router.POST("/admin/approve-link", handleApproveLink)
+	// Telegram bot webhook for the approve and deny buttons
+	router.POST("/telegram/callback", handleTelegramCallback)
+
+	// Endpoint to receive key requests
+	serverProtected.POST("/request-key", handleServerRequestKey)
+	// Endpoint to receive several key requests in one call
+	serverProtected.POST("/request-keys", handleServerRequestKeys)
+	// Endpoint to approve a request (protected)
+	adminProtected.GET("/approve/:req_id", requireTOTP(false), handleAdminApproveRequest)
+	// Endpoint to deny a request (protected)
+	adminProtected.GET("/deny/:req_id", requireTOTP(true), handleAdminDenyRequest)
+	// Endpoint to list pending requests (protected)
+	adminProtected.GET("/requests", handleAdminListRequests)
+	// Endpoint to inspect a single request (protected)
+	adminProtected.GET("/requests/:req_id", handleAdminGetRequest)
+	// Endpoint to give admins more time to decide a request (protected)
+	adminProtected.POST("/requests/:req_id/extend", handleAdminExtendRequest)
+	// Endpoint to remove every request (protected)
+	adminProtected.DELETE("/requests", handleAdminPurgeRequests)
+	// Endpoint to count requests by state (protected)
+	adminProtected.GET("/stats", handleAdminStats)
+	// Endpoint listing recently completed requests (protected)
+	adminProtected.GET("/history", handleAdminHistory)
+	// Endpoint streaming request events to dashboards (protected)
+	adminProtected.GET("/stream", handleAdminStream)
+	// Endpoint to approve all pending requests for a server_id prefix (protected)
+	adminProtected.POST("/approve-prefix", requireTOTP(false), handleAdminApprovePrefix)
+	// Endpoints to approve or deny all pending requests, optionally for one server_id (protected)
+	adminProtected.POST("/approve-all", requireTOTP(false), handleAdminApproveAll)
+	adminProtected.POST("/deny-all", requireTOTP(true), handleAdminDenyAll)
+	// Endpoint to approve the only pending request of a server (protected)
+	adminProtected.POST("/approve-by-server", requireTOTP(false), handleAdminApproveByServer)
+	// Endpoint to replace the key released to a server (protected)
+	adminProtected.PUT("/keys/:server_id", handleAdminRotateKey)
+	// Endpoint to get the decryption key
+	serverProtected.POST("/get-key", normalizeTiming(), handleServerGetKey)
+	serverProtected.POST("/get-key/:req_id", normalizeTiming(), handleServerGetKey)
+	// Endpoint to check a request's state without fetching the key
+	serverProtected.POST("/status", normalizeTiming(), handleServerStatus)
+	// Endpoint for a server to withdraw its own request
+	serverProtected.POST("/revoke", normalizeTiming(), handleServerRevoke)
+
+	registerOptionsRoutes(router)
+
+	return router
+}
+
+// Build information, set via -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  string
+)
+
+// handlePing answers pong with the build information, so monitoring can
+// confirm what is deployed
+func handlePing(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "pong",
+		"version":    version,
+		"commit":     commit,
+		"go_version": runtime.Version(),
+	})
+}
+
+// registerOptionsRoutes answers OPTIONS on every registered path with the
+// methods it allows. It must be called after all other routes are registered.
+func registerOptionsRoutes(router *gin.Engine) {
+	allowed := make(map[string][]string)
+	for _, route := range router.Routes() {
+		allowed[route.Path] = append(allowed[route.Path], route.Method)
+	}
+
+	for path, methods := range allowed {
+		methods = append(methods, http.MethodOptions)
+		sort.Strings(methods)
+		allow := strings.Join(methods, ", ")
+		handlers := []gin.HandlerFunc{}
+		// Browsers preflight admin calls, answer them for the allowed origins
+		if strings.HasPrefix(path, "/admin/") {
+			handlers = append(handlers, corsMiddleware())
+		}
+		handlers = append(handlers, func(c *gin.Context) {
+			c.Header("Allow", allow)
+			c.Status(http.StatusNoContent)
+		})
+		router.OPTIONS(path, handlers...)
+	}
+}
+
+// openConfiguredStore opens the store selected by STORE_BACKEND
+func openConfiguredStore() (Store, error) {
+	return openStore(storeBackend, storeConfig{
+		SQLitePath:    sqlitePath,
+		RedisAddr:     redisAddr,
+		RedisPassword: redisPassword,
+	})
+}
+
+func main() {
+	check := flag.Bool("check", false, "check the configuration, store and notifier, then exit")
+	encryptFor := flag.String("encrypt-key", "", "encrypt the key on stdin for this server_id with MASTER_KEY, then exit")
+	flag.Parse()
+	if err := setupLogging(os.Stderr); err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	if *encryptFor != "" {
+		if err := runEncryptKey(*encryptFor, os.Stdin, os.Stdout); err != nil {
+			fatal("encrypting key failed", "error", err)
+		}
+		return
+	}
+	if selfTest, _ := strconv.ParseBool(os.Getenv("SELF_TEST")); *check || selfTest {
+		os.Exit(runSelfTest(os.Stdout))
+	}
+
+	if errs := validateConfig(); len(errs) > 0 {
+		for _, err := range errs {
+			slog.Error("invalid configuration", "error", err)
+		}
+		os.Exit(1)
+	}
+	if err := loadConfig(); err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+
+	requestStore, err := openConfiguredStore()
+	if err != nil {
+		fatal("opening store failed", "backend", storeBackend, "error", err)
+	}
+	store = requestStore
+
+	shutdownTracing, err := setupTracing(context.Background(), otlpEndpoint)
+	if err != nil {
+		fatal("setting up tracing failed", "error", err)
+	}
+
+	router := setupRouter()
+
+	// Stop on SIGINT/SIGTERM, letting in-flight requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cleanupDone := startCleanup(ctx, cleanupInterval)
+
+	ln, err := openListener()
+	if err != nil {
+		fatal("listening failed", "address", bindAddress, "error", err)
+	}
+	// With socket activation BIND_ADDRESS may be unset
+	address := bindAddress
+	if address == "" {
+		address = ln.Addr().String()
+	}
+
+	var background sync.WaitGroup
+
+	if discoveryURL != "" {
+		// Register with the discovery service and deregister on shutdown
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runDiscovery(ctx, discoveryURL, discoveryHeartbeat, discoveryInstance{Address: address, Version: version})
+		}()
+	}
+
+	srv := newHTTPServer(router.Handler())
+	// Shutdown does not wait for streams to end by themselves
+	srv.RegisterOnShutdown(requestEvents.closeAll)
+	if tlsConfig != nil {
+		slog.Info("serving HTTPS", "address", ln.Addr().String())
+	}
+	ready.Store(true)
+	go func() {
+		<-ctx.Done()
+		ready.Store(false)
+	}()
+	if err := runServer(ctx, srv, ln, shutdownTimeout); err != nil {
+		slog.Error("server error", "error", err)
+	}
+	stop()
+	background.Wait()
+	<-cleanupDone
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
+	}
+	cancel()
+
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("closing store failed", "error", err)
+		}
+	}
 }
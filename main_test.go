@@ -2,19 +2,37 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
 	gin.SetMode(gin.TestMode)
+	approvalTimeout = 5 * time.Minute
+	auditLog = newJSONAuditLogger(io.Discard)
+	adminSecretKey = "test-admin-key"
+	adminSecretKeys = []string{adminSecretKey}
+	serverKeys = newKeyStore(map[string]string{"test-server": "test-decryption-key"})
 }
 
 func TestPingEndpoint(t *testing.T) {
@@ -24,7 +42,47 @@ func TestPingEndpoint(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), "pong")
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "pong", response["message"])
+	for _, field := range []string{"version", "commit", "go_version"} {
+		assert.Contains(t, response, field)
+	}
+	assert.Equal(t, runtime.Version(), response["go_version"])
+}
+
+func TestPingHeadEndpoint(t *testing.T) {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("HEAD", "/pingz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOptionsEndpoints(t *testing.T) {
+	router := setupRouter()
+
+	tests := []struct {
+		path      string
+		wantAllow string
+	}{
+		{path: "/pingz", wantAllow: "GET, HEAD, OPTIONS"},
+		{path: "/server/request-key", wantAllow: "OPTIONS, POST"},
+		{path: "/server/get-key", wantAllow: "OPTIONS, POST"},
+		{path: "/admin/approve/" + uuid.New().String(), wantAllow: "GET, OPTIONS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("OPTIONS", tt.path, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Equal(t, tt.wantAllow, w.Header().Get("Allow"))
+		})
+	}
 }
 
 func TestRequestKeyEndpoint(t *testing.T) {
@@ -47,6 +105,14 @@ func TestRequestKeyEndpoint(t *testing.T) {
 
 	// Verify response structure
 	assert.Contains(t, response, "request_id")
+	assert.Contains(t, response, "poll_interval_seconds")
+	assert.Equal(t, "test-server", response["server_id"], "server_id is echoed back")
+
+	createdAt, err := time.Parse(time.RFC3339, response["created_at"].(string))
+	assert.NoError(t, err)
+	expiresAt, err := time.Parse(time.RFC3339, response["expires_at"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, approvalTimeout, expiresAt.Sub(createdAt))
 
 	// Verify UUID validity
 	reqID := response["request_id"].(string)
@@ -116,16 +182,11 @@ func TestApprovalEndpoint(t *testing.T) {
 }
 
 func TestRequestExpiration(t *testing.T) {
-	// Override request timeout for testing
-	originalTimeout := approvalTimeout
-	approvalTimeout = "1s"
-	defer func() { approvalTimeout = originalTimeout }()
-
 	router := setupRouter()
 
-	// Create a test request
+	// Create a test request expiring in a second
 	w := httptest.NewRecorder()
-	reqBody := map[string]string{"server_id": "test-server"}
+	reqBody := map[string]string{"server_id": "test-server", "ttl": "1s"}
 	jsonBody, _ := json.Marshal(reqBody)
 	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
@@ -149,6 +210,91 @@ func TestRequestExpiration(t *testing.T) {
 	assert.Contains(t, r.Body.String(), "expired")
 }
 
+func TestExpiryFixedAtCreation(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	originalTimeout := approvalTimeout
+	approvalTimeout = time.Nanosecond
+	defer func() { approvalTimeout = originalTimeout }()
+
+	assert.False(t, isRequestExpired(getTestRequest(t, reqID)), "a shorter timeout does not expire existing requests")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCleanupPinsLegacyExpiry(t *testing.T) {
+	isolatePendingRequests(t)
+	createdAt := time.Now().Add(-time.Minute)
+	legacy := &Request{ID: uuid.New().String(), ServerID: "test-server", CreatedAt: createdAt}
+	require.NoError(t, store.Save(legacy))
+
+	cleanupExpiredRequests()
+	pinned := getTestRequest(t, legacy.ID)
+	require.NotNil(t, pinned)
+	assert.True(t, pinned.ExpiresAt.Equal(createdAt.Add(approvalTimeout)))
+
+	originalTimeout := approvalTimeout
+	approvalTimeout = time.Second
+	defer func() { approvalTimeout = originalTimeout }()
+	assert.False(t, isRequestExpired(pinned), "a pinned expiry no longer follows the timeout")
+}
+
+func TestRequestTTL(t *testing.T) {
+	isolatePendingRequests(t)
+
+	originalMax := maxRequestTTL
+	maxRequestTTL = 10 * time.Minute
+	defer func() { maxRequestTTL = originalMax }()
+
+	router := setupRouter()
+
+	tests := []struct {
+		name     string
+		ttl      string
+		wantCode int
+		wantTTL  time.Duration
+	}{
+		{"Default", "", http.StatusAccepted, approvalTimeout},
+		{"Custom", "2m", http.StatusAccepted, 2 * time.Minute},
+		{"AtMaximum", "10m", http.StatusAccepted, 10 * time.Minute},
+		{"AboveMaximum", "11m", http.StatusBadRequest, 0},
+		{"Malformed", "soon", http.StatusBadRequest, 0},
+		{"Negative", "-1m", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]string{"server_id": "test-server"}
+			if tt.ttl != "" {
+				body["ttl"] = tt.ttl
+			}
+			jsonBody, _ := json.Marshal(body)
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode != http.StatusAccepted {
+				return
+			}
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			stored := getTestRequest(t, response["request_id"].(string))
+			require.NotNil(t, stored)
+			assert.Equal(t, tt.wantTTL, stored.ExpiresAt.Sub(stored.CreatedAt))
+			assert.Contains(t, response["message"], tt.wantTTL.String())
+		})
+	}
+}
+
 func TestGetKeyEndpoint(t *testing.T) {
 	router := setupRouter()
 
@@ -232,8 +378,7 @@ func TestDenyEndpoint(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "denied")
 
-	// Verify request was removed
-	time.Sleep(1 * time.Second)
+	// Verify the server learns the request was denied
 	r := httptest.NewRecorder()
 	reqBody = map[string]string{"req_id": reqID}
 	jsonBody, _ = json.Marshal(reqBody)
@@ -242,5 +387,1377 @@ func TestDenyEndpoint(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(r, req)
 
-	assert.Equal(t, http.StatusNotFound, r.Code)
+	assert.Equal(t, http.StatusForbidden, r.Code)
+	assert.Contains(t, r.Body.String(), "denied")
+}
+
+func TestPollingAfterDenyWithReason(t *testing.T) {
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/deny/"+reqID+"?reason=unscheduled+reboot", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stored := getTestRequest(t, reqID)
+	if assert.NotNil(t, stored, "denied requests are kept until expiry") {
+		assert.True(t, stored.Denied)
+		assert.Equal(t, "unscheduled reboot", stored.DenyReason)
+	}
+
+	// Every poll reports the denial and its reason
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(map[string]string{"req_id": reqID})
+		req, _ = http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, apiError{Code: errCodeRequestDenied, Message: "Request denied"}, responseError(t, w))
+		assert.Equal(t, "unscheduled reboot", response["reason"])
+		assert.NotContains(t, response, "key")
+	}
+
+	// A denied request can no longer be approved
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+// isolatePendingRequests gives the test an empty store and restores the
+// previous one when it finishes
+func isolatePendingRequests(t *testing.T) {
+	t.Helper()
+	requestLocks.LockAll()
+	originalStore := store
+	store = newMemoryStore()
+	requestLocks.UnlockAll()
+	t.Cleanup(func() {
+		requestLocks.LockAll()
+		store = originalStore
+		requestLocks.UnlockAll()
+	})
+}
+
+// getTestRequest returns the stored request with the given ID, or nil
+func getTestRequest(t *testing.T, id string) *Request {
+	t.Helper()
+	req, _, err := store.Get(id)
+	if err != nil {
+		t.Fatalf("getting request %s: %v", id, err)
+	}
+	return req
+}
+
+// updateTestRequest applies update to the stored request and saves it
+func updateTestRequest(t *testing.T, id string, update func(req *Request)) {
+	t.Helper()
+	req := getTestRequest(t, id)
+	if req == nil {
+		t.Fatalf("request %s not found", id)
+	}
+	update(req)
+	if err := store.Save(req); err != nil {
+		t.Fatalf("saving request %s: %v", id, err)
+	}
+}
+
+// createTestRequest creates a key request for serverID and returns its ID
+func createTestRequest(t *testing.T, router *gin.Engine, serverID string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reqBody := map[string]string{"server_id": serverID}
+	jsonBody, _ := json.Marshal(reqBody)
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	reqID, _ := response["request_id"].(string)
+	return reqID
+}
+
+// adminCall makes an admin call with the bearer credential and returns the response
+func adminCall(router http.Handler, method, path, credential string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+credential)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDenyReasonCode(t *testing.T) {
+	router := setupRouter()
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Known reason code",
+			query:    "?reason_code=security_hold",
+			wantCode: http.StatusOK,
+			wantBody: "security_hold",
+		},
+		{
+			name:     "Unknown reason code",
+			query:    "?reason_code=bored",
+			wantCode: http.StatusBadRequest,
+			wantBody: "Invalid reason code",
+		},
+		{
+			name:     "Other without reason",
+			query:    "?reason_code=other",
+			wantCode: http.StatusBadRequest,
+			wantBody: "Reason is required",
+		},
+		{
+			name:     "Other with reason",
+			query:    "?reason_code=other&reason=decommissioned",
+			wantCode: http.StatusOK,
+			wantBody: "other",
+		},
+		{
+			name:     "No reason code",
+			query:    "",
+			wantCode: http.StatusOK,
+			wantBody: "denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqID := createTestRequest(t, router, "test-server")
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/admin/deny/"+reqID+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestSuggestedPollInterval(t *testing.T) {
+	tests := []struct {
+		pending int
+		want    time.Duration
+	}{
+		{pending: 0, want: 1 * time.Second},
+		{pending: 9, want: 1 * time.Second},
+		{pending: 10, want: 2 * time.Second},
+		{pending: 99, want: 2 * time.Second},
+		{pending: 100, want: 5 * time.Second},
+		{pending: 999, want: 5 * time.Second},
+		{pending: 1000, want: 10 * time.Second},
+		{pending: 50000, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, suggestedPollInterval(tt.pending), "pending=%d", tt.pending)
+	}
+}
+
+// listCountingStore counts List calls, which scan the whole store
+type listCountingStore struct {
+	Store
+	lists atomic.Int64
+}
+
+func (s *listCountingStore) List() ([]*Request, error) {
+	s.lists.Add(1)
+	return s.Store.List()
+}
+
+func TestPollIntervalWithoutListing(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	for range 9 {
+		createTestRequest(t, router, "other-server")
+	}
+
+	counting := &listCountingStore{Store: store}
+	requestLocks.LockAll()
+	store = counting
+	requestLocks.UnlockAll()
+
+	code, response := fetchTestKey(t, router, reqID)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, float64(2), response["poll_interval_seconds"])
+
+	w, _ := probeTestRequest(router, "/server/status", map[string]string{"req_id": reqID})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"poll_interval_seconds":2`)
+
+	assert.Zero(t, counting.lists.Load(), "polls use the cached request count")
+}
+
+func TestApprovePrefixEndpoint(t *testing.T) {
+	router := setupRouter()
+
+	rackA := createTestRequest(t, router, "rack12-a")
+	rackB := createTestRequest(t, router, "rack12-b")
+	other := createTestRequest(t, router, "rack13-a")
+
+	approvePrefix := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/approve-prefix", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without confirmation nothing is approved
+	w := approvePrefix(`{"prefix": "rack12-"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, getTestRequest(t, rackA).Approved)
+
+	w = approvePrefix(`{"prefix": "rack12-", "confirm": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Approved []string `json:"approved"`
+		Count    int      `json:"count"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Count)
+	assert.ElementsMatch(t, []string{rackA, rackB}, response.Approved)
+	assert.True(t, getTestRequest(t, rackA).Approved)
+	assert.True(t, getTestRequest(t, rackB).Approved)
+	assert.False(t, getTestRequest(t, other).Approved)
+}
+
+func TestApproveAllDenyAllEndpoints(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	bulk := func(path, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	first := createTestRequest(t, router, "darkstar")
+	second := createTestRequest(t, router, "darkstar")
+	other := createTestRequest(t, router, "bluestar")
+	expired := createTestRequest(t, router, "darkstar")
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+
+	// Without confirmation nothing changes
+	code, _ := bulk("/admin/approve-all", `{"server_id": "darkstar"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = bulk("/admin/deny-all", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.False(t, getTestRequest(t, first).Approved)
+	assert.False(t, getTestRequest(t, other).Denied)
+
+	code, response := bulk("/admin/approve-all", `{"server_id": "darkstar", "confirm": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2.0, response["count"])
+	assert.ElementsMatch(t, []interface{}{first, second}, response["approved"])
+	assert.True(t, getTestRequest(t, first).Approved)
+	assert.True(t, getTestRequest(t, second).Approved)
+	assert.False(t, getTestRequest(t, other).Approved)
+	assert.Nil(t, getTestRequest(t, expired), "expired requests are removed, not approved")
+
+	code, _ = bulk("/admin/deny-all", `{"reason_code": "bogus", "confirm": true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Without a server_id every pending request is affected, approved ones are left alone
+	late := createTestRequest(t, router, "redstar")
+	code, response = bulk("/admin/deny-all", `{"reason_code": "security_hold", "confirm": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2.0, response["count"])
+	assert.ElementsMatch(t, []interface{}{other, late}, response["denied"])
+	assert.True(t, getTestRequest(t, other).Denied)
+	assert.Equal(t, "security_hold", getTestRequest(t, late).DenyReasonCode)
+	assert.False(t, getTestRequest(t, first).Denied)
+
+	code, response = bulk("/admin/approve-all", `{"confirm": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0.0, response["count"], "denied requests are not approved")
+}
+
+func TestApproveByServer(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	approveByServer := func(serverID string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/approve-by-server", bytes.NewBufferString(`{"server_id": "`+serverID+`"}`))
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	t.Run("unique match", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "darkstar")
+		expired := createTestRequest(t, router, "darkstar")
+		updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+
+		code, response := approveByServer("darkstar")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, reqID, response["request_id"])
+		assert.True(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		code, _ := approveByServer("darkstar")
+		assert.Equal(t, http.StatusNotFound, code, "approved requests are no longer pending")
+		code, _ = approveByServer("nostar")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("multiple matches", func(t *testing.T) {
+		first := createTestRequest(t, router, "bluestar")
+		second := createTestRequest(t, router, "bluestar")
+
+		code, response := approveByServer("bluestar")
+		assert.Equal(t, http.StatusConflict, code)
+		assert.ElementsMatch(t, []interface{}{first, second}, response["candidates"])
+		assert.False(t, getTestRequest(t, first).Approved)
+		assert.False(t, getTestRequest(t, second).Approved)
+	})
+}
+
+func TestRequestTLSMetadata(t *testing.T) {
+	router := setupRouter()
+
+	// Plain HTTP leaves the metadata empty
+	reqID := createTestRequest(t, router, "plain-server")
+	assert.Empty(t, getTestRequest(t, reqID).TLSVersion)
+	assert.Empty(t, getTestRequest(t, reqID).TLSCipherSuite)
+	assert.Empty(t, getTestRequest(t, reqID).ClientCertCN)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id": "tls-server"}`))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.TLS = &tls.ConnectionState{
+		Version:     tls.VersionTLS13,
+		CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: "tls-server.internal"}},
+		},
+	}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	stored := getTestRequest(t, response["request_id"].(string))
+	assert.Equal(t, "TLS 1.3", stored.TLSVersion)
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", stored.TLSCipherSuite)
+	assert.Equal(t, "tls-server.internal", stored.ClientCertCN)
+}
+
+func TestEvictOldestPendingRequests(t *testing.T) {
+	isolatePendingRequests(t)
+	originalPolicy, originalThreshold := evictionPolicy, evictionThreshold
+	evictionPolicy, evictionThreshold = evictionPolicyOldest, 1
+	defer func() { evictionPolicy, evictionThreshold = originalPolicy, originalThreshold }()
+
+	router := setupRouter()
+
+	approved := createTestRequest(t, router, "server-2")
+	updateTestRequest(t, approved, func(req *Request) {
+		req.Approved = true
+		req.CreatedAt = time.Now().Add(-3 * time.Minute)
+	})
+	oldest := createTestRequest(t, router, "server-1")
+	// Keep creation times strictly ordered
+	updateTestRequest(t, oldest, func(req *Request) { req.CreatedAt = time.Now().Add(-2 * time.Minute) })
+
+	newest := createTestRequest(t, router, "server-3")
+
+	requests, _ := store.List()
+	assert.Len(t, requests, 2)
+	assert.Nil(t, getTestRequest(t, oldest), "oldest non-approved request should be evicted")
+	assert.NotNil(t, getTestRequest(t, approved), "approved requests are never evicted")
+	assert.NotNil(t, getTestRequest(t, newest))
+}
+
+func TestEvictionSparesNewRequest(t *testing.T) {
+	isolatePendingRequests(t)
+	originalPolicy, originalThreshold := evictionPolicy, evictionThreshold
+	evictionPolicy, evictionThreshold = evictionPolicyOldest, 1
+	defer func() { evictionPolicy, evictionThreshold = originalPolicy, originalThreshold }()
+
+	router := setupRouter()
+
+	// Decided requests do not count toward the threshold
+	approved := createTestRequest(t, router, "server-1")
+	updateTestRequest(t, approved, func(req *Request) { req.Approved = true })
+	denied := createTestRequest(t, router, "server-2")
+	updateTestRequest(t, denied, func(req *Request) { req.Denied = true })
+
+	created := createTestRequest(t, router, "server-3")
+	require.NotEmpty(t, created)
+	assert.NotNil(t, getTestRequest(t, created), "the request being created is never evicted")
+	assert.NotNil(t, getTestRequest(t, approved))
+	assert.NotNil(t, getTestRequest(t, denied))
+	code, response := fetchTestKey(t, router, created)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "pending", response["status"])
+
+	// A batch that alone exceeds the threshold leaves nothing to evict
+	w := requestTestBatch(router, `{"server_ids":["web-01","web-02"]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, errCodeTooManyPending, responseError(t, w).Code)
+	requests, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, requests, 3, "nothing of the rejected batch is stored, nothing is evicted")
+	assert.NotNil(t, getTestRequest(t, created))
+}
+
+func TestEvictionNotifiesServer(t *testing.T) {
+	isolatePendingRequests(t)
+	originalPolicy, originalThreshold := evictionPolicy, evictionThreshold
+	evictionPolicy, evictionThreshold = evictionPolicyOldest, 1
+	defer func() { evictionPolicy, evictionThreshold = originalPolicy, originalThreshold }()
+
+	payloads := make(chan callbackPayload, 1)
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload callbackPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer receiver.Close()
+	originalClient := callbackClient
+	callbackClient, allowPrivateCallbacks = receiver.Client(), true
+	defer func() { callbackClient, allowPrivateCallbacks = originalClient, false }()
+
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"server_id": "server-1", "callback_url": receiver.URL + "/decision"})
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	oldest := created["request_id"].(string)
+
+	polled := make(chan time.Duration, 1)
+	go func() {
+		_, elapsed := longPollGetKey(router, oldest, "10s")
+		polled <- elapsed
+	}()
+	require.Eventually(t, func() bool { return decisions.count(oldest) == 1 }, time.Second, 5*time.Millisecond)
+
+	createTestRequest(t, router, "server-2")
+	assert.Nil(t, getTestRequest(t, oldest), "oldest request should be evicted")
+
+	select {
+	case elapsed := <-polled:
+		assert.Less(t, elapsed, 5*time.Second, "eviction wakes the long-poll")
+	case <-time.After(5 * time.Second):
+		t.Fatal("long-poll not woken by eviction")
+	}
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, oldest, payload.RequestID)
+		assert.Equal(t, "evicted", payload.Status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback received")
+	}
+}
+
+func TestEvictionNeedsThreshold(t *testing.T) {
+	originalPolicy, originalThreshold, originalKeys := evictionPolicy, evictionThreshold, serverKeys
+	defer func() {
+		evictionPolicy, evictionThreshold, serverKeys = originalPolicy, originalThreshold, originalKeys
+	}()
+	t.Setenv("APPROVAL_TIMEOUT", "5m")
+	evictionPolicy, evictionThreshold = evictionPolicyOldest, 0
+
+	err := loadConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "EVICTION_THRESHOLD")
+
+	t.Setenv("EVICTION_THRESHOLD", "10")
+	assert.NoError(t, loadConfig())
+	assert.True(t, evictionEnabled())
+}
+
+func TestMaxPending(t *testing.T) {
+	isolatePendingRequests(t)
+	originalMaxPending := maxPending
+	maxPending = 2
+	defer func() { maxPending = originalMaxPending }()
+
+	router := setupRouter()
+	requestKey := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(map[string]string{"server_id": "test-server"})
+		req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := createTestRequest(t, router, "test-server")
+	require.NotEmpty(t, first)
+	require.Equal(t, http.StatusAccepted, requestKey().Code)
+
+	w := requestKey()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "limit is 2")
+
+	// An expired request no longer counts, even before cleanup removes it
+	updateTestRequest(t, first, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	assert.Equal(t, http.StatusAccepted, requestKey().Code)
+	assert.Equal(t, http.StatusServiceUnavailable, requestKey().Code)
+}
+
+func TestApproveWithReleaseAt(t *testing.T) {
+	router := setupRouter()
+
+	getKey := func(reqID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(map[string]string{"req_id": reqID})
+		req, _ := http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	approve := func(reqID, releaseAt string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/approve/"+reqID+"?release_at="+releaseAt, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Invalid release_at", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := approve(reqID, "tomorrow")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.False(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("Future release_at withholds key", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := approve(reqID, time.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = getKey(reqID)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "not_yet_released")
+	})
+
+	t.Run("Repeat approval keeps release_at", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		releaseAt := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+		w := approve(reqID, releaseAt.Format(time.RFC3339))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		approved := testutil.ToFloat64(requestsApproved)
+		w = approve(reqID, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, errCodeAlreadyApproved, responseError(t, w).Code)
+		assert.True(t, getTestRequest(t, reqID).ReleaseAt.Equal(releaseAt))
+		assert.Equal(t, approved, testutil.ToFloat64(requestsApproved))
+
+		w = getKey(reqID)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Past release_at rejected", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := approve(reqID, time.Now().Add(-time.Second).UTC().Format(time.RFC3339))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.False(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("release_at after expiry rejected", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		expiresAt := getTestRequest(t, reqID).ExpiresAt
+		w := approve(reqID, expiresAt.Add(time.Minute).UTC().Format(time.RFC3339))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "before request")
+		assert.False(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("Key released once release_at passes", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := approve(reqID, time.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+		require.Equal(t, http.StatusOK, w.Code)
+		updateTestRequest(t, reqID, func(req *Request) { req.ReleaseAt = time.Now().Add(-time.Second) })
+
+		w = getKey(reqID)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "key")
+	})
+}
+
+func TestRequestIDCollision(t *testing.T) {
+	router := setupRouter()
+
+	existing := createTestRequest(t, router, "first-server")
+
+	// Seeded generator that repeats the existing ID before yielding a fresh one
+	fresh := uuid.New().String()
+	ids := []string{existing, existing, fresh}
+	originalGenerator := newRequestID
+	newRequestID = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	defer func() { newRequestID = originalGenerator }()
+
+	reqID := createTestRequest(t, router, "second-server")
+
+	assert.Equal(t, fresh, reqID)
+	assert.Equal(t, "first-server", getTestRequest(t, existing).ServerID, "existing request must not be overwritten")
+	assert.Equal(t, "second-server", getTestRequest(t, fresh).ServerID)
+}
+
+func TestRequestIDCollisionExhausted(t *testing.T) {
+	router := setupRouter()
+
+	existing := createTestRequest(t, router, "first-server")
+
+	originalGenerator := newRequestID
+	newRequestID = func() string { return existing }
+	defer func() { newRequestID = originalGenerator }()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id": "second-server"}`))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "first-server", getTestRequest(t, existing).ServerID)
+}
+
+func TestRequestIDCollisionConcurrent(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	// Both calls draw the same ID first, only one may keep it
+	duplicate := uuid.New().String()
+	var mu sync.Mutex
+	ids := []string{duplicate, duplicate}
+	originalGenerator := newRequestID
+	newRequestID = func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(ids) == 0 {
+			return uuid.New().String()
+		}
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	defer func() { newRequestID = originalGenerator }()
+
+	var wg sync.WaitGroup
+	for _, serverID := range []string{"first-server", "second-server"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			createTestRequest(t, router, serverID)
+		}()
+	}
+	wg.Wait()
+
+	requests, err := store.List()
+	require.NoError(t, err)
+	servers := []string{}
+	for _, req := range requests {
+		servers = append(servers, req.ServerID)
+	}
+	assert.ElementsMatch(t, []string{"first-server", "second-server"}, servers, "neither request overwrites the other")
+}
+
+func TestListRequestsPagination(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	// Five requests created at the same time, ordered by ID
+	createdAt := time.Now()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		reqID := createTestRequest(t, router, "test-server")
+		updateTestRequest(t, reqID, func(req *Request) { req.CreatedAt = createdAt })
+		ids = append(ids, reqID)
+	}
+	sort.Strings(ids)
+
+	listPage := func(query string) (int, string, []string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/requests"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+
+		var response []requestSummary
+		json.Unmarshal(w.Body.Bytes(), &response)
+		page := []string{}
+		for _, summary := range response {
+			page = append(page, summary.RequestID)
+		}
+		return w.Code, w.Header().Get(totalCountHeader), page
+	}
+
+	code, total, page := listPage("?limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "5", total, "the total counts every matching request")
+	assert.Equal(t, ids[:2], page)
+
+	_, _, page = listPage("?limit=2&offset=2")
+	assert.Equal(t, ids[2:4], page, "pages continue where the previous ended")
+
+	_, _, page = listPage("?limit=2&offset=4")
+	assert.Equal(t, ids[4:], page)
+
+	code, total, page = listPage("?offset=10")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "5", total)
+	assert.Empty(t, page, "an offset beyond the end returns an empty page")
+
+	for _, query := range []string{"?limit=0", "?limit=1001", "?limit=x", "?offset=-1"} {
+		code, _, _ = listPage(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestListRequestsEndpoint(t *testing.T) {
+	isolatePendingRequests(t)
+
+	router := setupRouter()
+
+	older := createTestRequest(t, router, "older-server")
+	newer := createTestRequest(t, router, "newer-server")
+	expired := createTestRequest(t, router, "expired-server")
+	updateTestRequest(t, older, func(req *Request) { req.CreatedAt = time.Now().Add(-time.Minute) })
+	updateTestRequest(t, newer, func(req *Request) { req.Approved = true })
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+
+	listRequests := func(query string) (int, []requestSummary) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/requests"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+
+		var response []requestSummary
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, requests := listRequests("")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, requests, 2, "expired requests must be skipped") {
+		assert.Equal(t, newer, requests[0].RequestID, "newest request first")
+		assert.Equal(t, older, requests[1].RequestID)
+		assert.Equal(t, "older-server", requests[1].ServerID)
+	}
+
+	code, requests = listRequests("?approved=true")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, newer, requests[0].RequestID)
+	}
+
+	code, requests = listRequests("?approved=false")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, older, requests[0].RequestID)
+	}
+
+	code, _ = listRequests("?approved=maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestGetKeyPerServer(t *testing.T) {
+	router := setupRouter()
+
+	getKey := func(reqID string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		jsonBody, _ := json.Marshal(map[string]string{"req_id": reqID})
+		req, _ := http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	approve := func(reqID string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	t.Run("Known server", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		approve(reqID)
+
+		code, response := getKey(reqID)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "test-decryption-key", response["key"])
+	})
+
+	t.Run("Unknown server not yet approved", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "unknown-server")
+
+		code, response := getKey(reqID)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.NotContains(t, response, "key")
+	})
+
+	t.Run("Approved but unconfigured server", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "unknown-server")
+		approve(reqID)
+
+		code, response := getKey(reqID)
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, map[string]any{"code": errCodeKeyNotConfigured, "message": "No key configured for server"}, response["error"])
+		assert.NotContains(t, response, "key")
+	})
+}
+
+func TestAdminSecretKeyRotation(t *testing.T) {
+	originalKeys := adminSecretKeys
+	defer func() { adminSecretKeys = originalKeys }()
+
+	router := setupRouter()
+
+	listWith := func(authHeader string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/requests", nil)
+		req.Header.Set("Authorization", authHeader)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	adminSecretKeys = parseAdminSecretKeys("old-admin-key, new-admin-key", "")
+	assert.Equal(t, http.StatusOK, listWith("Bearer old-admin-key"))
+	assert.Equal(t, http.StatusOK, listWith("Bearer new-admin-key"))
+	assert.Equal(t, http.StatusUnauthorized, listWith("Bearer other-key"))
+
+	adminSecretKeys = parseAdminSecretKeys("", "")
+	assert.Empty(t, adminSecretKeys)
+	assert.Equal(t, http.StatusUnauthorized, listWith("Bearer "))
+	assert.Equal(t, http.StatusUnauthorized, listWith("Bearer old-admin-key"))
+}
+
+func TestParseAdminSecretKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, parseAdminSecretKeys("a,b", "single"), "list takes precedence")
+	assert.Equal(t, []string{"single"}, parseAdminSecretKeys("", "single"), "falls back to ADMIN_SECRET_KEY")
+	assert.Equal(t, []string{"a"}, parseAdminSecretKeys(" a , ,", ""))
+	assert.Empty(t, parseAdminSecretKeys("", ""))
+}
+
+func TestApprovalQuorum(t *testing.T) {
+	isolatePendingRequests(t)
+
+	originalApprovals := requiredApprovals
+	requiredApprovals = 2
+	defer func() { requiredApprovals = originalApprovals }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	signingKey, publicPEM := generateJWTKey(t)
+	approveAs := func(authorization, admin string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+		req.Header.Set("Authorization", "Bearer "+authorization)
+		if admin != "" {
+			req.Header.Set(adminIDHeader, admin)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	approve := func(admin string) *httptest.ResponseRecorder {
+		return approveAs(signAdminJWT(t, signingKey, adminClaims{
+			Admin:            admin,
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		}), "")
+	}
+	getKey := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]string{"req_id": reqID})
+		req, _ := http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("SharedKeyCannotCount", func(t *testing.T) {
+		for _, admin := range []string{"", "alice", "bob"} {
+			w := approveAs(adminSecretKey, admin)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "verified admin identity")
+		}
+		assert.Empty(t, getTestRequest(t, reqID).ApprovedBy)
+	})
+
+	enableJWTAuth(t, publicPEM)
+
+	t.Run("SingleApprovalInsufficient", func(t *testing.T) {
+		w := approve("alice")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Contains(t, w.Body.String(), "1 more approval(s) required")
+		assert.False(t, getTestRequest(t, reqID).Approved)
+
+		w = getKey()
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotContains(t, w.Body.String(), "test-decryption-key")
+		assert.Contains(t, w.Body.String(), `"approvals_remaining":1`)
+	})
+
+	t.Run("SameAdminTwice", func(t *testing.T) {
+		w := approve("alice")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.False(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("QuorumReached", func(t *testing.T) {
+		w := approve("bob")
+		assert.Equal(t, http.StatusOK, w.Code)
+		stored := getTestRequest(t, reqID)
+		assert.True(t, stored.Approved)
+		assert.Equal(t, []string{"alice", "bob"}, stored.ApprovedBy)
+
+		w = getKey()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "test-decryption-key")
+	})
+
+	t.Run("AlreadyApproved", func(t *testing.T) {
+		approved := testutil.ToFloat64(requestsApproved)
+		w := approve("carol")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, errCodeAlreadyApproved, responseError(t, w).Code)
+		assert.Equal(t, []string{"alice", "bob"}, getTestRequest(t, reqID).ApprovedBy)
+		assert.Equal(t, approved, testutil.ToFloat64(requestsApproved))
+	})
+}
+
+func TestQuorumRequiresJWT(t *testing.T) {
+	originalApprovals, originalKeys := requiredApprovals, serverKeys
+	defer func() { requiredApprovals, serverKeys = originalApprovals, originalKeys }()
+	t.Setenv("APPROVAL_TIMEOUT", "5m")
+	t.Setenv("REQUIRED_APPROVALS", "2")
+
+	err := loadConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ADMIN_AUTH_MODE=jwt")
+}
+
+func TestServerRevoke(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	revoke := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/revoke", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	reqID := createTestRequest(t, router, "test-server")
+
+	t.Run("InvalidID", func(t *testing.T) {
+		w := revoke(`{"req_id": "not-a-uuid", "server_id": "test-server"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("MissingServerID", func(t *testing.T) {
+		w := revoke(`{"req_id": "` + reqID + `"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "server_id")
+	})
+
+	t.Run("CrossServerMismatch", func(t *testing.T) {
+		w := revoke(`{"req_id": "` + reqID + `", "server_id": "other-server"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotNil(t, getTestRequest(t, reqID), "request must survive a foreign revoke")
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		w := revoke(`{"req_id": "` + reqID + `", "server_id": "test-server"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, getTestRequest(t, reqID))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		w := revoke(`{"req_id": "` + reqID + `", "server_id": "test-server"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestRequestReason(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	requestKey := func(body map[string]string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := requestKey(map[string]string{"server_id": "test-server", "reason": "Reboot after kernel update\nFAKE LOG LINE"})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/requests", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	var listed []requestSummary
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, created["request_id"], listed[0].RequestID)
+		assert.Equal(t, "Reboot after kernel update FAKE LOG LINE", listed[0].Reason, "newlines are sanitized")
+	}
+
+	w = requestKey(map[string]string{"server_id": "test-server", "reason": strings.Repeat("x", maxReasonLength+1)})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "reason exceeds")
+
+	w = requestKey(map[string]string{"server_id": "test-server", "reason": strings.Repeat("ż", maxReasonLength)})
+	assert.Equal(t, http.StatusAccepted, w.Code, "the limit counts characters, not bytes")
+}
+
+func TestGetKeyStatuses(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	pending := createTestRequest(t, router, "test-server")
+
+	approved := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, approved, func(req *Request) { req.Approved = true })
+
+	denied := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, denied, func(req *Request) { req.Denied = true })
+
+	expired := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+
+	cleanedUp := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, cleanedUp, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	cleanupExpiredRequests()
+	require.Nil(t, getTestRequest(t, cleanedUp))
+
+	tests := []struct {
+		name       string
+		reqID      string
+		query      string
+		wantCode   int
+		wantStatus string
+	}{
+		{"unknown request", uuid.New().String(), "", http.StatusNotFound, "not_found"},
+		{"pending request", pending, "", http.StatusForbidden, "pending"},
+		{"pending request with long poll", pending, "?wait=10ms", http.StatusAccepted, "pending"},
+		{"approved request", approved, "", http.StatusOK, "approved"},
+		{"denied request", denied, "", http.StatusForbidden, "denied"},
+		{"expired request", expired, "", http.StatusGone, "expired"},
+		{"expired request polled again", expired, "", http.StatusGone, "expired"},
+		{"expired request removed by cleanup", cleanedUp, "", http.StatusGone, "expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			jsonBody, _ := json.Marshal(map[string]string{"req_id": tt.reqID})
+			req, _ := http.NewRequest("POST", "/server/get-key"+tt.query, bytes.NewBuffer(jsonBody))
+			req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.wantStatus, response["status"])
+		})
+	}
+
+	// Admins acting on a removed expired request are told it expired
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+cleanedUp, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+// fetchTestKey calls get-key for reqID and returns the status code and body
+func fetchTestKey(t *testing.T, router http.Handler, reqID string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	jsonBody, _ := json.Marshal(map[string]string{"req_id": reqID})
+	req, _ := http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestOneTimeKey(t *testing.T) {
+	isolatePendingRequests(t)
+	originalOneTime := oneTimeKey
+	oneTimeKey = true
+	defer func() { oneTimeKey = originalOneTime }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	// Pending polls do not consume the request
+	code, _ := fetchTestKey(t, router, reqID)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+	code, response := fetchTestKey(t, router, reqID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test-decryption-key", response["key"])
+	assert.Nil(t, getTestRequest(t, reqID), "the request is removed once the key is fetched")
+
+	code, response = fetchTestKey(t, router, reqID)
+	assert.Equal(t, http.StatusGone, code)
+	assert.Equal(t, "consumed", response["status"])
+	assert.Nil(t, response["key"])
+}
+
+func TestApprovalNote(t *testing.T) {
+	router := setupRouter()
+
+	noted := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+noted+"?note="+url.QueryEscape("Approved for maintenance window X\nforged line"), adminSecretKey)
+	code, response := fetchTestKey(t, router, noted)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test-decryption-key", response["key"])
+	assert.Equal(t, "Approved for maintenance window X forged line", response["note"])
+
+	plain := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+plain, adminSecretKey)
+	code, response = fetchTestKey(t, router, plain)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, response, "note")
+
+	tooLong := createTestRequest(t, router, "test-server")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+tooLong+"?note="+strings.Repeat("x", maxNoteLength+1), nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, getTestRequest(t, tooLong).Approved)
+}
+
+func TestAdminPurgeRequests(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	approved := createTestRequest(t, router, "test-server")
+	denied := createTestRequest(t, router, "test-server")
+	createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+approved, adminSecretKey)
+	adminCall(router, "GET", "/admin/deny/"+denied, adminSecretKey)
+
+	purge := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/admin/requests"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := purge("")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "confirm is required")
+	requests, _ := store.List()
+	assert.Len(t, requests, 3)
+
+	received := testutil.ToFloat64(requestsReceived)
+	approvedTotal := testutil.ToFloat64(requestsApproved)
+	deniedTotal := testutil.ToFloat64(requestsDenied.WithLabelValues("none"))
+
+	w = purge("?confirm=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]int
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 3, response["purged"])
+	requests, _ = store.List()
+	assert.Empty(t, requests)
+
+	// The gauge follows the store, the counters stay monotonic
+	assert.Equal(t, float64(0), testutil.ToFloat64(pendingRequestsGauge))
+	assert.Equal(t, received, testutil.ToFloat64(requestsReceived))
+	assert.Equal(t, approvedTotal, testutil.ToFloat64(requestsApproved))
+	assert.Equal(t, deniedTotal, testutil.ToFloat64(requestsDenied.WithLabelValues("none")))
+
+	code, _ := fetchTestKey(t, router, approved)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestAdminExtendRequest(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	extend := func(reqID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/requests/"+reqID+"/extend", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	reqID := createTestRequest(t, router, "test-server")
+	deadline := getTestRequest(t, reqID).ExpiresAt
+
+	w := extend(reqID, `{"by": "10m"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, deadline.Add(10*time.Minute), getTestRequest(t, reqID).ExpiresAt)
+
+	// Without a body the request is extended by APPROVAL_TIMEOUT
+	w = extend(reqID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, deadline.Add(10*time.Minute+approvalTimeout), getTestRequest(t, reqID).ExpiresAt)
+
+	w = extend(reqID, `{"by": "48h"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "extension beyond MAX_REQUEST_LIFETIME")
+	assert.Contains(t, w.Body.String(), "maximum request lifetime")
+	assert.Equal(t, deadline.Add(10*time.Minute+approvalTimeout), getTestRequest(t, reqID).ExpiresAt)
+
+	assert.Equal(t, http.StatusBadRequest, extend(reqID, `{"by": "-5m"}`).Code)
+
+	expired := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	assert.Equal(t, http.StatusGone, extend(expired, `{"by": "5m"}`).Code)
+
+	approved := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+approved, adminSecretKey)
+	assert.Equal(t, http.StatusConflict, extend(approved, `{"by": "5m"}`).Code)
+
+	assert.Equal(t, http.StatusNotFound, extend(uuid.New().String(), `{"by": "5m"}`).Code)
+}
+
+func TestAdminGetRequest(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	tests := []struct {
+		name     string
+		reqID    string
+		wantCode int
+	}{
+		{"existing request", reqID, http.StatusOK},
+		{"invalid request ID", "invalid-uuid", http.StatusBadRequest},
+		{"unknown request", uuid.New().String(), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/admin/requests/"+tt.reqID, nil)
+			req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/requests/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+
+	var detail map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	assert.Equal(t, reqID, detail["request_id"])
+	assert.Equal(t, "test-server", detail["server_id"])
+	assert.Equal(t, true, detail["approved"])
+	assert.Equal(t, false, detail["denied"])
+	assert.Equal(t, false, detail["expired"])
+	assert.NotEmpty(t, detail["expires_at"])
+	assert.NotContains(t, w.Body.String(), "test-decryption-key")
+}
+
+func TestGetKeyRequestIDFromURL(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	approved := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+approved, adminSecretKey)
+	pending := createTestRequest(t, router, "test-server")
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"body only", "/server/get-key", `{"req_id":"` + approved + `"}`, http.StatusOK, ""},
+		{"query only", "/server/get-key?req_id=" + approved, "", http.StatusOK, ""},
+		{"path only", "/server/get-key/" + approved, "", http.StatusOK, ""},
+		{"query with an empty object", "/server/get-key?req_id=" + approved, `{}`, http.StatusOK, ""},
+		{"body wins over query", "/server/get-key?req_id=" + pending, `{"req_id":"` + approved + `"}`, http.StatusOK, ""},
+		{"body wins over path", "/server/get-key/" + approved, `{"req_id":"` + pending + `"}`, http.StatusForbidden, errCodeNotApproved},
+		{"invalid query", "/server/get-key?req_id=invalid-uuid", "", http.StatusBadRequest, errCodeInvalidRequestID},
+		{"invalid path", "/server/get-key/invalid-uuid", "", http.StatusBadRequest, errCodeInvalidRequestID},
+		{"neither", "/server/get-key", "", http.StatusBadRequest, errCodeBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := signedServerCall(router, tt.path, tt.body, "")
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantErr != "" {
+				assert.Equal(t, tt.wantErr, responseError(t, w).Code)
+				return
+			}
+			assert.Contains(t, w.Body.String(), "test-decryption-key")
+		})
+	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// masterKeyLength is the size of the AES-256 MASTER_KEY
+const masterKeyLength = 32
+
+// masterKey decrypts the static server keys, set from MASTER_KEY. When nil the
+// keys in KEYS_FILE and KEY_<server_id> are plaintext.
+var masterKey []byte
+
+// parseMasterKey decodes the base64 MASTER_KEY, which must be 32 bytes
+func parseMasterKey(raw string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("MASTER_KEY is not valid base64: %v", err)
+	}
+	if len(key) != masterKeyLength {
+		return nil, fmt.Errorf("MASTER_KEY must be %d bytes, got %d", masterKeyLength, len(key))
+	}
+	return key, nil
+}
+
+func newKeyCipher(master []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptKey seals key with AES-GCM under master and returns the base64 of the
+// nonce and ciphertext. The server ID is authenticated too, so a ciphertext
+// copied to another server's entry does not decrypt.
+func encryptKey(master []byte, serverID, key string) (string, error) {
+	gcm, err := newKeyCipher(master)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(key), []byte(serverID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptKey reverses encryptKey, failing if the ciphertext was tampered with
+// or belongs to a different server
+func decryptKey(master []byte, serverID, encrypted string) (string, error) {
+	gcm, err := newKeyCipher(master)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("encrypted key for server %q is not valid base64", serverID)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted key for server %q is too short", serverID)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	key, err := gcm.Open(nil, nonce, ciphertext, []byte(serverID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting key for server %q failed", serverID)
+	}
+	return string(key), nil
+}
+
+// runEncryptKey reads a plaintext key from in and writes its encryption for
+// serverID under MASTER_KEY to out, ready for KEYS_FILE or KEY_<server_id>
+func runEncryptKey(serverID string, in io.Reader, out io.Writer) error {
+	master, err := parseMasterKey(os.Getenv("MASTER_KEY"))
+	if err != nil {
+		return err
+	}
+	plain, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	key := strings.TrimRight(string(plain), "\r\n")
+	if key == "" {
+		return fmt.Errorf("no key given on standard input")
+	}
+	encrypted, err := encryptKey(master, serverID, key)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, encrypted)
+	return err
+}
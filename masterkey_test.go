@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateMasterKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, masterKeyLength)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func TestParseMasterKey(t *testing.T) {
+	master := generateMasterKey(t)
+	parsed, err := parseMasterKey(base64.StdEncoding.EncodeToString(master))
+	require.NoError(t, err)
+	assert.Equal(t, master, parsed)
+
+	_, err = parseMasterKey(base64.StdEncoding.EncodeToString(master[:16]))
+	assert.ErrorContains(t, err, "must be 32 bytes")
+	_, err = parseMasterKey("not base64!")
+	assert.ErrorContains(t, err, "base64")
+}
+
+func TestEncryptKeyRoundTrip(t *testing.T) {
+	master := generateMasterKey(t)
+	encrypted, err := encryptKey(master, "darkstar", "disk-key")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "disk-key")
+
+	key, err := decryptKey(master, "darkstar", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "disk-key", key)
+
+	again, err := encryptKey(master, "darkstar", "disk-key")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again, "every encryption uses a fresh nonce")
+}
+
+func TestDecryptKeyRejectsTampering(t *testing.T) {
+	master := generateMasterKey(t)
+	encrypted, err := encryptKey(master, "darkstar", "disk-key")
+	require.NoError(t, err)
+
+	sealed, _ := base64.StdEncoding.DecodeString(encrypted)
+	sealed[len(sealed)-1] ^= 0x01
+	_, err = decryptKey(master, "darkstar", base64.StdEncoding.EncodeToString(sealed))
+	assert.Error(t, err, "tampered ciphertext")
+
+	_, err = decryptKey(master, "other-server", encrypted)
+	assert.Error(t, err, "ciphertext of another server")
+	_, err = decryptKey(generateMasterKey(t), "darkstar", encrypted)
+	assert.Error(t, err, "wrong master key")
+	_, err = decryptKey(master, "darkstar", "c2hvcnQ=")
+	assert.Error(t, err, "truncated ciphertext")
+}
+
+func TestEncryptedKeyReleased(t *testing.T) {
+	master := generateMasterKey(t)
+	encrypted, err := encryptKey(master, "test-server", "test-decryption-key")
+	require.NoError(t, err)
+
+	originalKeys, originalMaster := serverKeys, masterKey
+	serverKeys, masterKey = newKeyStore(map[string]string{"test-server": encrypted}), master
+	defer func() { serverKeys, masterKey = originalKeys, originalMaster }()
+
+	require.NoError(t, checkEncryptedKeys(serverKeys, master))
+	assert.Error(t, checkEncryptedKeys(newKeyStore(map[string]string{"test-server": "plaintext"}), master))
+
+	key, err := releasedKey(context.Background(), "test-server")
+	require.NoError(t, err)
+	assert.Equal(t, "test-decryption-key", key)
+}
+
+func TestRunEncryptKey(t *testing.T) {
+	master := generateMasterKey(t)
+	t.Setenv("MASTER_KEY", base64.StdEncoding.EncodeToString(master))
+
+	var out bytes.Buffer
+	require.NoError(t, runEncryptKey("darkstar", strings.NewReader("disk-key\n"), &out))
+	key, err := decryptKey(master, "darkstar", strings.TrimSpace(out.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "disk-key", key)
+
+	assert.Error(t, runEncryptKey("darkstar", strings.NewReader(""), &out))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "szlaban_requests_received_total",
+		Help: "Total number of key requests received.",
+	})
+	requestsApproved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "szlaban_requests_approved_total",
+		Help: "Total number of key requests approved.",
+	})
+	requestsDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "szlaban_requests_denied_total",
+		Help: "Total number of key requests denied, by reason code.",
+	}, []string{"reason_code"})
+	requestsExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "szlaban_requests_expired_total",
+		Help: "Total number of key requests dropped after expiring.",
+	})
+	decisionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "szlaban_decision_duration_seconds",
+		Help:    "Time from a key request being created to it being approved, denied or expiring undecided.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200},
+	}, []string{"outcome"})
+	pendingRequestsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "szlaban_pending_requests",
+		Help: "Number of requests currently held by the store.",
+	})
+)
+
+// storedRequests caches the count behind pendingRequestsGauge, so polls can
+// size their interval hint without listing the store. Requests other
+// instances add or remove are picked up by the next cleanup.
+var storedRequests atomic.Int64
+
+// updatePendingGauge sets the pending gauge to the number of stored requests
+func updatePendingGauge() {
+	requests, err := store.List()
+	if err != nil {
+		slog.Error("updating pending gauge failed", "error", err)
+		return
+	}
+	storedRequests.Store(int64(len(requests)))
+	pendingRequestsGauge.Set(float64(len(requests)))
+}
+
+// denyReasonLabel is the reason_code label of a denial with code. Only the
+// DENY_REASON_CODES are used so the label stays bounded, denials without a
+// code are "none".
+func denyReasonLabel(code string) string {
+	switch {
+	case code == "":
+		return "none"
+	case denyReasonCodes[code]:
+		return code
+	default:
+		return "unknown"
+	}
+}
+
+// observeDecision records how long req waited for its decision. Undecided
+// requests are observed as expired, at the time they expired.
+func observeDecision(req *Request) {
+	switch {
+	case req.Approved:
+		decisionDuration.WithLabelValues("approved").Observe(req.DecidedAt.Sub(req.CreatedAt).Seconds())
+	case req.Denied:
+		decisionDuration.WithLabelValues("denied").Observe(req.DecidedAt.Sub(req.CreatedAt).Seconds())
+	default:
+		decisionDuration.WithLabelValues("expired").Observe(req.expiresAt().Sub(req.CreatedAt).Seconds())
+	}
+}
+
+// requireMetricsSecretKey protects /metrics when METRICS_SECRET_KEY is set
+func requireMetricsSecretKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsSecretKey == "" {
+			c.Next()
+			return
+		}
+		// Use constant time comparison to prevent timing attacks
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+metricsSecretKey)) != 1 {
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid authorization key")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// metricsHandler serves the Prometheus exposition format
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decisionSamples returns the sample count and sum of the decision duration
+// histogram for outcome
+func decisionSamples(t *testing.T, outcome string) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, decisionDuration.WithLabelValues(outcome).(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestMetricsCounters(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	received := testutil.ToFloat64(requestsReceived)
+	approved := testutil.ToFloat64(requestsApproved)
+	denied := testutil.ToFloat64(requestsDenied.WithLabelValues("none"))
+	expired := testutil.ToFloat64(requestsExpired)
+
+	toApprove := createTestRequest(t, router, "test-server")
+	toDeny := createTestRequest(t, router, "test-server")
+	toExpire := createTestRequest(t, router, "test-server")
+	assert.Equal(t, received+3, testutil.ToFloat64(requestsReceived))
+	assert.Equal(t, 3.0, testutil.ToFloat64(pendingRequestsGauge))
+
+	for _, path := range []string{"/admin/approve/" + toApprove, "/admin/deny/" + toDeny} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, approved+1, testutil.ToFloat64(requestsApproved))
+	assert.Equal(t, denied+1, testutil.ToFloat64(requestsDenied.WithLabelValues("none")))
+
+	updateTestRequest(t, toExpire, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	cleanupExpiredRequests()
+	assert.Equal(t, expired+1, testutil.ToFloat64(requestsExpired))
+	assert.Equal(t, 2.0, testutil.ToFloat64(pendingRequestsGauge))
+}
+
+func TestDeniedByReasonCode(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	held := testutil.ToFloat64(requestsDenied.WithLabelValues("security_hold"))
+	none := testutil.ToFloat64(requestsDenied.WithLabelValues("none"))
+
+	reqID := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/deny/"+reqID+"?reason_code=security_hold", adminSecretKey)
+	assert.True(t, getTestRequest(t, reqID).Denied)
+	assert.Equal(t, held+1, testutil.ToFloat64(requestsDenied.WithLabelValues("security_hold")))
+	assert.Equal(t, none, testutil.ToFloat64(requestsDenied.WithLabelValues("none")))
+
+	// Codes outside DENY_REASON_CODES are rejected, the label never sees them
+	reqID = createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/deny/"+reqID+"?reason_code=made_up", adminSecretKey)
+	assert.False(t, getTestRequest(t, reqID).Denied)
+
+	assert.Equal(t, "none", denyReasonLabel(""))
+	assert.Equal(t, "off_schedule", denyReasonLabel("off_schedule"))
+	assert.Equal(t, "unknown", denyReasonLabel("made_up"))
+}
+
+func TestDecisionDuration(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	approvedCount, approvedSum := decisionSamples(t, "approved")
+	deniedCount, _ := decisionSamples(t, "denied")
+	expiredCount, expiredSum := decisionSamples(t, "expired")
+
+	reqID := createTestRequest(t, router, "test-server")
+	time.Sleep(20 * time.Millisecond)
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+
+	count, sum := decisionSamples(t, "approved")
+	assert.Equal(t, approvedCount+1, count)
+	assert.GreaterOrEqual(t, sum-approvedSum, 0.02)
+	assert.False(t, getTestRequest(t, reqID).DecidedAt.IsZero())
+	count, _ = decisionSamples(t, "denied")
+	assert.Equal(t, deniedCount, count, "an approval is not recorded as denied")
+
+	// Undecided requests are observed at their expiry, however late cleanup runs
+	toExpire := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, toExpire, func(req *Request) {
+		req.CreatedAt = time.Now().Add(-time.Hour)
+		req.ExpiresAt = req.CreatedAt.Add(5 * time.Minute)
+	})
+	cleanupExpiredRequests()
+	count, sum = decisionSamples(t, "expired")
+	assert.Equal(t, expiredCount+1, count)
+	assert.InDelta(t, 300, sum-expiredSum, 0.001)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "szlaban_requests_received_total")
+	assert.Contains(t, w.Body.String(), "szlaban_pending_requests")
+}
+
+func TestMetricsEndpointSecretKey(t *testing.T) {
+	originalKey := metricsSecretKey
+	metricsSecretKey = "metrics-key"
+	defer func() { metricsSecretKey = originalKey }()
+
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Notifier announces new key requests to the admins. Implementations stop
+// when ctx is done.
+type Notifier interface {
+	Notify(ctx context.Context, req *Request) error
+}
+
+// notifier announces new requests, nil when no notification channel is configured
+var notifier Notifier
+
+// notifyAsync sends the notification in the background so it never delays the
+// response. Failures are logged and do not affect the request. Within
+// NOTIFY_COOLDOWN of the last notification for the same server it is
+// suppressed, and counted in the next one, unless the request is high priority.
+func notifyAsync(req *Request) {
+	n := notifierFor(req)
+	if n == nil {
+		return
+	}
+	req = req.clone()
+	if notifyCooldown > 0 && req.priority() != priorityHigh {
+		ok, suppressed := notificationCooldowns.allow(req.ServerID, time.Now())
+		if !ok {
+			slog.Info("notification suppressed by cooldown", "request_id", req.ID, "server_id", req.ServerID)
+			return
+		}
+		req.SuppressedNotifications = suppressed
+	}
+	go func(n Notifier, req *Request) {
+		ctx, cancel := asyncContext()
+		defer cancel()
+		if err := n.Notify(ctx, req); err != nil {
+			slog.Warn("notification failed", "request_id", req.ID, "error", err)
+		}
+	}(n, req)
+}
+
+// multiNotifier announces requests on every configured channel
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(ctx context.Context, req *Request) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, req); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notificationSummary describes a new request: its server, IP, priority, reason and tags
+func notificationSummary(req *Request) string {
+	text := fmt.Sprintf("New key request from server %s (IP %s).", req.ServerID, req.IP)
+	if priority := req.priority(); priority != priorityNormal {
+		text += fmt.Sprintf("\nPriority: %s", priority)
+	}
+	if req.Reason != "" {
+		text += fmt.Sprintf("\nReason: %s", req.Reason)
+	}
+	if len(req.Tags) > 0 {
+		text += fmt.Sprintf("\nTags: %s", strings.Join(req.Tags, ", "))
+	}
+	if req.BatchID != "" {
+		text += fmt.Sprintf("\nBatch: %s", req.BatchID)
+	}
+	if req.SuppressedNotifications > 0 {
+		text += fmt.Sprintf("\n%d more request(s) from this server were not announced during the %s cooldown.", req.SuppressedNotifications, notifyCooldown)
+	}
+	return text
+}
+
+// notificationText is the human readable summary of a new request with the
+// admin calls that decide it
+func notificationText(req *Request) string {
+	text := notificationSummary(req)
+	ref := req.ID
+	if req.ShortCode != "" {
+		ref = req.ShortCode
+	}
+	text += fmt.Sprintf("\nApprove: GET /admin/approve/%s\nDeny: GET /admin/deny/%s", ref, ref)
+	if link := approveLink(req); link != "" {
+		text += fmt.Sprintf("\nApprove now: %s", link)
+	}
+	return text
+}
+
+// slackEscaper escapes the characters Slack treats as markup in message text
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackNotifier posts new requests to a Slack incoming webhook
+type slackNotifier struct {
+	webhookURL string
+}
+
+func newSlackNotifier(webhookURL string) *slackNotifier {
+	return &slackNotifier{webhookURL: webhookURL}
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, req *Request) error {
+	body, err := json.Marshal(map[string]string{"text": slackEscaper.Replace(notificationText(req))})
+	if err != nil {
+		return err
+	}
+
+	resp, err := outboundPost(ctx, n.webhookURL, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlackNotification(t *testing.T) {
+	payloads := make(chan map[string]string, 1)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer slack.Close()
+
+	originalNotifier := notifier
+	notifier = newSlackNotifier(slack.URL)
+	defer func() { notifier = originalNotifier }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "slack-server")
+
+	select {
+	case payload := <-payloads:
+		assert.Contains(t, payload["text"], "slack-server")
+		assert.Contains(t, payload["text"], "/admin/approve/"+reqID)
+		assert.Contains(t, payload["text"], "/admin/deny/"+reqID)
+		assert.Contains(t, payload["text"], getTestRequest(t, reqID).IP)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+}
+
+func TestSlackNotificationReason(t *testing.T) {
+	payloads := make(chan map[string]string, 1)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer slack.Close()
+
+	req := &Request{ID: "id", ServerID: "darkstar", Reason: "Restore <!channel> & reboot"}
+	assert.NoError(t, newSlackNotifier(slack.URL).Notify(context.Background(), req))
+
+	payload := <-payloads
+	assert.Contains(t, payload["text"], "Reason: Restore &lt;!channel&gt; &amp; reboot", "markup in the reason is escaped")
+	assert.NotContains(t, notificationText(&Request{ID: "id"}), "Reason:", "no reason line without a reason")
+}
+
+func TestSlackNotificationFailure(t *testing.T) {
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer slack.Close()
+
+	err := newSlackNotifier(slack.URL).Notify(context.Background(), &Request{ID: "id", ServerID: "darkstar"})
+	assert.Error(t, err)
+
+	// A failing notifier must not fail the request
+	originalNotifier := notifier
+	notifier = newSlackNotifier(slack.URL)
+	defer func() { notifier = originalNotifier }()
+
+	router := setupRouter()
+	assert.NotEmpty(t, createTestRequest(t, router, "slack-server"))
+}
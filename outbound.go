@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+)
+
+// defaultOutboundTimeout bounds each outbound call unless OUTBOUND_TIMEOUT is set
+const defaultOutboundTimeout = 10 * time.Second
+
+// asyncSendLimit bounds a background delivery including its retries, so a
+// notification or callback never outlives it even if every attempt stalls
+const asyncSendLimit = time.Minute
+
+// outboundClient makes the calls to notifiers and key providers. Its timeout
+// is set from OUTBOUND_TIMEOUT by loadConfig. Callbacks use callbackClient,
+// which shares the timeout but refuses private addresses.
+var outboundClient = &http.Client{Timeout: defaultOutboundTimeout}
+
+// asyncContext returns the context of a background send, which has no request
+// to derive one from
+func asyncContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), asyncSendLimit)
+}
+
+// outboundPost posts body to url with outboundClient, giving up when ctx is done
+func outboundPost(ctx context.Context, url, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return outboundClient.Do(req)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowServer answers only after delay, or when the test ends
+func slowServer(t *testing.T, delay time.Duration) *httptest.Server {
+	t.Helper()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-done:
+		}
+	}))
+	t.Cleanup(server.Close)
+	// Runs before Close, which waits for the handlers
+	t.Cleanup(func() { close(done) })
+	return server
+}
+
+func TestOutboundTimeout(t *testing.T) {
+	originalTimeout := outboundClient.Timeout
+	outboundClient.Timeout = 100 * time.Millisecond
+	defer func() { outboundClient.Timeout = originalTimeout }()
+	server := slowServer(t, 5*time.Second)
+
+	start := time.Now()
+	err := newSlackNotifier(server.URL).Notify(context.Background(), &Request{ID: "id"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "the notification aborts at the timeout")
+
+	provider, err := newVaultKeyProvider(server.URL, "token", "")
+	require.NoError(t, err)
+	start = time.Now()
+	_, err = provider.Key(context.Background(), "darkstar")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "the key lookup aborts at the timeout")
+}
+
+func TestOutboundContextCancelled(t *testing.T) {
+	server := slowServer(t, 5*time.Second)
+	originalBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = originalBackoff }()
+
+	webhook, err := newWebhookNotifier(server.URL, "", "application/json", time.Minute)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.ErrorIs(t, webhook.Notify(ctx, &Request{ID: "id"}), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "retries stop once the context is done")
+}
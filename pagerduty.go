@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint, replaceable in tests
+var pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyNotifier triggers a PagerDuty incident for a new request
+type pagerDutyNotifier struct {
+	routingKey string
+}
+
+func newPagerDutyNotifier(routingKey string) *pagerDutyNotifier {
+	return &pagerDutyNotifier{routingKey: routingKey}
+}
+
+func (n *pagerDutyNotifier) Notify(ctx context.Context, req *Request) error {
+	body, err := json.Marshal(map[string]any{
+		"routing_key":  n.routingKey,
+		"event_action": "trigger",
+		// One incident per request, however often it is announced
+		"dedup_key": "szlaban-" + req.ID,
+		"payload": map[string]any{
+			"summary":        fmt.Sprintf("szlaban: %s key request from %s", req.priority(), req.ServerID),
+			"source":         req.ServerID,
+			"severity":       "critical",
+			"custom_details": map[string]string{"details": notificationText(req)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := outboundPost(ctx, pagerDutyEventsURL, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pagerduty returned %s", resp.Status)
+	}
+	return nil
+}
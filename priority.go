@@ -0,0 +1,47 @@
+package main
+
+import "fmt"
+
+// Request priorities a server may give, routing its notification
+const (
+	priorityLow    = "low"
+	priorityNormal = "normal"
+	priorityHigh   = "high"
+)
+
+// highPriorityNotifier is additionally told of high priority requests, such as
+// a pager for production incidents. Nil sends them to the default channels only.
+var highPriorityNotifier Notifier
+
+// parsePriority validates the priority of a new request, defaulting to normal
+func parsePriority(raw string) (string, error) {
+	switch raw {
+	case "":
+		return priorityNormal, nil
+	case priorityLow, priorityNormal, priorityHigh:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("must be %s, %s or %s", priorityLow, priorityNormal, priorityHigh)
+	}
+}
+
+// priority returns the priority of the request. Requests stored before
+// priorities existed are normal.
+func (r *Request) priority() string {
+	if r.Priority == "" {
+		return priorityNormal
+	}
+	return r.Priority
+}
+
+// notifierFor returns the channels announcing req: the default ones, plus
+// highPriorityNotifier for high priority requests
+func notifierFor(req *Request) Notifier {
+	if req.priority() != priorityHigh || highPriorityNotifier == nil {
+		return notifier
+	}
+	if notifier == nil {
+		return highPriorityNotifier
+	}
+	return multiNotifier{notifier, highPriorityNotifier}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingNotifier sends the server_id of every request it is told of to sent
+type recordingNotifier struct {
+	sent chan string
+}
+
+func newRecordingNotifier() *recordingNotifier {
+	return &recordingNotifier{sent: make(chan string, 10)}
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, req *Request) error {
+	n.sent <- req.ServerID
+	return nil
+}
+
+// setNotifiers replaces the default and high priority notifiers for the test
+func setNotifiers(t *testing.T, normal, high Notifier) {
+	t.Helper()
+	originalNotifier, originalHigh := notifier, highPriorityNotifier
+	notifier, highPriorityNotifier = normal, high
+	t.Cleanup(func() { notifier, highPriorityNotifier = originalNotifier, originalHigh })
+}
+
+// expectNotified waits for n to be told of serverID
+func expectNotified(t *testing.T, n *recordingNotifier, serverID string) {
+	t.Helper()
+	select {
+	case got := <-n.sent:
+		assert.Equal(t, serverID, got)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no notification for %s", serverID)
+	}
+}
+
+func TestPriorityValidation(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	w := signedServerCall(router, "/server/request-key", `{"server_id":"darkstar","priority":"urgent"}`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid priority")
+
+	w = signedServerCall(router, "/server/request-key", `{"server_id":"darkstar"}`, "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, priorityNormal, getTestRequest(t, created["request_id"].(string)).Priority, "priority defaults to normal")
+
+	w = signedServerCall(router, "/server/request-key", `{"server_id":"nova","priority":"low"}`, "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	w = httptest.NewRecorder()
+	listReq, _ := http.NewRequest("GET", "/admin/requests", nil)
+	listReq.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, listReq)
+	var summaries []requestSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &summaries))
+	priorities := map[string]string{}
+	for _, summary := range summaries {
+		priorities[summary.ServerID] = summary.Priority
+	}
+	assert.Equal(t, map[string]string{"darkstar": priorityNormal, "nova": priorityLow}, priorities)
+}
+
+func TestPriorityRouting(t *testing.T) {
+	isolatePendingRequests(t)
+	normal, high := newRecordingNotifier(), newRecordingNotifier()
+	setNotifiers(t, normal, high)
+	router := setupRouter()
+
+	require.Equal(t, http.StatusAccepted, signedServerCall(router, "/server/request-key", `{"server_id":"incident","priority":"high"}`, "").Code)
+	expectNotified(t, normal, "incident")
+	expectNotified(t, high, "incident")
+
+	require.Equal(t, http.StatusAccepted, signedServerCall(router, "/server/request-key", `{"server_id":"routine"}`, "").Code)
+	expectNotified(t, normal, "routine")
+	select {
+	case got := <-high.sent:
+		t.Fatalf("normal priority request %s reached the high priority notifier", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPagerDutyNotification(t *testing.T) {
+	events := make(chan map[string]any, 1)
+	pagerDuty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer pagerDuty.Close()
+	originalURL := pagerDutyEventsURL
+	pagerDutyEventsURL = pagerDuty.URL
+	defer func() { pagerDutyEventsURL = originalURL }()
+
+	req := &Request{ID: "id", ServerID: "darkstar", Priority: priorityHigh}
+	require.NoError(t, newPagerDutyNotifier("routing-key").Notify(context.Background(), req))
+
+	event := <-events
+	assert.Equal(t, "routing-key", event["routing_key"])
+	assert.Equal(t, "trigger", event["event_action"])
+	assert.Equal(t, "szlaban-id", event["dedup_key"])
+	assert.Contains(t, event["payload"].(map[string]any)["summary"], "high key request from darkstar")
+}
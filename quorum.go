@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminIDHeader names the admin in the audit log when admin secret keys are
+// used. It is self-reported, so it never counts towards a quorum.
+const adminIDHeader = "X-Admin-Id"
+
+// adminID returns the identifier of the admin making the request, or "" if unset.
+// A verified JWT identity takes precedence over the self-reported header.
+func adminID(c *gin.Context) string {
+	if admin := c.GetString(adminContextKey); admin != "" {
+		return admin
+	}
+	return strings.TrimSpace(c.GetHeader(adminIDHeader))
+}
+
+// verifiedAdmin reports whether the admin of c was identified by a JWT, a
+// mapped Telegram account or an approval link rather than the header
+func verifiedAdmin(c *gin.Context) bool {
+	return c.GetString(adminContextKey) != ""
+}
+
+// respondQuorumIdentityRequired rejects an approval that cannot count towards
+// a quorum, since anyone holding a shared admin key could claim any identity
+func respondQuorumIdentityRequired(c *gin.Context) {
+	respondError(c, http.StatusBadRequest, errCodeBadRequest,
+		fmt.Sprintf("A verified admin identity is required when %d approvals are required, use ADMIN_AUTH_MODE=%s", requiredApprovals, adminAuthJWT))
+}
+
+// recordApproval records adminID's approval of req and marks it approved once
+// requiredApprovals distinct admins have approved. It returns the number of
+// approvals still missing, and false if req is already approved or adminID
+// has already approved it.
+func recordApproval(req *Request, adminID string) (int, bool) {
+	if req.Approved {
+		return 0, false
+	}
+	if adminID != "" {
+		if slices.Contains(req.ApprovedBy, adminID) {
+			return approvalsRemaining(req), false
+		}
+		req.ApprovedBy = append(req.ApprovedBy, adminID)
+	}
+	if requiredApprovals <= 1 || len(req.ApprovedBy) >= requiredApprovals {
+		req.Approved = true
+		req.DecidedAt = time.Now()
+	}
+	return approvalsRemaining(req), true
+}
+
+// approvalsRemaining returns how many more distinct admins must approve req
+func approvalsRemaining(req *Request) int {
+	if req.Approved {
+		return 0
+	}
+	return max(requiredApprovals-len(req.ApprovedBy), 1)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestLimiter limits request-key calls per server_id and per client IP,
+// nil when REQUEST_RATE is not set
+var requestLimiter *rateLimiter
+
+// rateLimiter is a set of token buckets, each holding up to burst tokens and
+// refilling completely over window
+type rateLimiter struct {
+	mu        sync.Mutex
+	burst     float64
+	window    time.Duration
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(burst int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		burst:   float64(burst),
+		window:  window,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// parseRate parses a REQUEST_RATE such as "10/m" into the number of requests
+// and the window they are allowed in. The unit is s, m, h or a Go duration.
+func parseRate(raw string) (int, time.Duration, error) {
+	count, unit, ok := strings.Cut(raw, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid rate %q, expected <count>/<unit> such as 10/m", raw)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q, count must be a positive integer", raw)
+	}
+
+	var window time.Duration
+	switch unit = strings.TrimSpace(unit); unit {
+	case "s":
+		window = time.Second
+	case "m":
+		window = time.Minute
+	case "h":
+		window = time.Hour
+	default:
+		if window, err = time.ParseDuration(unit); err != nil || window <= 0 {
+			return 0, 0, fmt.Errorf("invalid rate %q, unit must be s, m, h or a duration", raw)
+		}
+	}
+	return n, window, nil
+}
+
+// allow takes a token from the bucket of every key, or from none of them if
+// any bucket is empty. When denied it returns how long until a retry can succeed.
+func (l *rateLimiter) allow(keys ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	rate := l.burst / l.window.Seconds() // tokens per second
+	var wait time.Duration
+	buckets := make([]*tokenBucket, len(keys))
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: l.burst, updated: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+		b.updated = now
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/rate*float64(time.Second)))
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// sweep drops buckets idle for a full window. They have refilled completely,
+// so forgetting them is indistinguishable from keeping them. Caller holds l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// retryAfterSeconds rounds a wait up to the whole seconds of a Retry-After header
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
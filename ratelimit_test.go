@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a manually advanced time source for the limiter
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		raw        string
+		wantCount  int
+		wantWindow time.Duration
+		wantErr    bool
+	}{
+		{raw: "10/m", wantCount: 10, wantWindow: time.Minute},
+		{raw: "5/s", wantCount: 5, wantWindow: time.Second},
+		{raw: "100/h", wantCount: 100, wantWindow: time.Hour},
+		{raw: "3/30s", wantCount: 3, wantWindow: 30 * time.Second},
+		{raw: "10", wantErr: true},
+		{raw: "0/m", wantErr: true},
+		{raw: "ten/m", wantErr: true},
+		{raw: "10/fortnight", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			count, window, err := parseRate(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+			assert.Equal(t, tt.wantWindow, window)
+		})
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limiter := newRateLimiter(2, time.Minute)
+	limiter.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.allow("server:a")
+		assert.True(t, ok, "burst request %d", i)
+	}
+	ok, wait := limiter.allow("server:a")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, wait, "one token refills in half the window")
+
+	// Other keys have their own bucket
+	ok, _ = limiter.allow("server:b")
+	assert.True(t, ok)
+
+	clock.Advance(30 * time.Second)
+	ok, _ = limiter.allow("server:a")
+	assert.True(t, ok)
+	ok, _ = limiter.allow("server:a")
+	assert.False(t, ok)
+
+	// A denied key does not consume the tokens of the others
+	ok, _ = limiter.allow("server:a", "ip:10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 2.0, limiter.buckets["ip:10.0.0.1"].tokens)
+
+	// Buckets idle for a full window are evicted
+	clock.Advance(2 * time.Minute)
+	limiter.allow("server:c")
+	assert.NotContains(t, limiter.buckets, "server:a")
+	assert.NotContains(t, limiter.buckets, "server:b")
+	assert.Contains(t, limiter.buckets, "server:c")
+}
+
+func TestRequestKeyRateLimit(t *testing.T) {
+	isolatePendingRequests(t)
+
+	clock := &fakeClock{now: time.Now()}
+	requestLimiter = newRateLimiter(3, time.Minute)
+	requestLimiter.now = clock.Now
+	defer func() { requestLimiter = nil }()
+
+	router := setupRouter()
+	requestKey := func(serverID, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(`{"server_id": "`+serverID+`"}`))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":40000"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusAccepted, requestKey("flooder", "10.0.0.1").Code)
+	}
+	w := requestKey("flooder", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "20", w.Header().Get("Retry-After"))
+
+	// The server_id is limited regardless of the IP it comes from
+	assert.Equal(t, http.StatusTooManyRequests, requestKey("flooder", "10.0.0.2").Code)
+	// And the IP is limited regardless of the server_id it claims
+	assert.Equal(t, http.StatusTooManyRequests, requestKey("other", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusAccepted, requestKey("other", "10.0.0.3").Code)
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, http.StatusAccepted, requestKey("flooder", "10.0.0.1").Code)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ready is set once startup has completed and cleared again on shutdown so
+// load balancers stop routing new traffic before the listener closes
+var ready atomic.Bool
+
+// storePinger is implemented by store backends that depend on an external
+// resource which may become unreachable
+type storePinger interface {
+	Ping() error
+}
+
+// handleReady is the readiness probe. Unlike /pingz, which only reports that
+// the process is alive, it fails until startup is complete and while the
+// store is unreachable.
+func handleReady(c *gin.Context) {
+	if !ready.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+	if err := checkStore(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "store unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// storeFailing is set when a store call fails and cleared once the store
+// answers the readiness probe again, taking the instance out of rotation
+// while its backend is down
+var storeFailing atomic.Bool
+
+// readinessProbeID is looked up to probe stores that cannot be pinged
+const readinessProbeID = "readyz-probe"
+
+// checkStore reports whether the store is reachable and updates storeFailing.
+// Stores without Ping are only probed after a failure, with a lookup.
+func checkStore() error {
+	var err error
+	if pinger, ok := store.(storePinger); ok {
+		err = pinger.Ping()
+	} else if storeFailing.Load() {
+		_, _, err = store.Get(readinessProbeID)
+	}
+	storeFailing.Store(err != nil)
+	return err
+}
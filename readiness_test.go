@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unreachableStore is a memory store whose backing resource is down
+type unreachableStore struct {
+	*memoryStore
+}
+
+func (unreachableStore) Ping() error { return errors.New("connection refused") }
+
+func TestReadyzEndpoint(t *testing.T) {
+	router := setupRouter()
+	probe := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	defer ready.Store(false)
+
+	ready.Store(false)
+	w := probe()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "not ready before initialization")
+	assert.Contains(t, w.Body.String(), "starting")
+
+	ready.Store(true)
+	w = probe()
+	assert.Equal(t, http.StatusOK, w.Code, "ready after initialization")
+	assert.Contains(t, w.Body.String(), "ready")
+
+	original := store
+	store = unreachableStore{newMemoryStore()}
+	defer func() { store = original }()
+	w = probe()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "store unavailable")
+
+	// Liveness is unaffected by readiness
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pingz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// failingStore is a store whose backend is down, every call fails
+type failingStore struct{}
+
+var errStoreDown = errors.New("dial tcp 10.0.0.5:6379: connect: connection refused")
+
+func (failingStore) Save(*Request) error                { return errStoreDown }
+func (failingStore) Get(string) (*Request, bool, error) { return nil, false, errStoreDown }
+func (failingStore) Delete(string) error                { return errStoreDown }
+func (failingStore) Take(string) (bool, error)          { return false, errStoreDown }
+func (failingStore) List() ([]*Request, error)          { return nil, errStoreDown }
+
+func TestStoreUnavailable(t *testing.T) {
+	router := setupRouter()
+	ready.Store(true)
+	defer ready.Store(false)
+
+	original := store
+	store = failingStore{}
+	defer func() { store = original }()
+	defer storeFailing.Store(false)
+
+	calls := []struct {
+		method, path, body, key string
+	}{
+		{"POST", "/server/request-key", `{"server_id": "test-server"}`, serverSecretKey},
+		{"POST", "/server/get-key", `{"req_id": "550e8400-e29b-41d4-a716-446655440000"}`, serverSecretKey},
+		{"GET", "/admin/approve/550e8400-e29b-41d4-a716-446655440000", "", adminSecretKey},
+		{"GET", "/admin/deny/550e8400-e29b-41d4-a716-446655440000", "", adminSecretKey},
+		{"GET", "/admin/requests", "", adminSecretKey},
+	}
+	for _, call := range calls {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(call.method, call.path, strings.NewReader(call.body))
+		req.Header.Set("Authorization", "Bearer "+call.key)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, call.path)
+		assert.Contains(t, w.Body.String(), storeUnavailableMessage, call.path)
+		assert.NotContains(t, w.Body.String(), "10.0.0.5", "backend details are not exposed")
+		assert.NotEmpty(t, w.Header().Get("Retry-After"), call.path)
+	}
+
+	probe := func() int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.True(t, storeFailing.Load())
+	assert.Equal(t, http.StatusServiceUnavailable, probe(), "not ready while the store fails")
+
+	// Once the backend answers again the instance is ready
+	store = newMemoryStore()
+	assert.Equal(t, http.StatusOK, probe())
+	assert.False(t, storeFailing.Load())
+}
+
+func TestRedactStoreError(t *testing.T) {
+	original := redisPassword
+	redisPassword = "hunter2-redis"
+	defer func() { redisPassword = original }()
+
+	msg := redactStoreError(errors.New("AUTH hunter2-redis failed"))
+	assert.NotContains(t, msg, "hunter2-redis")
+	assert.Contains(t, msg, "[redacted]")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the correlation ID of a call, taken from the client
+// or generated, and echoed in the response
+const requestIDHeader = "X-Request-ID"
+
+// Gin context keys of the correlation ID of the call and of the key request
+// it acted on, both logged by accessLog
+const (
+	correlationIDContextKey = "szlaban.correlation_id"
+	keyRequestContextKey    = "szlaban.key_request"
+)
+
+// clientRequestIDPattern limits the X-Request-ID accepted from clients, which
+// ends up in logs, to a reasonable length of plain characters
+var clientRequestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:-]{1,128}$`)
+
+// correlateRequest gives every call a correlation ID: the client's
+// X-Request-ID when well-formed, otherwise a new UUID. It is kept in the gin
+// context for the logs and echoed in the response.
+func correlateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !clientRequestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set(correlationIDContextKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// logKeyRequest records which key request the call acted on, so the access
+// log lines of its creation, decision and fetch can be joined
+func logKeyRequest(c *gin.Context, reqID string) {
+	c.Set(keyRequestContextKey, reqID)
+}
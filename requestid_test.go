@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pingWithRequestID calls /pingz with the given X-Request-ID, if any
+func pingWithRequestID(router http.Handler, id string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pingz", nil)
+	if id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	router := setupRouter()
+
+	w := pingWithRequestID(router, "deploy-42.step-3")
+	assert.Equal(t, "deploy-42.step-3", w.Header().Get(requestIDHeader), "a supplied ID is echoed")
+
+	w = pingWithRequestID(router, "")
+	_, err := uuid.Parse(w.Header().Get(requestIDHeader))
+	assert.NoError(t, err, "an ID is generated when none is supplied")
+
+	w = pingWithRequestID(router, strings.Repeat("x", 200))
+	_, err = uuid.Parse(w.Header().Get(requestIDHeader))
+	assert.NoError(t, err, "an overlong ID is replaced")
+	w = pingWithRequestID(router, "bad id\x7f")
+	assert.NotContains(t, w.Header().Get(requestIDHeader), "bad", "an ID with odd characters is replaced")
+}
+
+func TestAccessLogKeyRequestID(t *testing.T) {
+	isolatePendingRequests(t)
+	t.Setenv("LOG_FORMAT", "json")
+	buf := captureLogs(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+	code, _ := fetchTestKey(t, router, reqID)
+	require.Equal(t, http.StatusOK, code)
+
+	paths := map[string]string{}
+	for _, record := range logRecords(t, buf) {
+		if record["msg"] == "http request" && record["key_request_id"] == reqID {
+			paths[record["path"].(string)] = record["request_id"].(string)
+		}
+	}
+	assert.Contains(t, paths, "/server/request-key")
+	assert.Contains(t, paths, "/admin/approve/"+reqID)
+	assert.Contains(t, paths, "/server/get-key")
+	assert.NotEqual(t, paths["/server/request-key"], paths["/server/get-key"], "each call has its own correlation ID")
+}
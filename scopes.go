@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminScopeConfig limits admins to the requests whose server_id starts with
+// one of their prefixes. Admins without an entry may act on every request.
+type adminScopeConfig struct {
+	// Admins are named by the JWT admin claim, or the admin a Telegram user maps to
+	Admins map[string][]string `json:"admins"`
+	// Keys are admin secret keys, for teams sharing a key in secret mode
+	Keys map[string][]string `json:"keys"`
+}
+
+// adminScopes is loaded from ADMIN_SCOPES_FILE
+var adminScopes adminScopeConfig
+
+// adminScopeContextKey holds the server_id prefixes the admin of a call is
+// limited to, unset for unrestricted admins
+const adminScopeContextKey = "szlaban.scope"
+
+// loadAdminScopes reads the scopes file at path, an empty path restricts nobody
+func loadAdminScopes(path string) (adminScopeConfig, error) {
+	var scopes adminScopeConfig
+	if path == "" {
+		return scopes, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scopes, fmt.Errorf("reading ADMIN_SCOPES_FILE: %v", err)
+	}
+	if err := json.Unmarshal(data, &scopes); err != nil {
+		return scopes, fmt.Errorf("parsing ADMIN_SCOPES_FILE %s: %v", path, err)
+	}
+	for name, prefixes := range scopes.Admins {
+		if len(prefixes) == 0 {
+			return scopes, fmt.Errorf("ADMIN_SCOPES_FILE gives admin %q no server_id prefixes", name)
+		}
+	}
+	for _, prefixes := range scopes.Keys {
+		if len(prefixes) == 0 {
+			// Do not echo the key
+			return scopes, fmt.Errorf("ADMIN_SCOPES_FILE gives an admin key no server_id prefixes")
+		}
+	}
+	return scopes, nil
+}
+
+// restrictAdmin limits the admin of c to prefixes when ok, as found in one of
+// the adminScopes maps
+func restrictAdmin(c *gin.Context, prefixes []string, ok bool) {
+	if ok {
+		c.Set(adminScopeContextKey, prefixes)
+	}
+}
+
+// adminScoped reports whether the admin of c is limited to some servers
+func adminScoped(c *gin.Context) bool {
+	_, ok := c.Get(adminScopeContextKey)
+	return ok
+}
+
+// inAdminScope reports whether the admin of c may act on requests of serverID
+func inAdminScope(c *gin.Context, serverID string) bool {
+	value, ok := c.Get(adminScopeContextKey)
+	if !ok {
+		return true
+	}
+	for _, prefix := range value.([]string) {
+		if strings.HasPrefix(serverID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func respondOutOfScope(c *gin.Context) {
+	respondError(c, http.StatusForbidden, errCodeOutOfScope, "Request is outside your admin scope")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webTeamKey is an admin key limited to web- servers in the tests
+const webTeamKey = "web-team-admin-key-0123"
+
+// enableAdminScopes sets the admin scopes for the test and accepts webTeamKey
+func enableAdminScopes(t *testing.T, scopes adminScopeConfig) {
+	t.Helper()
+	originalScopes, originalKeys := adminScopes, adminSecretKeys
+	adminScopes, adminSecretKeys = scopes, append([]string{webTeamKey}, adminSecretKeys...)
+	t.Cleanup(func() { adminScopes, adminSecretKeys = originalScopes, originalKeys })
+}
+
+func TestLoadAdminScopes(t *testing.T) {
+	scopes, err := loadAdminScopes("")
+	require.NoError(t, err)
+	assert.Empty(t, scopes.Admins)
+
+	path := filepath.Join(t.TempDir(), "scopes.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"admins": {"alice": ["web-", "db-"]}, "keys": {"team-key": ["cache-"]}}`), 0o600))
+	scopes, err = loadAdminScopes(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-", "db-"}, scopes.Admins["alice"])
+	assert.Equal(t, []string{"cache-"}, scopes.Keys["team-key"])
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys": {"team-key": []}}`), 0o600))
+	_, err = loadAdminScopes(path)
+	assert.ErrorContains(t, err, "no server_id prefixes")
+	assert.NotContains(t, err.Error(), "team-key", "the key is not echoed")
+}
+
+func TestAdminScopeSecretKey(t *testing.T) {
+	isolatePendingRequests(t)
+	enableAdminScopes(t, adminScopeConfig{Keys: map[string][]string{webTeamKey: {"web-"}}})
+	router := setupRouter()
+
+	web := createTestRequest(t, router, "web-01")
+	db := createTestRequest(t, router, "db-01")
+
+	assert.Equal(t, http.StatusOK, adminCall(router, "GET", "/admin/approve/"+web, webTeamKey).Code, "in scope")
+	assert.True(t, getTestRequest(t, web).Approved)
+
+	assert.Equal(t, http.StatusForbidden, adminCall(router, "GET", "/admin/approve/"+db, webTeamKey).Code, "out of scope")
+	assert.Equal(t, http.StatusForbidden, adminCall(router, "GET", "/admin/deny/"+db, webTeamKey).Code)
+	assert.Equal(t, http.StatusForbidden, adminCall(router, "GET", "/admin/requests/"+db, webTeamKey).Code)
+	assert.Equal(t, http.StatusForbidden, adminCall(router, "DELETE", "/admin/requests?confirm=true", webTeamKey).Code)
+	assert.False(t, getTestRequest(t, db).Approved)
+	assert.False(t, getTestRequest(t, db).Denied)
+
+	w := adminCall(router, "GET", "/admin/requests", webTeamKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []requestSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	if assert.Len(t, listed, 1, "only in-scope requests are listed") {
+		assert.Equal(t, web, listed[0].RequestID)
+	}
+
+	// Admins without a scope are unrestricted
+	assert.Equal(t, http.StatusOK, adminCall(router, "GET", "/admin/approve/"+db, adminSecretKey).Code)
+}
+
+func TestAdminScopeJWT(t *testing.T) {
+	isolatePendingRequests(t)
+	key, publicPEM := generateJWTKey(t)
+	enableJWTAuth(t, publicPEM)
+	enableAdminScopes(t, adminScopeConfig{Admins: map[string][]string{"alice": {"web-"}}})
+	router := setupRouter()
+
+	token := signAdminJWT(t, key, adminClaims{
+		Admin:            "alice",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	web := createTestRequest(t, router, "web-01")
+	db := createTestRequest(t, router, "db-01")
+
+	assert.Equal(t, http.StatusOK, adminCall(router, "GET", "/admin/approve/"+web, token).Code)
+	assert.Equal(t, http.StatusForbidden, adminCall(router, "GET", "/admin/approve/"+db, token).Code)
+	assert.False(t, getTestRequest(t, db).Approved)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// notifierPinger is implemented by notifiers that can check their channel is
+// reachable without announcing anything
+type notifierPinger interface {
+	Ping() error
+}
+
+// Ping checks that every notifier able to is reachable
+func (m multiNotifier) Ping() error {
+	for _, n := range m {
+		if pinger, ok := n.(notifierPinger); ok {
+			if err := pinger.Ping(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ping checks that the webhook host answers. Posting would announce a message,
+// so any HTTP response counts as reachable.
+func (n *slackNotifier) Ping() error {
+	resp, err := outboundClient.Head(n.webhookURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ping checks the bot token with getMe
+func (n *telegramNotifier) Ping() error {
+	resp, err := outboundClient.Get(fmt.Sprintf("%s/bot%s/getMe", telegramAPIURL, n.token))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram getMe returned %s", resp.Status)
+	}
+	return nil
+}
+
+// runSelfTest checks the configuration through the same steps as startup,
+// without serving, and prints a summary to w. It stops at the first failing
+// step and returns the process exit code.
+func runSelfTest(w io.Writer) int {
+	fail := func(step string, err error) int {
+		fmt.Fprintf(w, "FAIL %s: %v\n", step, err)
+		return 1
+	}
+
+	if errs := validateConfig(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(w, "FAIL configuration: %v\n", err)
+		}
+		return 1
+	}
+	if err := loadConfig(); err != nil {
+		return fail("configuration", err)
+	}
+	fmt.Fprintf(w, "ok   configuration (approval timeout %s, %d approval(s) required)\n", approvalTimeout, requiredApprovals)
+
+	checkStore, err := openConfiguredStore()
+	if err != nil {
+		return fail("store", err)
+	}
+	if closer, ok := checkStore.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if pinger, ok := checkStore.(storePinger); ok {
+		if err := pinger.Ping(); err != nil {
+			return fail("store", err)
+		}
+	}
+	backend := storeBackend
+	if backend == "" {
+		backend = storeBackendMemory
+	}
+	fmt.Fprintf(w, "ok   store (%s)\n", backend)
+
+	if notifier == nil {
+		fmt.Fprintln(w, "ok   notifier (none configured)")
+	} else {
+		if pinger, ok := notifier.(notifierPinger); ok {
+			if err := pinger.Ping(); err != nil {
+				return fail("notifier", err)
+			}
+		}
+		fmt.Fprintln(w, "ok   notifier")
+	}
+
+	fmt.Fprintln(w, "self-test passed")
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setSelfTestConfig sets a valid configuration for the test and restores the
+// settings loadConfig changes afterwards
+func setSelfTestConfig(t *testing.T) {
+	t.Helper()
+	originalAdmin, originalServer, originalBind := adminSecretKeys, serverSecretKey, bindAddress
+	originalTimeout, originalKeys, originalNotifier := approvalTimeout, serverKeys, notifier
+	t.Cleanup(func() {
+		adminSecretKeys, serverSecretKey, bindAddress = originalAdmin, originalServer, originalBind
+		approvalTimeout, serverKeys, notifier = originalTimeout, originalKeys, originalNotifier
+	})
+	adminSecretKeys, serverSecretKey, bindAddress = []string{"0123456789abcdef"}, "0123456789abcdef", ":8080"
+	t.Setenv("APPROVAL_TIMEOUT", "5m")
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	setSelfTestConfig(t)
+
+	var out strings.Builder
+	assert.Equal(t, 0, runSelfTest(&out))
+	assert.Contains(t, out.String(), "ok   store (memory)")
+	assert.Contains(t, out.String(), "self-test passed")
+}
+
+func TestSelfTestMissingVariable(t *testing.T) {
+	setSelfTestConfig(t)
+	serverSecretKey = ""
+
+	var out strings.Builder
+	assert.NotEqual(t, 0, runSelfTest(&out))
+	assert.Contains(t, out.String(), "FAIL configuration: SERVER_SECRET_KEY is required")
+	assert.NotContains(t, out.String(), "self-test passed")
+}
+
+func TestSelfTestUnreachableNotifier(t *testing.T) {
+	setSelfTestConfig(t)
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer telegram.Close()
+	originalURL, originalSecret, originalAdmins := telegramAPIURL, telegramWebhookSecret, telegramAdmins
+	defer func() {
+		telegramAPIURL, telegramWebhookSecret, telegramAdmins = originalURL, originalSecret, originalAdmins
+	}()
+	telegramAPIURL = telegram.URL
+	t.Setenv("TELEGRAM_BOT_TOKEN", "bad-token")
+	t.Setenv("TELEGRAM_CHAT_ID", "-100123")
+	t.Setenv("TELEGRAM_WEBHOOK_SECRET", testTelegramSecret)
+
+	var out strings.Builder
+	assert.Equal(t, 1, runSelfTest(&out))
+	assert.Contains(t, out.String(), "FAIL notifier: telegram getMe returned 401")
+}
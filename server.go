@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultShutdownTimeout is used when SHUTDOWN_TIMEOUT is not set
+const defaultShutdownTimeout = 10 * time.Second
+
+// defaultReadHeaderTimeout is used when READ_HEADER_TIMEOUT is not set. It bounds
+// how long a client may trickle request headers, the slowloris attack.
+const defaultReadHeaderTimeout = 10 * time.Second
+
+// defaultIdleTimeout is used when IDLE_TIMEOUT is not set
+const defaultIdleTimeout = 2 * time.Minute
+
+// longPollTimeoutMargin is added to MAX_LONGPOLL for the default READ_TIMEOUT
+// and WRITE_TIMEOUT, so a full long poll still fits in them
+const longPollTimeoutMargin = 30 * time.Second
+
+// HTTP server settings parsed by loadConfig
+var (
+	enableH2C         bool // serve HTTP/2 over cleartext connections as well as HTTP/1.1
+	httpKeepAlive     = true
+	readHeaderTimeout = defaultReadHeaderTimeout
+	readTimeout       time.Duration // 0 disables it
+	writeTimeout      time.Duration // 0 disables it
+	idleTimeout       = defaultIdleTimeout
+)
+
+// newHTTPServer returns the server for handler with the configured TLS,
+// timeouts and keep-alive setting
+func newHTTPServer(handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	srv.SetKeepAlivesEnabled(httpKeepAlive)
+	return srv
+}
+
+// parseLongPollTimeout parses the READ_TIMEOUT or WRITE_TIMEOUT value raw. The
+// deadlines span the whole call, so they must outlast MAX_LONGPOLL or would cut
+// long polls short. Unset defaults to MAX_LONGPOLL plus a margin, 0 disables it.
+func parseLongPollTimeout(name, raw string) (time.Duration, error) {
+	if raw == "" {
+		return maxLongPoll + longPollTimeoutMargin, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	if timeout > 0 && timeout <= maxLongPoll {
+		return 0, fmt.Errorf("%s %s must be longer than MAX_LONGPOLL %s", name, timeout, maxLongPoll)
+	}
+	return timeout, nil
+}
+
+// runServer serves on ln until ctx is done, then stops accepting connections
+// and waits up to timeout for in-flight requests to finish. It serves HTTPS
+// when srv.TLSConfig is set.
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			serveErr <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// defaultCleanupInterval is used when CLEANUP_INTERVAL is not set
+const defaultCleanupInterval = time.Minute
+
+// startCleanup removes expired requests every interval in the background until
+// ctx is done. The returned channel is closed once the loop has stopped.
+func startCleanup(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cleanupExpiredRequests()
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestRunServerGracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	url := "http://" + ln.Addr().String()
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	mux.HandleFunc("/pingz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- runServer(ctx, &http.Server{Handler: mux}, ln, 5*time.Second)
+	}()
+
+	type result struct {
+		code int
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		inFlight <- result{code: resp.StatusCode, body: string(body)}
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+
+	// New connections are refused once shutdown has started
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: time.Second}
+	_, err = client.Get(url + "/pingz")
+	assert.Error(t, err)
+
+	// The in-flight request still completes
+	res := <-inFlight
+	require.NoError(t, res.err)
+	assert.Equal(t, http.StatusOK, res.code)
+	assert.Equal(t, "done", res.body)
+
+	select {
+	case err := <-serverDone:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServer did not return after shutdown")
+	}
+}
+
+func TestStartCleanupStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startCleanup(ctx, time.Minute)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup did not stop after cancellation")
+	}
+}
+
+func TestStartCleanupRemovesExpired(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, reqID, func(req *Request) {
+		req.ExpiresAt = time.Now().Add(-time.Second)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startCleanup(ctx, 50*time.Millisecond)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Nothing touches the request, only the background loop can remove it
+	assert.Eventually(t, func() bool {
+		return getTestRequest(t, reqID) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, recentlyExpired.contains(reqID))
+}
+
+// h2cGet fetches url over HTTP/2 with prior knowledge, without TLS
+func h2cGet(url string) (*http.Response, error) {
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+	return client.Get(url)
+}
+
+func TestH2C(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		original := enableH2C
+		enableH2C = enabled
+		router := setupRouter()
+		enableH2C = original
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		serverDone := make(chan error, 1)
+		go func() { serverDone <- runServer(ctx, newHTTPServer(router.Handler()), ln, time.Second) }()
+
+		resp, err := h2cGet("http://" + ln.Addr().String() + "/pingz")
+		if enabled {
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, 2, resp.ProtoMajor)
+			assert.Contains(t, string(body), "pong")
+		} else {
+			assert.Error(t, err, "HTTP/2 without TLS is refused unless ENABLE_H2C is set")
+		}
+
+		cancel()
+		require.NoError(t, <-serverDone)
+	}
+}
+
+func TestParseLongPollTimeout(t *testing.T) {
+	original := maxLongPoll
+	defer func() { maxLongPoll = original }()
+	maxLongPoll = time.Minute
+
+	timeout, err := parseLongPollTimeout("READ_TIMEOUT", "")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute+longPollTimeoutMargin, timeout)
+
+	timeout, err = parseLongPollTimeout("READ_TIMEOUT", "0")
+	require.NoError(t, err)
+	assert.Zero(t, timeout)
+
+	timeout, err = parseLongPollTimeout("READ_TIMEOUT", "2m")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, timeout)
+
+	_, err = parseLongPollTimeout("WRITE_TIMEOUT", "30s")
+	assert.ErrorContains(t, err, "must be longer than MAX_LONGPOLL")
+	_, err = parseLongPollTimeout("WRITE_TIMEOUT", "soon")
+	assert.ErrorContains(t, err, "invalid WRITE_TIMEOUT")
+}
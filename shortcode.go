@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// shortCodes gives each request a short approval code admins can type instead
+// of the UUID, set from SHORT_CODES. Servers keep using the full request ID.
+var shortCodes bool
+
+// shortCodeAlphabet is base32 without the easily confused 0, O, 1 and I.
+// Its 32 symbols divide 256, so taking random bytes modulo it is unbiased.
+const shortCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// shortCodeLength gives 40 bits, plenty for the requests live at one time
+const shortCodeLength = 8
+
+// newShortCode generates a random approval code, replaceable in tests
+var newShortCode = func() string {
+	random := make([]byte, shortCodeLength)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	code := make([]byte, shortCodeLength)
+	for i, b := range random {
+		code[i] = shortCodeAlphabet[int(b)%len(shortCodeAlphabet)]
+	}
+	return string(code)
+}
+
+// normalizeShortCode returns raw as a canonical approval code, accepting
+// lower case and a separating hyphen as typed in by hand
+func normalizeShortCode(raw string) (string, bool) {
+	code := strings.ToUpper(strings.ReplaceAll(raw, "-", ""))
+	if len(code) != shortCodeLength {
+		return "", false
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(shortCodeAlphabet, r) {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// assignShortCode gives req an approval code not used by any live request,
+// regenerating on collision. The caller must hold every lock.
+func assignShortCode(requests []*Request, req *Request) bool {
+	inUse := make(map[string]bool)
+	for _, existing := range requests {
+		if existing.ShortCode != "" && !isRequestExpired(existing) {
+			inUse[existing.ShortCode] = true
+		}
+	}
+	for attempt := 0; attempt < maxRequestIDAttempts; attempt++ {
+		code := newShortCode()
+		if !inUse[code] {
+			req.ShortCode = code
+			return true
+		}
+	}
+	return false
+}
+
+// findByShortCode returns the ID of the request with the given code. A live
+// request wins over an expired one still waiting for cleanup.
+func findByShortCode(requests []*Request, code string) (string, bool) {
+	var expiredID string
+	for _, req := range requests {
+		if req.ShortCode != code {
+			continue
+		}
+		if !isRequestExpired(req) {
+			return req.ID, true
+		}
+		expiredID = req.ID
+	}
+	return expiredID, expiredID != ""
+}
+
+// resolveRequestID maps the :req_id of an admin path, either a request UUID or
+// an approval code, to the request ID. It responds with an error and returns
+// false if raw is neither or no request has that code.
+func resolveRequestID(c *gin.Context, raw string) (string, bool) {
+	if _, err := uuid.Parse(raw); err == nil {
+		return raw, true
+	}
+	code, ok := normalizeShortCode(raw)
+	if !shortCodes || !ok {
+		respondError(c, http.StatusBadRequest, errCodeInvalidRequestID, "Invalid request ID format")
+		return "", false
+	}
+
+	requestLocks.RLockAll()
+	requests, err := store.List()
+	requestLocks.RUnlockAll()
+	if err != nil {
+		respondStoreError(c, err)
+		return "", false
+	}
+	reqID, found := findByShortCode(requests, code)
+	if !found {
+		respondError(c, http.StatusNotFound, errCodeRequestNotFound, "Request not found.")
+		return "", false
+	}
+	return reqID, true
+}
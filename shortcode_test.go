@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enableShortCodes(t *testing.T) {
+	t.Helper()
+	original := shortCodes
+	shortCodes = true
+	t.Cleanup(func() { shortCodes = original })
+}
+
+func TestNewShortCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		code := newShortCode()
+		assert.Len(t, code, shortCodeLength)
+		normalized, ok := normalizeShortCode(code)
+		assert.True(t, ok, "generated code %s should be valid", code)
+		assert.Equal(t, code, normalized)
+		assert.False(t, seen[code], "code %s generated twice", code)
+		seen[code] = true
+	}
+	assert.False(t, strings.ContainsAny(shortCodeAlphabet, "0O1Il"))
+}
+
+func TestNormalizeShortCode(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"K7QM3XPA", "K7QM3XPA", true},
+		{"k7qm-3xpa", "K7QM3XPA", true},
+		{"K7QM3XP", "", false},
+		{"K7QM3XP0", "", false},
+		{"550e8400-e29b-41d4-a716-446655440000", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, ok := normalizeShortCode(tt.raw)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestShortCodeCollision(t *testing.T) {
+	isolatePendingRequests(t)
+	enableShortCodes(t)
+
+	codes := []string{"AAAAAAAA", "AAAAAAAA", "BBBBBBBB"}
+	originalGenerator := newShortCode
+	newShortCode = func() string {
+		code := codes[0]
+		codes = codes[1:]
+		return code
+	}
+	defer func() { newShortCode = originalGenerator }()
+
+	router := setupRouter()
+	first := createTestRequest(t, router, "test-server")
+	second := createTestRequest(t, router, "test-server")
+
+	assert.Equal(t, "AAAAAAAA", getTestRequest(t, first).ShortCode)
+	assert.Equal(t, "BBBBBBBB", getTestRequest(t, second).ShortCode, "colliding code should be regenerated")
+}
+
+func TestApproveWithShortCode(t *testing.T) {
+	isolatePendingRequests(t)
+	enableShortCodes(t)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	jsonBody, _ := json.Marshal(map[string]string{"server_id": "test-server"})
+	req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	reqID := response["request_id"].(string)
+	code := response["approval_code"].(string)
+	require.Len(t, code, shortCodeLength)
+
+	approve := func(ref string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/approve/"+ref, nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, approve("ZZZZZZZZ"))
+	assert.Equal(t, http.StatusBadRequest, approve("not-a-code"))
+	assert.Equal(t, http.StatusOK, approve(strings.ToLower(code)))
+	assert.True(t, getTestRequest(t, reqID).Approved)
+
+	// An expired code is rejected, immediately and after cleanup
+	expired := createTestRequest(t, router, "test-server")
+	expiredCode := getTestRequest(t, expired).ShortCode
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	assert.Equal(t, http.StatusGone, approve(expiredCode))
+	assert.Equal(t, http.StatusNotFound, approve(expiredCode))
+}
+
+func TestShortCodesDisabled(t *testing.T) {
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/K7QM3XPA", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestShortCodeInspectAndExtend(t *testing.T) {
+	isolatePendingRequests(t)
+	enableShortCodes(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	stored := getTestRequest(t, reqID)
+	w := adminCall(router, "GET", "/admin/requests/"+strings.ToLower(stored.ShortCode), adminSecretKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), reqID)
+
+	w = adminCall(router, "POST", "/admin/requests/"+stored.ShortCode+"/extend", adminSecretKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, getTestRequest(t, reqID).ExpiresAt.After(stored.ExpiresAt))
+}
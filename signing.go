@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the request body, keyed with
+// the signing secret of the server making the call
+const signatureHeader = "X-Signature"
+
+// signingEnvPrefix is the prefix of environment variables holding per-server
+// signing secrets, SIGNING_SECRET_<server_id>=<secret>
+const signingEnvPrefix = "SIGNING_SECRET_"
+
+// Signature modes selectable via SERVER_SIGNATURES
+const (
+	signaturesOff      = "off"
+	signaturesOptional = "optional"
+	signaturesRequired = "required"
+)
+
+// serverSignatures is the signature mode of the server endpoints. Optional
+// verifies signatures that are sent but still accepts bearer-only calls, for
+// migrating servers one at a time.
+var serverSignatures = signaturesOff
+
+// signingSecrets maps each server_id to its signing secret
+var signingSecrets map[string]string
+
+// maxSignedBody bounds how much of a server call is read to verify it
+const maxSignedBody = 1 << 20
+
+// loadSigningSecrets reads the secrets from SIGNING_SECRETS_FILE and
+// SIGNING_SECRET_<server_id> entries in environ
+func loadSigningSecrets(path string, environ []string) (map[string]string, error) {
+	return loadServerSecrets(path, signingEnvPrefix, "signing secrets file", "signing secret", environ)
+}
+
+// signBody returns the hex HMAC-SHA256 of body under secret
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedClaims are the fields of a server call body that decide whose
+// signing secret it is checked against
+type signedClaims struct {
+	ServerID  string   `json:"server_id"`
+	ServerIDs []string `json:"server_ids"`
+	ReqID     string   `json:"req_id"`
+}
+
+// parseSignedClaims returns the claims of body, false if it is not JSON.
+// An empty body claims nothing.
+func parseSignedClaims(body []byte) (signedClaims, bool) {
+	var claims signedClaims
+	if len(body) == 0 {
+		return claims, true
+	}
+	return claims, json.Unmarshal(body, &claims) == nil
+}
+
+// signedServerID returns the server a call acts for: for calls naming a live
+// req_id the server that created it, whatever server_id the body claims, and
+// otherwise the server_id in the body. The second result is false only for a
+// call naming neither a server_id nor a live request, which cannot release
+// anything. A body that is not JSON names no server and fails verification.
+func signedServerID(body []byte) (string, bool, error) {
+	claims, ok := parseSignedClaims(body)
+	if !ok {
+		return "", true, nil
+	}
+	if claims.ReqID != "" {
+		requestLocks.RLock(claims.ReqID)
+		req, exists, err := store.Get(claims.ReqID)
+		requestLocks.RUnlock(claims.ReqID)
+		if err != nil {
+			return "", false, err
+		}
+		if exists {
+			return req.ServerID, true, nil
+		}
+		if claims.ServerID == "" {
+			return "", false, nil
+		}
+	}
+	return claims.ServerID, true, nil
+}
+
+// verifyBatchSignature checks a request-keys batch, which acts for every
+// server it lists: signature holds one signature of body per server_ids
+// entry, comma-separated and in the same order, each keyed with the signing
+// secret of that server
+func verifyBatchSignature(body []byte, serverIDs []string, signature string) bool {
+	signatures := strings.Split(signature, ",")
+	if len(signatures) != len(serverIDs) {
+		return false
+	}
+	valid := true
+	for i, serverID := range serverIDs {
+		secret, ok := signingSecrets[serverID]
+		if !ok || !hmac.Equal([]byte(strings.TrimSpace(signatures[i])), []byte(signBody(secret, body))) {
+			valid = false
+		}
+	}
+	return valid
+}
+
+// requireServerSignature verifies the X-Signature of server calls against the
+// signing secret of the server_id they claim, binding the call to that server
+// rather than to anyone holding SERVER_SECRET_KEY. It runs after the bearer check.
+func requireServerSignature() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signature := c.GetHeader(signatureHeader)
+		if serverSignatures == signaturesOff || (signature == "" && serverSignatures == signaturesOptional) {
+			c.Next()
+			return
+		}
+		if signature == "" {
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, signatureHeader+" header is required")
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBody))
+		if isBodyTooLarge(err) {
+			respondBodyTooLarge(c)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Could not read request body")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The signature covers only the body: a req_id named by the URL alone
+		// would leave it the same for every call by the server, replayable
+		// against any of its requests
+		claims, _ := parseSignedClaims(body)
+		if claims.ReqID == "" && urlRequestID(c) != "" {
+			respondError(c, http.StatusBadRequest, errCodeBadRequest, "Signed calls must name req_id in the body")
+			c.Abort()
+			return
+		}
+		if len(claims.ServerIDs) > 0 {
+			if !verifyBatchSignature(body, claims.ServerIDs, signature) {
+				respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid signature, a batch needs one per server")
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		serverID, found, err := signedServerID(body)
+		if err != nil {
+			respondStoreError(c, err)
+			c.Abort()
+			return
+		}
+		if !found {
+			// The request is gone, the handler reports it without releasing anything
+			c.Next()
+			return
+		}
+		secret, ok := signingSecrets[serverID]
+		if !ok {
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "No signing secret for server")
+			c.Abort()
+			return
+		}
+		if !hmac.Equal([]byte(signature), []byte(signBody(secret, body))) {
+			respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid signature")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableSignatures sets the signature mode and per-server secrets for the test
+func enableSignatures(t *testing.T, mode string, secrets map[string]string) {
+	t.Helper()
+	originalMode, originalSecrets := serverSignatures, signingSecrets
+	serverSignatures, signingSecrets = mode, secrets
+	t.Cleanup(func() { serverSignatures, signingSecrets = originalMode, originalSecrets })
+}
+
+// signedServerCall posts body to path with the bearer key and, if set, signature
+func signedServerCall(router http.Handler, path, body, signature string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set(signatureHeader, signature)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoadSigningSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"darkstar": "file-secret"}`), 0o600))
+
+	secrets, err := loadSigningSecrets(path, []string{"SIGNING_SECRET_nova=env-secret", "KEY_pulsar=key"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"darkstar": "file-secret", "nova": "env-secret"}, secrets)
+
+	_, err = loadSigningSecrets("", []string{"SIGNING_SECRET_nova="})
+	assert.ErrorContains(t, err, "empty signing secret")
+}
+
+func TestServerSignatures(t *testing.T) {
+	isolatePendingRequests(t)
+	enableSignatures(t, signaturesRequired, map[string]string{"test-server": "test-server-secret", "other-server": "other-secret"})
+	router := setupRouter()
+
+	body := `{"server_id":"test-server"}`
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		wantCode  int
+	}{
+		{"valid signature", body, signBody("test-server-secret", []byte(body)), http.StatusAccepted},
+		{"tampered body", `{"server_id":"test-server","reason":"x"}`, signBody("test-server-secret", []byte(body)), http.StatusUnauthorized},
+		{"signed by another server", body, signBody("other-secret", []byte(body)), http.StatusUnauthorized},
+		{"unknown server_id", `{"server_id":"rogue"}`, signBody("test-server-secret", []byte(`{"server_id":"rogue"}`)), http.StatusUnauthorized},
+		{"missing signature", body, "", http.StatusUnauthorized},
+		{
+			"unknown req_id does not skip verification", `{"server_id":"test-server","req_id":"nil"}`,
+			signBody("other-secret", []byte(`{"server_id":"test-server","req_id":"nil"}`)), http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := signedServerCall(router, "/server/request-key", tt.body, tt.signature)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestServerSignatureGetKeyBoundToRequest(t *testing.T) {
+	isolatePendingRequests(t)
+	enableSignatures(t, signaturesRequired, map[string]string{"test-server": "test-server-secret", "other-server": "other-secret"})
+	router := setupRouter()
+
+	create := `{"server_id":"test-server"}`
+	w := signedServerCall(router, "/server/request-key", create, signBody("test-server-secret", []byte(create)))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	reqID := created["request_id"].(string)
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+
+	// Another server cannot fetch the key, even claiming its own server_id
+	spoofed := `{"req_id":"` + reqID + `","server_id":"other-server"}`
+	w = signedServerCall(router, "/server/get-key", spoofed, signBody("other-secret", []byte(spoofed)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Nor by naming the request in the URL instead of the body
+	claimed := `{"server_id":"other-server"}`
+	w = signedServerCall(router, "/server/get-key?req_id="+reqID, claimed, signBody("other-secret", []byte(claimed)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = signedServerCall(router, "/server/get-key/"+reqID, "", signBody("other-secret", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	fetch := `{"req_id":"` + reqID + `"}`
+	w = signedServerCall(router, "/server/get-key", fetch, signBody("test-server-secret", []byte(fetch)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "test-decryption-key")
+
+	// A signature of the empty body would be the same for every request of
+	// the server, so signed calls must name the request in the body
+	for _, path := range []string{"/server/get-key?req_id=" + reqID, "/server/get-key/" + reqID} {
+		w = signedServerCall(router, path, "", signBody("test-server-secret", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Contains(t, w.Body.String(), "req_id in the body")
+	}
+	w = signedServerCall(router, "/server/get-key/"+reqID, fetch, signBody("test-server-secret", []byte(fetch)))
+	assert.Equal(t, http.StatusOK, w.Code, "the body names the request")
+}
+
+func TestServerSignatureBatch(t *testing.T) {
+	isolatePendingRequests(t)
+	enableSignatures(t, signaturesRequired, map[string]string{"test-server": "test-server-secret", "other-server": "other-secret"})
+	router := setupRouter()
+
+	body := `{"server_ids":["test-server","other-server"]}`
+	signed := func(secrets ...string) string {
+		signatures := make([]string, len(secrets))
+		for i, secret := range secrets {
+			signatures[i] = signBody(secret, []byte(body))
+		}
+		return strings.Join(signatures, ",")
+	}
+
+	tests := []struct {
+		name      string
+		signature string
+		wantCode  int
+	}{
+		{"every server signs", signed("test-server-secret", "other-secret"), http.StatusAccepted},
+		{"one server signs", signed("test-server-secret"), http.StatusUnauthorized},
+		{"one secret for both", signed("test-server-secret", "test-server-secret"), http.StatusUnauthorized},
+		{"out of order", signed("other-secret", "test-server-secret"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := signedServerCall(router, "/server/request-keys", body, tt.signature)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+		})
+	}
+
+	unknown := `{"server_ids":["test-server","rogue"]}`
+	w := signedServerCall(router, "/server/request-keys", unknown,
+		signBody("test-server-secret", []byte(unknown))+","+signBody("test-server-secret", []byte(unknown)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "every listed server needs a signing secret")
+}
+
+func TestServerSignaturesOptional(t *testing.T) {
+	isolatePendingRequests(t)
+	enableSignatures(t, signaturesOptional, map[string]string{"test-server": "test-server-secret"})
+	router := setupRouter()
+
+	body := `{"server_id":"test-server"}`
+	assert.Equal(t, http.StatusAccepted, signedServerCall(router, "/server/request-key", body, "").Code, "bearer-only still accepted")
+	assert.Equal(t, http.StatusAccepted, signedServerCall(router, "/server/request-key", body, signBody("test-server-secret", []byte(body))).Code)
+	assert.Equal(t, http.StatusUnauthorized, signedServerCall(router, "/server/request-key", body, "bad").Code, "a sent signature must be valid")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// defaultSMTPPort is used when SMTP_PORT is not set, the submission port
+const defaultSMTPPort = "587"
+
+// smtpNotifier emails new requests to the admins
+type smtpNotifier struct {
+	addr     string // host:port
+	host     string
+	user     string
+	pass     string
+	from     string
+	to       []string
+	startTLS bool // upgrade with STARTTLS and refuse servers not offering it
+}
+
+func newSMTPNotifier(host, port, user, pass, from string, to []string, startTLS bool) *smtpNotifier {
+	return &smtpNotifier{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		user:     user,
+		pass:     pass,
+		from:     from,
+		to:       to,
+		startTLS: startTLS,
+	}
+}
+
+// parseAddressList splits the comma-separated SMTP_TO
+func parseAddressList(list string) []string {
+	addresses := []string{}
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// smtpMessage formats the email announcing req
+func (n *smtpNotifier) smtpMessage(req *Request) []byte {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: szlaban: key request from %s\r\n", req.ServerID)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(notificationText(req), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return []byte(msg.String())
+}
+
+// dial connects and greets the server, upgrading to TLS and authenticating as
+// configured. The connection gives up when ctx is done or OUTBOUND_TIMEOUT passes.
+func (n *smtpNotifier) dial(ctx context.Context) (*smtp.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, outboundClient.Timeout)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// net/smtp has no context support, the deadline bounds the whole exchange
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	cancel()
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if n.startTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("smtp server %s does not offer STARTTLS", n.addr)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: n.host, MinVersion: tls.VersionTLS12}); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if n.user != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.user, n.pass, n.host)); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, req *Request) error {
+	client, err := n.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.smtpMessage(req)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Ping connects, upgrades and authenticates without sending anything
+func (n *smtpNotifier) Ping() error {
+	client, err := n.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Quit()
+}
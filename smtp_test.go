@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capturedMail is one message received by the mock SMTP server
+type capturedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// mockSMTPServer accepts mail without TLS or authentication and sends every
+// message it receives to the returned channel
+func mockSMTPServer(t *testing.T) (string, string, <-chan capturedMail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan capturedMail, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveMockSMTP(conn, mails)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, mails
+}
+
+func serveMockSMTP(conn net.Conn, mails chan<- capturedMail) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var mail capturedMail
+	reply("220 mock ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 mock")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			mail.from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			mail.to = append(mail.to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			mail.data = data.String()
+			mails <- mail
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPNotification(t *testing.T) {
+	host, port, mails := mockSMTPServer(t)
+	originalNotifier := notifier
+	notifier = newSMTPNotifier(host, port, "", "", "szlaban@example.com", []string{"ops@example.com", "sec@example.com"}, false)
+	defer func() { notifier = originalNotifier }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "mail-server")
+
+	select {
+	case mail := <-mails:
+		assert.Equal(t, "szlaban@example.com", mail.from)
+		assert.Equal(t, []string{"ops@example.com", "sec@example.com"}, mail.to)
+		assert.Contains(t, mail.data, "Subject: szlaban: key request from mail-server\r\n")
+		assert.Contains(t, mail.data, "To: ops@example.com, sec@example.com\r\n")
+		assert.Contains(t, mail.data, "/admin/approve/"+reqID)
+		assert.Contains(t, mail.data, "/admin/deny/"+reqID)
+		assert.Contains(t, mail.data, getTestRequest(t, reqID).IP)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+	}
+}
+
+func TestSMTPRequiresStartTLS(t *testing.T) {
+	host, port, _ := mockSMTPServer(t)
+	n := newSMTPNotifier(host, port, "", "", "szlaban@example.com", []string{"ops@example.com"}, true)
+	err := n.Notify(context.Background(), &Request{ID: "id", ServerID: "darkstar"})
+	assert.ErrorContains(t, err, "does not offer STARTTLS", "mail is not sent in the clear when STARTTLS is expected")
+}
+
+func TestParseAddressList(t *testing.T) {
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, parseAddressList(" a@example.com, ,b@example.com "))
+	assert.Empty(t, parseAddressList(""))
+}
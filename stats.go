@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// requestStats is the summary returned by /admin/stats
+type requestStats struct {
+	Pending  int `json:"pending"`
+	Approved int `json:"approved"` // approved and not expired, the key not fetched yet
+	Denied   int `json:"denied"`
+	// Requests created by this process since it started, for every server
+	// even for a scoped admin, since the counter is not kept per server
+	TotalCreated int64 `json:"total_created"`
+	// Age of the oldest pending request, 0 when none is pending
+	OldestPendingAgeSeconds int64 `json:"oldest_pending_age_seconds"`
+}
+
+// computeRequestStats counts requests in one pass, ignoring expired ones
+func computeRequestStats(requests []*Request, now time.Time) requestStats {
+	var stats requestStats
+	var oldest time.Time
+	for _, req := range requests {
+		if isRequestExpired(req) {
+			continue
+		}
+		switch {
+		case req.Denied:
+			stats.Denied++
+		case req.Approved && req.FetchedAt.IsZero():
+			stats.Approved++
+		case req.Approved:
+		default:
+			stats.Pending++
+			if oldest.IsZero() || req.CreatedAt.Before(oldest) {
+				oldest = req.CreatedAt
+			}
+		}
+	}
+	if !oldest.IsZero() {
+		stats.OldestPendingAgeSeconds = int64(now.Sub(oldest).Seconds())
+	}
+	return stats
+}
+
+// requestsCreatedTotal reads the received requests counter
+func requestsCreatedTotal() int64 {
+	var metric dto.Metric
+	if err := requestsReceived.Write(&metric); err != nil {
+		return 0
+	}
+	return int64(metric.GetCounter().GetValue())
+}
+
+// handleAdminStats returns request counts for dashboards that only need a
+// badge, without transferring the full list
+func handleAdminStats(c *gin.Context) {
+	requestLocks.RLockAll()
+	defer requestLocks.RUnlockAll()
+
+	requests, err := store.List()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	stats := computeRequestStats(slices.DeleteFunc(requests, func(req *Request) bool {
+		return !inAdminScope(c, req.ServerID)
+	}), time.Now())
+	stats.TotalCreated = requestsCreatedTotal()
+	c.JSON(http.StatusOK, stats)
+}
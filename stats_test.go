@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestStats(t *testing.T, router http.Handler) requestStats {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats requestStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	return stats
+}
+
+func TestAdminStats(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+	createdBefore := getTestStats(t, router).TotalCreated
+
+	approved := createTestRequest(t, router, "test-server")
+	approvedToo := createTestRequest(t, router, "test-server")
+	denied := createTestRequest(t, router, "test-server")
+	pending := createTestRequest(t, router, "test-server")
+	expired := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+approved, adminSecretKey)
+	adminCall(router, "GET", "/admin/approve/"+approvedToo, adminSecretKey)
+	adminCall(router, "GET", "/admin/deny/"+denied, adminSecretKey)
+	updateTestRequest(t, pending, func(req *Request) {
+		req.CreatedAt = time.Now().Add(-90 * time.Second)
+	})
+	updateTestRequest(t, expired, func(req *Request) {
+		req.CreatedAt = time.Now().Add(-time.Hour)
+		req.ExpiresAt = time.Now().Add(-time.Minute)
+	})
+
+	stats := getTestStats(t, router)
+	assert.Equal(t, 1, stats.Pending, "expired requests are not pending")
+	assert.Equal(t, 2, stats.Approved)
+
+	// Fetched keys no longer count as approved
+	code, _ := fetchTestKey(t, router, approvedToo)
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, getTestRequest(t, approvedToo).FetchedAt.IsZero())
+	stats = getTestStats(t, router)
+	assert.Equal(t, 1, stats.Approved)
+	assert.Equal(t, 1, stats.Denied)
+	assert.Equal(t, createdBefore+5, stats.TotalCreated)
+	assert.InDelta(t, 90, stats.OldestPendingAgeSeconds, 5)
+}
+
+func TestAdminStatsEmpty(t *testing.T) {
+	isolatePendingRequests(t)
+	stats := getTestStats(t, setupRouter())
+	assert.Zero(t, stats.Pending)
+	assert.Zero(t, stats.OldestPendingAgeSeconds)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// handleServerStatus reports the state of a request without ever releasing its
+// key, so servers can poll cheaply and call get-key once it is approved. Every
+// state answers 200, the state itself is in "status".
+func handleServerStatus(c *gin.Context) {
+	var json struct {
+		ReqID string `json:"req_id" binding:"required"`
+	}
+	if !bindJSON(c, &json) {
+		return
+	}
+
+	// Validate UUID format
+	if _, err := uuid.Parse(json.ReqID); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeInvalidRequestID, "Invalid request ID format")
+		return
+	}
+	logKeyRequest(c, json.ReqID)
+
+	requestLocks.RLock(json.ReqID)
+	defer requestLocks.RUnlock(json.ReqID)
+
+	req, exists, err := store.Get(json.ReqID)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	status := requestState(json.ReqID, req, exists)
+	response := gin.H{"request_id": json.ReqID, "status": status}
+	switch {
+	case status == "pending":
+		response["poll_interval_seconds"] = int(currentPollInterval().Seconds())
+	case status == "approved" && time.Now().Before(req.ReleaseAt):
+		response["release_at"] = req.ReleaseAt.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// requestState names the state of reqID with the get-key status strings.
+// Expired requests are reported without being removed, cleanup does that.
+func requestState(reqID string, req *Request, exists bool) string {
+	switch {
+	case !exists && recentlyExpired.contains(reqID):
+		return "expired"
+	case !exists && recentlyConsumed.contains(reqID):
+		return "consumed"
+	case !exists && hiddenNotFound(reqID):
+		return "pending"
+	case !exists:
+		return "not_found"
+	case isRequestExpired(req):
+		return "expired"
+	case req.Denied:
+		return "denied"
+	case req.Approved:
+		return "approved"
+	default:
+		return "pending"
+	}
+}
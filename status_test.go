@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerStatus(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	pending := createTestRequest(t, router, "test-server")
+	approved := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, approved, func(req *Request) { req.Approved = true })
+	withheld := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, withheld, func(req *Request) {
+		req.Approved = true
+		req.ReleaseAt = time.Now().Add(time.Hour)
+	})
+	denied := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, denied, func(req *Request) { req.Denied = true })
+	expired := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, expired, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Minute) })
+	cleanedUp := uuid.NewString()
+	recentlyExpired.add(cleanedUp)
+	consumed := uuid.NewString()
+	recentlyConsumed.add(consumed)
+
+	tests := []struct {
+		name   string
+		reqID  string
+		status string
+	}{
+		{"pending", pending, "pending"},
+		{"approved", approved, "approved"},
+		{"approved before release_at", withheld, "approved"},
+		{"denied", denied, "denied"},
+		{"expired", expired, "expired"},
+		{"expired and cleaned up", cleanedUp, "expired"},
+		{"consumed", consumed, "consumed"},
+		{"unknown", uuid.NewString(), "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := probeTestRequest(router, "/server/status", map[string]string{"req_id": tt.reqID})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var response map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.status, response["status"])
+			assert.Equal(t, tt.reqID, response["request_id"])
+			assert.NotContains(t, response, "key", "status never releases the key")
+		})
+	}
+
+	w, _ := probeTestRequest(router, "/server/status", map[string]string{"req_id": withheld})
+	assert.Contains(t, w.Body.String(), `"release_at"`)
+	w, _ = probeTestRequest(router, "/server/status", map[string]string{"req_id": pending})
+	assert.Contains(t, w.Body.String(), `"poll_interval_seconds"`)
+	assert.NotNil(t, getTestRequest(t, expired), "status leaves expired requests to cleanup")
+
+	w, _ = probeTestRequest(router, "/server/status", map[string]string{"req_id": "not-a-uuid"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, errCodeInvalidRequestID, responseError(t, w).Code)
+}
+
+func TestServerStatusHardened(t *testing.T) {
+	isolatePendingRequests(t)
+	originalHarden, originalDelay := hardenEnumeration, enumerationDelay
+	hardenEnumeration, enumerationDelay = true, 0
+	defer func() { hardenEnumeration, enumerationDelay = originalHarden, originalDelay }()
+	router := setupRouter()
+
+	createTestRequest(t, router, "test-server")
+	w, _ := probeTestRequest(router, "/server/status", map[string]string{"req_id": uuid.NewString()})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"pending"`)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Storage backends selectable via STORE_BACKEND
+const (
+	storeBackendMemory = "memory"
+	storeBackendSQLite = "sqlite"
+	storeBackendRedis  = "redis"
+)
+
+// Store persists key requests. Implementations must return copies so that
+// changes to a request only take effect once it is saved.
+type Store interface {
+	Save(req *Request) error
+	Get(id string) (*Request, bool, error)
+	Delete(id string) error
+	// Take deletes the request and reports whether it was still stored. Of
+	// concurrent calls for one ID, even from instances sharing the store, only
+	// one sees true.
+	Take(id string) (bool, error)
+	List() ([]*Request, error)
+}
+
+// store is the backend used by the handlers, selected by openStore at startup
+var store Store = newMemoryStore()
+
+// storeConfig holds the settings of the storage backends
+type storeConfig struct {
+	SQLitePath    string
+	RedisAddr     string
+	RedisPassword string
+}
+
+// openStore creates the storage backend selected by STORE_BACKEND
+func openStore(backend string, config storeConfig) (Store, error) {
+	switch backend {
+	case "", storeBackendMemory:
+		return newMemoryStore(), nil
+	case storeBackendSQLite:
+		return newSQLiteStore(config.SQLitePath)
+	case storeBackendRedis:
+		return newRedisStore(config.RedisAddr, config.RedisPassword)
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+}
+
+// storeShared reports whether the store is shared by several instances, which
+// keep their own configuration and in-memory state such as rotated keys
+func storeShared() bool {
+	_, shared := store.(*redisStore)
+	return shared
+}
+
+// clone returns a copy of the request that shares no mutable state with it
+func (r *Request) clone() *Request {
+	copied := *r
+	copied.ApprovedBy = slices.Clone(r.ApprovedBy)
+	copied.Tags = slices.Clone(r.Tags)
+	return &copied
+}
+
+// memoryStore keeps requests in process memory, they are lost on restart.
+// The map is split into shards keyed like requestLocks, each with its own
+// lock, so calls on different requests do not contend.
+type memoryStore struct {
+	shards []memoryShard
+}
+
+type memoryShard struct {
+	mu       sync.RWMutex
+	requests map[string]*Request
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{shards: make([]memoryShard, defaultLockShards)}
+	for i := range s.shards {
+		s.shards[i].requests = make(map[string]*Request)
+	}
+	return s
+}
+
+func (s *memoryStore) shard(id string) *memoryShard {
+	return &s.shards[shardIndex(id, len(s.shards))]
+}
+
+func (s *memoryStore) Save(req *Request) error {
+	shard := s.shard(req.ID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.requests[req.ID] = req.clone()
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (*Request, bool, error) {
+	shard := s.shard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	req, ok := shard.requests[id]
+	if !ok {
+		return nil, false, nil
+	}
+	return req.clone(), true, nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.requests, id)
+	return nil
+}
+
+func (s *memoryStore) Take(id string) (bool, error) {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, ok := shard.requests[id]
+	delete(shard.requests, id)
+	return ok, nil
+}
+
+func (s *memoryStore) List() ([]*Request, error) {
+	requests := []*Request{}
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for _, req := range shard.requests {
+			requests = append(requests, req.clone())
+		}
+		shard.mu.RUnlock()
+	}
+	return requests, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the request hashes in a shared Redis
+const redisKeyPrefix = "szlaban:request:"
+
+// redisStore keeps each request as a Redis hash, so that several instances
+// share requests. The queryable fields mirror the core fields, data holds the
+// full request.
+type redisStore struct {
+	client *redis.Client
+}
+
+// redisExpiryGrace is how long Redis keeps a request past its expiry. Expired
+// requests are left to cleanup and the handlers, which answer 410 and audit,
+// count and record the expiry; the native TTL only drops those no instance
+// got to, e.g. while none was running.
+func redisExpiryGrace() time.Duration {
+	return 2 * cleanupInterval
+}
+
+func newRedisStore(addr, password string) (*redisStore, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("REDIS_ADDR is required for the redis store")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to redis at %s: %v", addr, err)
+	}
+	return &redisStore{client: client}, nil
+}
+
+func (s *redisStore) Save(req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := redisKeyPrefix + req.ID
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"server_id", req.ServerID,
+			"approved", req.Approved,
+			"created_at", req.CreatedAt,
+			"ip", req.IP,
+			"data", data)
+		pipe.PExpireAt(ctx, key, req.expiresAt().Add(redisExpiryGrace()))
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) Get(id string) (*Request, bool, error) {
+	data, err := s.client.HGet(context.Background(), redisKeyPrefix+id, "data").Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, false, err
+	}
+	return &req, true, nil
+}
+
+func (s *redisStore) Delete(id string) error {
+	return s.client.Del(context.Background(), redisKeyPrefix+id).Err()
+}
+
+func (s *redisStore) Take(id string) (bool, error) {
+	deleted, err := s.client.Del(context.Background(), redisKeyPrefix+id).Result()
+	return deleted > 0, err
+}
+
+func (s *redisStore) List() ([]*Request, error) {
+	ctx := context.Background()
+	requests := []*Request{}
+	iter := s.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.client.HGet(ctx, iter.Val(), "data").Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue // expired between the scan and the read
+		}
+		if err != nil {
+			return nil, err
+		}
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &req)
+	}
+	return requests, iter.Err()
+}
+
+// Ping checks that Redis is still reachable
+func (s *redisStore) Ping() error {
+	return s.client.Ping(context.Background()).Err()
+}
+
+// Close closes the connection pool
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T) (*redisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s, err := newRedisStore(mr.Addr(), "")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, mr
+}
+
+func TestRedisStore(t *testing.T) {
+	s, _ := newTestRedisStore(t)
+	testStore(t, s)
+	assert.NoError(t, s.Ping())
+}
+
+func TestRedisStoreTTL(t *testing.T) {
+	s, mr := newTestRedisStore(t)
+	ttl := time.Minute + redisExpiryGrace()
+
+	req := &Request{
+		ID:        uuid.New().String(),
+		ServerID:  "darkstar",
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	require.NoError(t, s.Save(req))
+	assert.Equal(t, "darkstar", mr.HGet(redisKeyPrefix+req.ID, "server_id"))
+	assert.InDelta(t, ttl.Seconds(), mr.TTL(redisKeyPrefix+req.ID).Seconds(), 2)
+
+	// Saving keeps the expiry of the request rather than extending it
+	req.Approved = true
+	require.NoError(t, s.Save(req))
+	assert.InDelta(t, ttl.Seconds(), mr.TTL(redisKeyPrefix+req.ID).Seconds(), 2)
+
+	// Just past its expiry the request is still there for cleanup to report
+	mr.FastForward(time.Minute + time.Second)
+	_, ok, err := s.Get(req.ID)
+	require.NoError(t, err)
+	assert.True(t, ok, "expired requests are left to cleanup")
+
+	mr.FastForward(redisExpiryGrace())
+	_, ok, err = s.Get(req.ID)
+	require.NoError(t, err)
+	assert.False(t, ok, "redis drops requests cleanup never got to")
+	requests, err := s.List()
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+}
+
+func TestRedisStoreExpiryReported(t *testing.T) {
+	s, _ := newTestRedisStore(t)
+	original := store
+	defer func() { store = original }()
+	store = s
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	updateTestRequest(t, reqID, func(req *Request) { req.ExpiresAt = time.Now().Add(-time.Second) })
+	expired := testutil.ToFloat64(requestsExpired)
+
+	code, response := fetchTestKey(t, router, reqID)
+	assert.Equal(t, http.StatusGone, code)
+	assert.Equal(t, "expired", response["status"])
+	assert.Equal(t, expired+1, testutil.ToFloat64(requestsExpired))
+}
+
+func TestRedisStoreSharedBetweenInstances(t *testing.T) {
+	first, mr := newTestRedisStore(t)
+	second, err := newRedisStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer second.Close()
+
+	original := store
+	defer func() { store = original }()
+	router := setupRouter()
+
+	// Create on one instance, approve on the other and fetch the key on the first
+	store = first
+	reqID := createTestRequest(t, router, "test-server")
+	require.NotEmpty(t, reqID)
+
+	store = second
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	store = first
+	w = httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"req_id": reqID})
+	req, _ = http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "test-decryption-key")
+}
+
+func TestRedisStoreUnreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	_, err := newRedisStore(addr, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore persists requests in a SQLite database so they survive restarts.
+// The queryable columns mirror the core fields, data holds the full request.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS requests (
+	id         TEXT PRIMARY KEY,
+	server_id  TEXT NOT NULL,
+	approved   INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	ip         TEXT NOT NULL,
+	data       TEXT NOT NULL
+)`
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("SQLITE_PATH is required for the sqlite store")
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, serialise access instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating sqlite schema: %v", err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Save(req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO requests (id, server_id, approved, created_at, ip, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			server_id = excluded.server_id,
+			approved = excluded.approved,
+			created_at = excluded.created_at,
+			ip = excluded.ip,
+			data = excluded.data`,
+		req.ID, req.ServerID, req.Approved, req.CreatedAt, req.IP, string(data))
+	return err
+}
+
+func (s *sqliteStore) Get(id string) (*Request, bool, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM requests WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var req Request
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		return nil, false, err
+	}
+	return &req, true, nil
+}
+
+func (s *sqliteStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM requests WHERE id = ?`, id)
+	return err
+}
+
+func (s *sqliteStore) Take(id string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM requests WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+func (s *sqliteStore) List() ([]*Request, error) {
+	rows, err := s.db.Query(`SELECT data FROM requests`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*Request{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var req Request
+		if err := json.Unmarshal([]byte(data), &req); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &req)
+	}
+	return requests, rows.Err()
+}
+
+// Ping checks that the database is still reachable
+func (s *sqliteStore) Ping() error {
+	return s.db.Ping()
+}
+
+// Close closes the underlying database
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore exercises the Store contract against a backend
+func testStore(t *testing.T, s Store) {
+	req := &Request{
+		ID:        uuid.New().String(),
+		ServerID:  "darkstar",
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		IP:        "10.0.0.1",
+		Tags:      []string{"prod"},
+	}
+	require.NoError(t, s.Save(req))
+
+	got, ok, err := s.Get(req.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "darkstar", got.ServerID)
+	assert.Equal(t, "10.0.0.1", got.IP)
+	assert.True(t, req.CreatedAt.Equal(got.CreatedAt))
+	assert.False(t, got.Approved)
+
+	// Changes only take effect once saved
+	got.Approved = true
+	again, _, _ := s.Get(req.ID)
+	assert.False(t, again.Approved)
+	require.NoError(t, s.Save(got))
+	again, _, _ = s.Get(req.ID)
+	assert.True(t, again.Approved)
+
+	// Nor do changes to the slices of a saved or fetched request
+	req.Tags[0] = "changed"
+	again.Tags[0] = "changed"
+	again, _, _ = s.Get(req.ID)
+	assert.Equal(t, []string{"prod"}, again.Tags)
+	req.Tags[0] = "prod"
+
+	requests, err := s.List()
+	require.NoError(t, err)
+	assert.Len(t, requests, 1)
+
+	require.NoError(t, s.Delete(req.ID))
+	_, ok, err = s.Get(req.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Only the first Take of a stored request succeeds
+	require.NoError(t, s.Save(req))
+	taken, err := s.Take(req.ID)
+	require.NoError(t, err)
+	assert.True(t, taken)
+	taken, err = s.Take(req.ID)
+	require.NoError(t, err)
+	assert.False(t, taken)
+	_, ok, _ = s.Get(req.ID)
+	assert.False(t, ok)
+}
+
+func TestRequestClone(t *testing.T) {
+	req := &Request{ApprovedBy: []string{"alice"}, Tags: []string{"prod", "team:db"}}
+	copied := req.clone()
+	copied.ApprovedBy[0] = "mallory"
+	copied.Tags[0] = "staging"
+	assert.Equal(t, []string{"alice"}, req.ApprovedBy)
+	assert.Equal(t, []string{"prod", "team:db"}, req.Tags)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newMemoryStore())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "szlaban.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	testStore(t, s)
+	assert.NoError(t, s.Ping())
+}
+
+func TestSQLiteStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "szlaban.db")
+
+	s, err := newSQLiteStore(path)
+	require.NoError(t, err)
+	req := &Request{ID: uuid.New().String(), ServerID: "darkstar", Approved: true, CreatedAt: time.Now(), IP: "10.0.0.1"}
+	require.NoError(t, s.Save(req))
+	require.NoError(t, s.Close())
+
+	s, err = newSQLiteStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	got, ok, err := s.Get(req.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "darkstar", got.ServerID)
+	assert.True(t, got.Approved)
+}
+
+func TestCleanupUsesStore(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "szlaban.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	originalStore := store
+	store = s
+	defer func() { store = originalStore }()
+
+	expired := &Request{ID: uuid.New().String(), ServerID: "old", CreatedAt: time.Now().Add(-2 * approvalTimeout)}
+	live := &Request{ID: uuid.New().String(), ServerID: "new", CreatedAt: time.Now()}
+	require.NoError(t, s.Save(expired))
+	require.NoError(t, s.Save(live))
+
+	cleanupExpiredRequests()
+
+	_, ok, _ := s.Get(expired.ID)
+	assert.False(t, ok, "expired request should be cleaned up")
+	_, ok, _ = s.Get(live.ID)
+	assert.True(t, ok)
+}
+
+func TestOpenStore(t *testing.T) {
+	s, err := openStore("", storeConfig{})
+	require.NoError(t, err)
+	assert.IsType(t, &memoryStore{}, s)
+
+	_, err = openStore(storeBackendSQLite, storeConfig{})
+	assert.Error(t, err, "sqlite requires SQLITE_PATH")
+
+	_, err = openStore(storeBackendRedis, storeConfig{})
+	assert.Error(t, err, "redis requires REDIS_ADDR")
+
+	_, err = openStore("cassandra", storeConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamBuffer is how many events a stream client may fall behind before it
+// is disconnected, so a slow dashboard never holds up the handlers
+const streamBuffer = 64
+
+// streamKeepalive is how often an idle stream sends a comment, keeping
+// proxies from closing the connection
+var streamKeepalive = 30 * time.Second
+
+// streamEventTypes maps the audited events pushed to /admin/stream to their
+// SSE event names. Requests deleted before they expire are "removed", so
+// dashboards can drop them.
+var streamEventTypes = map[string]string{
+	auditRequestCreated:      "created",
+	auditRequestApproved:     "approved",
+	auditRequestAutoApproved: "approved",
+	auditRequestDenied:       "denied",
+	auditRequestExpired:      "expired",
+	auditRequestEvicted:      "removed",
+	auditRequestRevoked:      "removed",
+	auditRequestPurged:       "removed",
+}
+
+// streamEvent is the data of one SSE event
+type streamEvent struct {
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Request requestSummary `json:"request"`
+}
+
+// eventHub fans request events out to the connected stream clients
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan streamEvent]struct{}
+}
+
+// requestEvents receives every streamed event, published from audit
+var requestEvents = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: make(map[chan streamEvent]struct{})}
+}
+
+// subscribe registers a client and returns its events and a function removing
+// it. The channel is closed if the client falls too far behind.
+func (h *eventHub) subscribe() (<-chan streamEvent, func()) {
+	ch := make(chan streamEvent, streamBuffer)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() { h.remove(ch) }
+}
+
+// remove unregisters ch and closes it, it is safe to call more than once
+func (h *eventHub) remove(ch chan streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// publish sends event to every client without blocking, disconnecting those
+// whose buffer is full
+func (h *eventHub) publish(event streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// closeAll disconnects every client, letting their handlers return on shutdown
+func (h *eventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// publishRequestEvent streams the audited event for req if dashboards follow it
+func publishRequestEvent(event string, req *Request) {
+	eventType, ok := streamEventTypes[event]
+	if event == auditKeyReleased && oneTimeKey {
+		// Releasing a one-time key consumes the request
+		eventType, ok = "removed", true
+	}
+	if ok {
+		requestEvents.publish(streamEvent{Type: eventType, Time: time.Now().UTC(), Request: newRequestSummary(req)})
+	}
+}
+
+// handleAdminStream pushes request events to the admin as Server-Sent Events
+// until the client disconnects, falls behind or the server shuts down
+func handleAdminStream(c *gin.Context) {
+	events, unsubscribe := requestEvents.subscribe()
+	defer unsubscribe()
+
+	// The stream outlives READ_TIMEOUT and WRITE_TIMEOUT, which bound single calls
+	rc := http.NewResponseController(c.Writer)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !inAdminScope(c, event.Request.ServerID) {
+				continue
+			}
+			c.SSEvent(event.Type, event)
+		case <-keepalive.C:
+			io.WriteString(c.Writer, ": keepalive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
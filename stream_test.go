@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readStreamEvent reads the next SSE event from the stream
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (string, streamEvent) {
+	t.Helper()
+	var name string
+	var event streamEvent
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event))
+		case line == "" && name != "":
+			return name, event
+		}
+	}
+}
+
+func TestAdminStream(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Two listeners both receive every event
+	var readers []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", server.URL+"/admin/stream", nil)
+		req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		readers = append(readers, bufio.NewReader(resp.Body))
+	}
+
+	reqID := createTestRequest(t, router, "test-server")
+	adminCall(router, "GET", "/admin/approve/"+reqID, adminSecretKey)
+
+	for _, reader := range readers {
+		name, event := readStreamEvent(t, reader)
+		assert.Equal(t, "created", name)
+		assert.Equal(t, "created", event.Type)
+		assert.Equal(t, reqID, event.Request.RequestID)
+		assert.Equal(t, "test-server", event.Request.ServerID)
+
+		name, event = readStreamEvent(t, reader)
+		assert.Equal(t, "approved", name)
+		assert.True(t, event.Request.Approved)
+	}
+}
+
+func TestStreamRemovedRequests(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+	events, unsubscribe := requestEvents.subscribe()
+	defer unsubscribe()
+
+	next := func() streamEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event streamed")
+			return streamEvent{}
+		}
+	}
+
+	revoked := createTestRequest(t, router, "test-server")
+	assert.Equal(t, "created", next().Type)
+	w := signedServerCall(router, "/server/revoke", `{"req_id":"`+revoked+`","server_id":"test-server"}`, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	event := next()
+	assert.Equal(t, "removed", event.Type)
+	assert.Equal(t, revoked, event.Request.RequestID)
+
+	purged := createTestRequest(t, router, "test-server")
+	assert.Equal(t, "created", next().Type)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/admin/requests?confirm=true", nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	event = next()
+	assert.Equal(t, "removed", event.Type)
+	assert.Equal(t, purged, event.Request.RequestID)
+}
+
+func TestAdminStreamRequiresAuth(t *testing.T) {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/stream", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestEventHubDropsSlowClient(t *testing.T) {
+	hub := newEventHub()
+	slow, unsubscribeSlow := hub.subscribe()
+	defer unsubscribeSlow()
+	fast, unsubscribeFast := hub.subscribe()
+	defer unsubscribeFast()
+
+	done := make(chan struct{})
+	received := 0
+	go func() {
+		defer close(done)
+		for range fast {
+			if received++; received == streamBuffer+1 {
+				return
+			}
+		}
+	}()
+
+	// The slow client never reads, publishing must still not block
+	for i := 0; i <= streamBuffer; i++ {
+		hub.publish(streamEvent{Type: "created"})
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast client did not receive every event")
+	}
+
+	drained := 0
+	for range slow {
+		drained++
+	}
+	assert.Equal(t, streamBuffer, drained, "the slow client is closed once its buffer is full")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Limits on the tags a server may group its request by, such as prod or team-db
+const (
+	maxTags      = 10
+	maxTagLength = 32
+)
+
+// tagPattern is the character set of a tag, ':' allows key:value tags
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:-]+$`)
+
+// parseTags validates the tags of a new request and drops repeated ones,
+// keeping the order they were given in
+func parseTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	var parsed []string
+	for _, tag := range tags {
+		switch {
+		case tag == "":
+			return nil, errors.New("tags must not be empty")
+		case len(tag) > maxTagLength:
+			return nil, fmt.Errorf("tag %q exceeds %d characters", tag, maxTagLength)
+		case !tagPattern.MatchString(tag):
+			return nil, fmt.Errorf("tag %q may only contain letters, digits, '_', '.', ':' and '-'", tag)
+		}
+		if !slices.Contains(parsed, tag) {
+			parsed = append(parsed, tag)
+		}
+	}
+	return parsed, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listTestRequests returns the IDs listed by /admin/requests with query
+func listTestRequests(t *testing.T, router *gin.Engine, query string) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/requests"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var listed []requestSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	ids := []string{}
+	for _, summary := range listed {
+		ids = append(ids, summary.RequestID)
+	}
+	return ids
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{"prod", "team:db", "prod"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod", "team:db"}, tags, "repeated tags are dropped")
+
+	tags, err = parseTags(nil)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	invalid := [][]string{
+		{""},
+		{"has space"},
+		{strings.Repeat("a", maxTagLength+1)},
+		strings.Split(strings.Repeat("t,", maxTags)+"t", ","),
+	}
+	for _, tags := range invalid {
+		_, err := parseTags(tags)
+		assert.Error(t, err, "%q", tags)
+	}
+}
+
+func TestRequestTags(t *testing.T) {
+	isolatePendingRequests(t)
+	router := setupRouter()
+
+	w := signedServerCall(router, "/server/request-key", `{"server_id":"test-server","tags":["prod","team:db"]}`, "")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var response struct {
+		RequestID string `json:"request_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	tagged := response.RequestID
+	untagged := createTestRequest(t, router, "test-server")
+
+	stored := getTestRequest(t, tagged)
+	require.NotNil(t, stored)
+	assert.Equal(t, []string{"prod", "team:db"}, stored.Tags)
+	assert.Contains(t, notificationText(stored), "Tags: prod, team:db")
+
+	assert.ElementsMatch(t, []string{tagged, untagged}, listTestRequests(t, router, ""))
+	assert.Equal(t, []string{tagged}, listTestRequests(t, router, "?tag=team:db"))
+	assert.Empty(t, listTestRequests(t, router, "?tag=staging"), "no request carries the tag")
+
+	w = signedServerCall(router, "/server/request-key", `{"server_id":"test-server","tags":["not a tag"]}`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, errCodeBadRequest, responseError(t, w).Code)
+}
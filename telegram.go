@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// telegramAPIURL is the Bot API base, replaceable in tests
+var telegramAPIURL = "https://api.telegram.org"
+
+// telegramSecretHeader carries the secret_token given to setWebhook, proving an
+// update comes from Telegram
+const telegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Telegram webhook settings, set from TELEGRAM_WEBHOOK_SECRET and TELEGRAM_ADMINS
+var (
+	telegramWebhookSecret string
+	telegramAdmins        map[int64]string // Telegram user ID to admin identity
+)
+
+// Callback data actions of the inline keyboard buttons
+const (
+	telegramApprove = "approve"
+	telegramDeny    = "deny"
+)
+
+// parseTelegramAdmins parses comma-separated user_id:admin pairs. Only these
+// Telegram users may press the buttons, acting as the named admin.
+func parseTelegramAdmins(list string) (map[int64]string, error) {
+	admins := make(map[int64]string)
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		rawID, admin, ok := strings.Cut(entry, ":")
+		id, err := strconv.ParseInt(strings.TrimSpace(rawID), 10, 64)
+		admin = strings.TrimSpace(admin)
+		if !ok || err != nil || admin == "" {
+			return nil, fmt.Errorf("invalid TELEGRAM_ADMINS entry %q, expected user_id:admin", entry)
+		}
+		admins[id] = admin
+	}
+	return admins, nil
+}
+
+// telegramNotifier sends new requests to a Telegram chat with approve and deny buttons
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func newTelegramNotifier(token, chatID string) *telegramNotifier {
+	return &telegramNotifier{token: token, chatID: chatID}
+}
+
+// telegramButton is an inline keyboard button sending callback data when pressed
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+func (n *telegramNotifier) Notify(ctx context.Context, req *Request) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id": n.chatID,
+		"text":    notificationSummary(req),
+		"reply_markup": map[string]any{
+			"inline_keyboard": [][]telegramButton{{
+				{Text: "Approve", CallbackData: telegramApprove + ":" + req.ID},
+				{Text: "Deny", CallbackData: telegramDeny + ":" + req.ID},
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := outboundPost(ctx, fmt.Sprintf("%s/bot%s/sendMessage", telegramAPIURL, n.token), "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telegram sendMessage returned %s", resp.Status)
+	}
+	return nil
+}
+
+// telegramUpdate is the part of a Telegram update the webhook acts on
+type telegramUpdate struct {
+	CallbackQuery *struct {
+		ID   string `json:"id"`
+		Data string `json:"data"`
+		From struct {
+			ID       int64  `json:"id"`
+			Username string `json:"username"`
+		} `json:"from"`
+	} `json:"callback_query"`
+}
+
+// answerTelegramCallback replies to a button press in the webhook response,
+// showing text to the admin without a separate Bot API call
+func answerTelegramCallback(c *gin.Context, queryID, text string) {
+	c.JSON(http.StatusOK, gin.H{
+		"method":            "answerCallbackQuery",
+		"callback_query_id": queryID,
+		"text":              text,
+	})
+}
+
+// handleTelegramCallback receives Telegram updates and applies approve and
+// deny button presses through the same logic as the admin endpoints. Updates
+// are always acknowledged with 200 so Telegram does not redeliver them.
+func handleTelegramCallback(c *gin.Context) {
+	secret := c.GetHeader(telegramSecretHeader)
+	if telegramWebhookSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(telegramWebhookSecret)) != 1 {
+		respondError(c, http.StatusUnauthorized, errCodeUnauthorized, "Invalid webhook secret")
+		return
+	}
+
+	var update telegramUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Invalid update")
+		return
+	}
+	query := update.CallbackQuery
+	if query == nil {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	admin, ok := telegramAdmins[query.From.ID]
+	if !ok {
+		slog.Warn("telegram button press by unmapped user ignored", "telegram_user_id", query.From.ID, "telegram_username", query.From.Username)
+		answerTelegramCallback(c, query.ID, "You are not allowed to approve requests.")
+		return
+	}
+	action, reqID, _ := strings.Cut(query.Data, ":")
+	if _, err := uuid.Parse(reqID); err != nil {
+		answerTelegramCallback(c, query.ID, "Invalid request ID.")
+		return
+	}
+	c.Set(adminContextKey, admin)
+	prefixes, scoped := adminScopes.Admins[admin]
+	restrictAdmin(c, prefixes, scoped)
+
+	var message string
+	switch action {
+	case telegramApprove:
+		span := startSpan(c, spanApprove)
+		defer endSpan(c, span)
+		_, _, message = approveRequest(c, span, reqID, admin, time.Time{}, "")
+	case telegramDeny:
+		_, _, message = denyRequest(c, reqID, "", "")
+	default:
+		message = "Unknown action."
+	}
+	answerTelegramCallback(c, query.ID, message)
+}
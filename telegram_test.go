@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTelegramSecret = "test-telegram-secret"
+
+// enableTelegramWebhook configures the webhook secret and admin mapping for the test
+func enableTelegramWebhook(t *testing.T, admins map[int64]string) {
+	t.Helper()
+	originalSecret, originalAdmins := telegramWebhookSecret, telegramAdmins
+	telegramWebhookSecret, telegramAdmins = testTelegramSecret, admins
+	t.Cleanup(func() { telegramWebhookSecret, telegramAdmins = originalSecret, originalAdmins })
+}
+
+// pressTelegramButton posts a callback query update as Telegram would
+func pressTelegramButton(t *testing.T, router http.Handler, secret string, userID int64, data string) (int, map[string]string) {
+	t.Helper()
+	update := map[string]any{
+		"update_id": 1,
+		"callback_query": map[string]any{
+			"id":   "query-1",
+			"data": data,
+			"from": map[string]any{"id": userID, "username": "oncall"},
+		},
+	}
+	body, _ := json.Marshal(update)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/telegram/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(telegramSecretHeader, secret)
+	router.ServeHTTP(w, req)
+
+	var answer map[string]string
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	return w.Code, answer
+}
+
+func TestParseTelegramAdmins(t *testing.T) {
+	admins, err := parseTelegramAdmins(" 123:alice, 456:bob ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{123: "alice", 456: "bob"}, admins)
+
+	for _, invalid := range []string{"alice", "abc:alice", "123:", "123"} {
+		_, err := parseTelegramAdmins(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTelegramNotification(t *testing.T) {
+	messages := make(chan map[string]any, 1)
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/botbot-token/sendMessage", r.URL.Path)
+		var message map[string]any
+		json.NewDecoder(r.Body).Decode(&message)
+		messages <- message
+	}))
+	defer telegram.Close()
+	originalURL := telegramAPIURL
+	telegramAPIURL = telegram.URL
+	defer func() { telegramAPIURL = originalURL }()
+
+	req := &Request{ID: "id", ServerID: "darkstar", IP: "192.0.2.1", Reason: "Reboot"}
+	require.NoError(t, newTelegramNotifier("bot-token", "-100123").Notify(context.Background(), req))
+
+	message := <-messages
+	assert.Equal(t, "-100123", message["chat_id"])
+	assert.Contains(t, message["text"], "darkstar")
+	assert.Contains(t, message["text"], "192.0.2.1")
+	assert.Contains(t, message["text"], "Reason: Reboot")
+	keyboard, _ := json.Marshal(message["reply_markup"])
+	assert.Contains(t, string(keyboard), `"callback_data":"approve:id"`)
+	assert.Contains(t, string(keyboard), `"callback_data":"deny:id"`)
+}
+
+func TestTelegramCallbackApprove(t *testing.T) {
+	isolatePendingRequests(t)
+	enableTelegramWebhook(t, map[int64]string{42: "alice"})
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+	otherID := createTestRequest(t, router, "test-server")
+
+	code, answer := pressTelegramButton(t, router, testTelegramSecret, 42, "approve:"+reqID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "answerCallbackQuery", answer["method"])
+	assert.Equal(t, "query-1", answer["callback_query_id"])
+	assert.Contains(t, answer["text"], "approved")
+
+	approved := getTestRequest(t, reqID)
+	assert.True(t, approved.Approved)
+	assert.Equal(t, []string{"alice"}, approved.ApprovedBy)
+	assert.False(t, getTestRequest(t, otherID).Approved, "only the pressed request is approved")
+}
+
+func TestTelegramCallbackDeny(t *testing.T) {
+	isolatePendingRequests(t)
+	enableTelegramWebhook(t, map[int64]string{42: "alice"})
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	code, answer := pressTelegramButton(t, router, testTelegramSecret, 42, "deny:"+reqID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, answer["text"], "denied")
+	assert.True(t, getTestRequest(t, reqID).Denied)
+}
+
+func TestTelegramCallbackRejected(t *testing.T) {
+	isolatePendingRequests(t)
+	enableTelegramWebhook(t, map[int64]string{42: "alice"})
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+
+	code, _ := pressTelegramButton(t, router, "wrong-secret", 42, "approve:"+reqID)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, answer := pressTelegramButton(t, router, testTelegramSecret, 7, "approve:"+reqID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, answer["text"], "not allowed")
+
+	_, answer = pressTelegramButton(t, router, testTelegramSecret, 42, "approve:not-a-uuid")
+	assert.Contains(t, answer["text"], "Invalid request ID")
+
+	assert.False(t, getTestRequest(t, reqID).Approved)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// returns the certificate and key file paths
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "szlaban-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	tests := []struct {
+		name       string
+		certFile   string
+		keyFile    string
+		minVersion string
+		wantNil    bool
+		wantMin    uint16
+		wantErr    bool
+	}{
+		{name: "Disabled", wantNil: true},
+		{name: "Enabled", certFile: certFile, keyFile: keyFile, wantMin: tls.VersionTLS12},
+		{name: "MinVersion", certFile: certFile, keyFile: keyFile, minVersion: "1.3", wantMin: tls.VersionTLS13},
+		{name: "CertWithoutKey", certFile: certFile, wantErr: true},
+		{name: "KeyWithoutCert", keyFile: keyFile, wantErr: true},
+		{name: "UnreadableCert", certFile: missing, keyFile: keyFile, wantErr: true},
+		{name: "InvalidMinVersion", certFile: certFile, keyFile: keyFile, minVersion: "1.4", wantErr: true},
+		{name: "MinVersionWithoutTLS", minVersion: "1.2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTLSConfig(tt.certFile, tt.keyFile, tt.minVersion)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantNil {
+				assert.Nil(t, config)
+				return
+			}
+			require.NotNil(t, config)
+			assert.Len(t, config.Certificates, 1)
+			assert.Equal(t, tt.wantMin, config.MinVersion)
+		})
+	}
+}
+
+func TestRunServerTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	config, err := loadTLSConfig(certFile, keyFile, "1.3")
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pingz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- runServer(ctx, &http.Server{Handler: mux, TLSConfig: config}, ln, time.Second)
+	}()
+
+	pool := x509.NewCertPool()
+	certPEM, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+
+	client := &http.Client{Timeout: time.Second, Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/pingz")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "pong", string(body))
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+
+	// Clients limited to older versions are rejected
+	oldClient := &http.Client{Timeout: time.Second, Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MaxVersion: tls.VersionTLS12},
+	}}
+	_, err = oldClient.Get("https://" + ln.Addr().String() + "/pingz")
+	assert.Error(t, err)
+
+	// Plain HTTP is not served on the TLS listener
+	plainResp, err := (&http.Client{Timeout: time.Second}).Get("http://" + ln.Addr().String() + "/pingz")
+	if err == nil {
+		assert.Equal(t, http.StatusBadRequest, plainResp.StatusCode)
+		plainResp.Body.Close()
+	}
+
+	cancel()
+	assert.NoError(t, <-serverDone)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TOTP codes follow RFC 6238 with the defaults authenticator apps use:
+// HMAC-SHA1, 30 second steps and 6 digits
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpModulus is 10^totpDigits
+	totpModulus = 1_000_000
+	// totpSkew is the number of steps accepted either side of the current
+	// one, for clock drift and codes entered just before they rolled over
+	totpSkew = 1
+	// totpMinSecretBytes is the shortest secret accepted, 80 bits as the
+	// 16-character secrets authenticator apps are commonly given
+	totpMinSecretBytes = 10
+)
+
+// totpHeader carries the admin's current code, the totp query parameter may
+// be used instead
+const totpHeader = "X-TOTP"
+
+var (
+	// adminTOTPSecret is the decoded ADMIN_TOTP_SECRET, admin decisions need
+	// no code without it
+	adminTOTPSecret []byte
+	// totpSkipDeny lets denials through without a code, set by ADMIN_TOTP_SKIP_DENY
+	totpSkipDeny bool
+)
+
+// parseTOTPSecret decodes a base32 secret as shown by authenticator apps,
+// spaces, lower case and padding are accepted
+func parseTOTPSecret(raw string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.TrimRight(strings.ReplaceAll(raw, " ", ""), "="))
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("ADMIN_TOTP_SECRET is not valid base32")
+	}
+	if len(secret) < totpMinSecretBytes {
+		return nil, fmt.Errorf("ADMIN_TOTP_SECRET is shorter than %d bytes", totpMinSecretBytes)
+	}
+	return secret, nil
+}
+
+// totpCode returns the code of secret for the given step (RFC 4226 HOTP)
+func totpCode(secret []byte, step uint64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// validTOTP reports whether code is the code of secret at now, or within
+// totpSkew steps of it
+func validTOTP(secret []byte, code string, now time.Time) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	current := uint64(now.Unix()) / uint64(totpPeriod/time.Second)
+	valid := 0
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		valid |= subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code))
+	}
+	return valid == 1
+}
+
+// requireTOTP rejects admin decisions without a valid TOTP code when
+// ADMIN_TOTP_SECRET is set. deny marks routes exempt with ADMIN_TOTP_SKIP_DENY.
+func requireTOTP(deny bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminTOTPSecret == nil || (deny && totpSkipDeny) {
+			c.Next()
+			return
+		}
+		code := strings.TrimSpace(c.GetHeader(totpHeader))
+		if code == "" {
+			code = strings.TrimSpace(c.Query("totp"))
+		}
+		if code == "" {
+			respondError(c, http.StatusUnauthorized, errCodeTOTPRequired, "A TOTP code is required in the X-TOTP header")
+			c.Abort()
+			return
+		}
+		if !validTOTP(adminTOTPSecret, code, time.Now()) {
+			slog.Warn("rejected TOTP code", "client_ip", c.ClientIP(), "admin", adminID(c))
+			respondError(c, http.StatusUnauthorized, errCodeInvalidTOTP, "Invalid or expired TOTP code")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
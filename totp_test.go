@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// totpTestSecret is "12345678901234567890", the secret of the RFC 6238 test vectors
+const totpTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// totpAdminAction calls an admin endpoint with the TOTP code in the header
+func totpAdminAction(router *gin.Engine, path, code string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	if code != "" {
+		req.Header.Set(totpHeader, code)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// currentTOTP returns the code of secret shifted by steps from now
+func currentTOTP(secret []byte, steps int) string {
+	step := time.Now().Add(time.Duration(steps)*totpPeriod).Unix() / int64(totpPeriod/time.Second)
+	return totpCode(secret, uint64(step))
+}
+
+func TestTOTPCode(t *testing.T) {
+	secret, err := parseTOTPSecret(totpTestSecret)
+	require.NoError(t, err)
+	assert.Equal(t, "12345678901234567890", string(secret))
+
+	// The last 6 digits of the RFC 6238 SHA1 vectors
+	assert.Equal(t, "287082", totpCode(secret, 59/30))
+	assert.Equal(t, "081804", totpCode(secret, 1111111109/30))
+	assert.Equal(t, "005924", totpCode(secret, 1234567890/30))
+
+	now := time.Unix(1111111109, 0)
+	assert.True(t, validTOTP(secret, "081804", now))
+	assert.True(t, validTOTP(secret, "081804", now.Add(totpPeriod)), "one step of drift is accepted")
+	assert.False(t, validTOTP(secret, "081804", now.Add(3*totpPeriod)))
+	assert.False(t, validTOTP(secret, "81804", now))
+}
+
+func TestParseTOTPSecret(t *testing.T) {
+	_, err := parseTOTPSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	assert.NoError(t, err, "spaces and lower case as shown by authenticator apps")
+	_, err = parseTOTPSecret("GEZDGNBV")
+	assert.Error(t, err, "too short")
+	_, err = parseTOTPSecret("not base32!")
+	assert.Error(t, err)
+}
+
+func TestAdminTOTP(t *testing.T) {
+	isolatePendingRequests(t)
+	secret, err := parseTOTPSecret(totpTestSecret)
+	require.NoError(t, err)
+	originalSecret, originalSkip := adminTOTPSecret, totpSkipDeny
+	adminTOTPSecret = secret
+	defer func() { adminTOTPSecret, totpSkipDeny = originalSecret, originalSkip }()
+	router := setupRouter()
+
+	t.Run("missing code", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := totpAdminAction(router, "/admin/approve/"+reqID, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, errCodeTOTPRequired, responseError(t, w).Code)
+		assert.False(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := totpAdminAction(router, "/admin/approve/"+reqID, currentTOTP(secret, -3))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, errCodeInvalidTOTP, responseError(t, w).Code)
+		assert.False(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("valid code", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := totpAdminAction(router, "/admin/approve/"+reqID, currentTOTP(secret, 0))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("valid code in the query", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := totpAdminAction(router, "/admin/approve/"+reqID+"?totp="+currentTOTP(secret, 0), "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, getTestRequest(t, reqID).Approved)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		reqID := createTestRequest(t, router, "test-server")
+		w := totpAdminAction(router, "/admin/deny/"+reqID, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.False(t, getTestRequest(t, reqID).Denied)
+
+		totpSkipDeny = true
+		w = totpAdminAction(router, "/admin/deny/"+reqID, "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, getTestRequest(t, reqID).Denied)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this service
+const tracerName = "szlaban"
+
+// Span names of the instrumented handlers
+const (
+	spanRequestKey = "request-key"
+	spanApprove    = "approve"
+	spanGetKey     = "get-key"
+)
+
+// setupTracing exports spans over OTLP/HTTP to endpoint and returns the
+// function flushing them on shutdown. Without an endpoint the global no-op
+// tracer provider is kept and tracing costs nothing.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(tracerName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// startSpan starts a server span for the handler, continuing any trace the
+// caller propagated, and makes it the request's context
+func startSpan(c *gin.Context, name string) trace.Span {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	c.Request = c.Request.WithContext(ctx)
+	return span
+}
+
+// endSpan records the response status and ends the span, meant to be deferred
+func endSpan(c *gin.Context, span trace.Span) {
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, fmt.Sprintf("responded with %d", status))
+	}
+	span.End()
+}
+
+// setRequestTrace stores the trace of the span creating req so later calls
+// for the same request link back to it
+func setRequestTrace(req *Request, span trace.Span) {
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	req.TraceID = sc.TraceID().String()
+	req.SpanID = sc.SpanID().String()
+}
+
+// linkRequestSpan annotates span with the request and links it to the span
+// that created the request, if it was traced
+func linkRequestSpan(span trace.Span, req *Request) {
+	span.SetAttributes(
+		attribute.String("szlaban.request_id", req.ID),
+		attribute.String("szlaban.server_id", req.ServerID),
+	)
+	traceID, err := trace.TraceIDFromHex(req.TraceID)
+	if err != nil {
+		return
+	}
+	spanID, err := trace.SpanIDFromHex(req.SpanID)
+	if err != nil {
+		return
+	}
+	span.AddLink(trace.Link{SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})})
+}
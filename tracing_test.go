@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordSpans installs a tracer provider recording every span for the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	// The default global provider delegates to the first one set, so restore
+	// the no-op behaviour explicitly
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return recorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingSpans(t *testing.T) {
+	isolatePendingRequests(t)
+	recorder := recordSpans(t)
+	router := setupRouter()
+
+	reqID := createTestRequest(t, router, "test-server")
+	stored := getTestRequest(t, reqID)
+	require.NotEmpty(t, stored.TraceID)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/approve/"+reqID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminSecretKey)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	jsonBody, _ := json.Marshal(map[string]string{"req_id": reqID})
+	req, _ = http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	wantNames := []string{spanRequestKey, spanApprove, spanGetKey}
+	wantStatus := []int64{http.StatusAccepted, http.StatusOK, http.StatusOK}
+	for i, span := range spans {
+		assert.Equal(t, wantNames[i], span.Name())
+		assert.Equal(t, reqID, spanAttribute(span, "szlaban.request_id").AsString())
+		assert.Equal(t, "test-server", spanAttribute(span, "szlaban.server_id").AsString())
+		assert.Equal(t, wantStatus[i], spanAttribute(span, "http.response.status_code").AsInt64())
+	}
+
+	// Later calls link back to the span that created the request
+	created := spans[0].SpanContext()
+	assert.Equal(t, created.TraceID().String(), stored.TraceID)
+	for _, span := range spans[1:] {
+		require.Len(t, span.Links(), 1, span.Name())
+		assert.Equal(t, created.TraceID(), span.Links()[0].SpanContext.TraceID())
+		assert.Equal(t, created.SpanID(), span.Links()[0].SpanContext.SpanID())
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	isolatePendingRequests(t)
+	shutdown, err := setupTracing(context.Background(), "")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	// The default no-op provider records nothing to link to
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "test-server")
+	assert.Empty(t, getTestRequest(t, reqID).TraceID)
+}
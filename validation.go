@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report JSON field names rather than Go struct field names in validation errors
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// bindErrorMessage maps a JSON binding error to a message a client can act on
+func bindErrorMessage(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return "Request body is empty"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Sprintf("Unknown field: %s", strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`))
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "Malformed JSON"
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("Invalid type for field: %s", typeErr.Field)
+	case errors.As(err, &validationErrs) && len(validationErrs) > 0:
+		fieldErr := validationErrs[0]
+		if fieldErr.Tag() == "required" {
+			return fmt.Sprintf("Missing required field: %s", fieldErr.Field())
+		}
+		return fmt.Sprintf("Invalid value for field: %s", fieldErr.Field())
+	default:
+		return "Invalid request"
+	}
+}
+
+// bindJSON binds the request body into obj, responding with 400 and a
+// specific error message on failure. Unknown fields are rejected so a typo
+// such as serverid is not silently ignored. It returns false if binding failed.
+func bindJSON(c *gin.Context, obj any) bool {
+	return decodeBody(c, obj, false)
+}
+
+// bindOptionalJSON is bindJSON for endpoints that also take their fields from
+// the URL, a missing or empty body leaves obj untouched
+func bindOptionalJSON(c *gin.Context, obj any) bool {
+	return decodeBody(c, obj, true)
+}
+
+func decodeBody(c *gin.Context, obj any, optional bool) bool {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		if optional {
+			return true
+		}
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, "Request body is empty")
+		return false
+	}
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(obj)
+	if optional && errors.Is(err, io.EOF) {
+		return true
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(obj)
+	}
+	if isBodyTooLarge(err) {
+		respondBodyTooLarge(c)
+		return false
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errCodeBadRequest, bindErrorMessage(err))
+		return false
+	}
+	return true
+}
+
+// limitBodySize caps request bodies at MAX_BODY_BYTES, reads past the limit
+// fail and are answered with 413
+func limitBodySize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBodyBytes {
+			respondBodyTooLarge(c)
+			c.Abort()
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+		}
+		c.Next()
+	}
+}
+
+// isBodyTooLarge reports whether err comes from reading past MAX_BODY_BYTES
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func respondBodyTooLarge(c *gin.Context) {
+	respondError(c, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBodyBytes))
+}
+
+// maxReasonLength is the longest justification a server may give, in characters
+const maxReasonLength = 500
+
+// maxNoteLength is the longest note an admin may attach to an approval, in characters
+const maxNoteLength = 500
+
+// sanitizeReason replaces control characters, including newlines, with spaces
+// so a reason or note cannot forge log lines or break notification formatting
+func sanitizeReason(reason string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return ' '
+		}
+		return r
+	}, reason))
+}
+
+// maxServerIDLength is the longest server_id accepted, in bytes
+const maxServerIDLength = 128
+
+// serverIDPattern is the character set of a server_id
+var serverIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// knownServerIDs, when not empty, lists the only server_ids accepted, set
+// from KNOWN_SERVER_IDS
+var knownServerIDs map[string]bool
+
+// parseKnownServerIDs parses the comma-separated KNOWN_SERVER_IDS
+func parseKnownServerIDs(list string) (map[string]bool, error) {
+	return parseServerIDList("KNOWN_SERVER_IDS", list)
+}
+
+// parseServerIDList parses a comma-separated list of server_ids, each entry
+// must itself be a valid server_id. name is the setting reported in errors.
+func parseServerIDList(name, list string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if err := validateServerID(id); err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %v", name, id, err)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// validateServerID checks that id is non-empty, short enough and made of
+// letters, digits, '_', '.' and '-' only
+func validateServerID(id string) error {
+	switch {
+	case id == "":
+		return errors.New("must not be empty")
+	case len(id) > maxServerIDLength:
+		return fmt.Errorf("exceeds %d characters", maxServerIDLength)
+	case !serverIDPattern.MatchString(id):
+		return errors.New("may only contain letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMalformedInput(t *testing.T) {
+	router := setupRouter()
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		wantError string
+	}{
+		{
+			name:      "request-key empty body",
+			path:      "/server/request-key",
+			body:      "",
+			wantError: "Request body is empty",
+		},
+		{
+			name:      "request-key malformed JSON",
+			path:      "/server/request-key",
+			body:      `{"server_id": `,
+			wantError: "Malformed JSON",
+		},
+		{
+			name:      "request-key invalid JSON syntax",
+			path:      "/server/request-key",
+			body:      `{server_id}`,
+			wantError: "Malformed JSON",
+		},
+		{
+			name:      "request-key missing field",
+			path:      "/server/request-key",
+			body:      `{}`,
+			wantError: "Missing required field: server_id",
+		},
+		{
+			name:      "request-key wrong type",
+			path:      "/server/request-key",
+			body:      `{"server_id": 42}`,
+			wantError: "Invalid type for field: server_id",
+		},
+		{
+			name:      "get-key empty body without req_id in the URL",
+			path:      "/server/get-key",
+			body:      "",
+			wantError: "Missing required field: req_id",
+		},
+		{
+			name:      "get-key malformed JSON",
+			path:      "/server/get-key",
+			body:      `{"req_id"`,
+			wantError: "Malformed JSON",
+		},
+		{
+			name:      "get-key missing field",
+			path:      "/server/get-key",
+			body:      `{}`,
+			wantError: "Missing required field: req_id",
+		},
+		{
+			name:      "request-key unknown field",
+			path:      "/server/request-key",
+			body:      `{"serverid": "test-server"}`,
+			wantError: "Unknown field: serverid",
+		},
+		{
+			name:      "get-key unknown field",
+			path:      "/server/get-key",
+			body:      `{"req_id": "abc", "reqid": "abc"}`,
+			wantError: "Unknown field: reqid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			assert.Equal(t, apiError{Code: errCodeBadRequest, Message: tt.wantError}, responseError(t, w))
+		})
+	}
+}
+
+func TestOversizedBody(t *testing.T) {
+	originalLimit := maxBodyBytes
+	maxBodyBytes = 64
+	defer func() { maxBodyBytes = originalLimit }()
+	router := setupRouter()
+
+	body := `{"server_id": "test-server", "reason": "` + strings.Repeat("x", 100) + `"}`
+	for _, chunked := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBufferString(body))
+		if chunked {
+			// Without a Content-Length the limit applies while reading
+			req.ContentLength = -1
+		}
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "chunked=%v", chunked)
+	}
+}
+
+func TestServerIDValidation(t *testing.T) {
+	router := setupRouter()
+	originalKnown := knownServerIDs
+	defer func() { knownServerIDs = originalKnown }()
+
+	tests := []struct {
+		name      string
+		serverID  string
+		known     string
+		wantCode  int
+		wantError string
+	}{
+		{"empty", "", "", http.StatusBadRequest, "Missing required field: server_id"},
+		{"too long", strings.Repeat("a", maxServerIDLength+1), "", http.StatusBadRequest, "Invalid server_id: exceeds 128 characters"},
+		{"illegal characters", "web 01/../", "", http.StatusBadRequest, "Invalid server_id: may only contain letters, digits, '_', '.' and '-'"},
+		{"valid", "web-01.prod_eu", "", http.StatusAccepted, ""},
+		{"unknown", "rogue", "web-01,db-01", http.StatusBadRequest, "Unknown server_id"},
+		{"known", "db-01", "web-01, db-01", http.StatusAccepted, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			knownServerIDs, err = parseKnownServerIDs(tt.known)
+			require.NoError(t, err)
+
+			body, _ := json.Marshal(map[string]string{"server_id": tt.serverID})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/server/request-key", bytes.NewBuffer(body))
+			req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantError != "" {
+				assert.Equal(t, tt.wantError, responseError(t, w).Message)
+			}
+		})
+	}
+}
+
+func TestParseKnownServerIDs(t *testing.T) {
+	_, err := parseKnownServerIDs("web-01,bad id")
+	assert.ErrorContains(t, err, `invalid KNOWN_SERVER_IDS entry "bad id"`)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultVaultPathPrefix is used when VAULT_PATH_PREFIX is not set, the KV v2
+// data path of a szlaban folder in the default secret mount
+const defaultVaultPathPrefix = "secret/data/szlaban"
+
+// vaultKeyField is the field of the Vault secret holding the key
+const vaultKeyField = "key"
+
+// vaultKeyProvider reads each server's key from the Vault secret at
+// <prefix>/<server_id>. Both KV v1 and v2 secret layouts are understood.
+type vaultKeyProvider struct {
+	addr   string
+	token  string
+	prefix string
+}
+
+func newVaultKeyProvider(addr, token, prefix string) (*vaultKeyProvider, error) {
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN are required for the vault key provider")
+	}
+	if prefix == "" {
+		prefix = defaultVaultPathPrefix
+	}
+	return &vaultKeyProvider{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (p *vaultKeyProvider) Key(ctx context.Context, serverID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+p.prefix+"/"+url.PathEscape(serverID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errKeyNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	// KV v2 nests the secret under data.data, KV v1 has it directly under data
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decoding vault response: %v", err)
+	}
+	fields := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return "", fmt.Errorf("decoding vault secret: %v", err)
+		}
+	}
+	raw, ok := fields[vaultKeyField]
+	if !ok {
+		return "", errKeyNotFound
+	}
+	var key string
+	if err := json.Unmarshal(raw, &key); err != nil || key == "" {
+		return "", fmt.Errorf("vault secret field %q is not a non-empty string", vaultKeyField)
+	}
+	return key, nil
+}
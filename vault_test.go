@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockVault serves KV v2 secrets for darkstar, a KV v1 secret for legacy
+// and fails every other path with 500
+func newMockVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/szlaban/darkstar":
+			w.Write([]byte(`{"data": {"data": {"key": "vault-darkstar-key"}, "metadata": {"version": 3}}}`))
+		case "/v1/secret/data/szlaban/legacy":
+			w.Write([]byte(`{"data": {"key": "vault-legacy-key"}}`))
+		case "/v1/secret/data/szlaban/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"errors": ["internal error: storage backend sealed"]}`))
+		}
+	}))
+}
+
+func TestVaultKeyProvider(t *testing.T) {
+	vault := newMockVault(t)
+	defer vault.Close()
+
+	provider, err := newVaultKeyProvider(vault.URL+"/", "test-token", "")
+	require.NoError(t, err)
+
+	key, err := provider.Key(context.Background(), "darkstar")
+	assert.NoError(t, err)
+	assert.Equal(t, "vault-darkstar-key", key)
+
+	key, err = provider.Key(context.Background(), "legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, "vault-legacy-key", key)
+
+	_, err = provider.Key(context.Background(), "missing")
+	assert.ErrorIs(t, err, errKeyNotFound)
+
+	_, err = provider.Key(context.Background(), "broken")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errKeyNotFound)
+
+	unauthorized, err := newVaultKeyProvider(vault.URL, "wrong-token", "")
+	require.NoError(t, err)
+	_, err = unauthorized.Key(context.Background(), "darkstar")
+	assert.Error(t, err)
+
+	_, err = newVaultKeyProvider("", "test-token", "")
+	assert.Error(t, err)
+}
+
+func TestGetKeyFromVault(t *testing.T) {
+	isolatePendingRequests(t)
+	vault := newMockVault(t)
+	defer vault.Close()
+
+	provider, err := newVaultKeyProvider(vault.URL, "test-token", "")
+	require.NoError(t, err)
+	keyProvider = provider
+	defer func() { keyProvider = nil }()
+
+	router := setupRouter()
+	getKey := func(serverID string) *httptest.ResponseRecorder {
+		reqID := createTestRequest(t, router, serverID)
+		updateTestRequest(t, reqID, func(req *Request) { req.Approved = true })
+
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]string{"req_id": reqID})
+		req, _ := http.NewRequest("POST", "/server/get-key", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+serverSecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := getKey("darkstar")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "vault-darkstar-key")
+
+	w = getKey("missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = getKey("broken")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.NotContains(t, w.Body.String(), "sealed", "vault errors must not leak to clients")
+}
+
+// keyProviderFunc adapts a function to KeyProvider
+type keyProviderFunc func(ctx context.Context, serverID string) (string, error)
+
+func (f keyProviderFunc) Key(ctx context.Context, serverID string) (string, error) {
+	return f(ctx, serverID)
+}
+
+func TestSlowKeyProviderHoldsNoLock(t *testing.T) {
+	isolatePendingRequests(t)
+	fetching, release := make(chan struct{}), make(chan struct{})
+	keyProvider = keyProviderFunc(func(ctx context.Context, serverID string) (string, error) {
+		close(fetching)
+		<-release
+		return "slow-key", nil
+	})
+	defer func() { keyProvider = nil }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "darkstar")
+	updateTestRequest(t, reqID, func(req *Request) { req.Approved = true })
+
+	done := make(chan int, 1)
+	go func() {
+		code, _ := fetchTestKey(t, router, reqID)
+		done <- code
+	}()
+	<-fetching
+
+	// Cleanup and creation take every lock, a slow provider must not block them
+	locked := make(chan struct{})
+	go func() {
+		requestLocks.LockAll()
+		requestLocks.UnlockAll()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the key fetch holds the request lock")
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// Webhook delivery attempts and the delay before the first retry, which
+// doubles on every further attempt
+const webhookAttempts = 3
+
+var webhookBackoff = time.Second
+
+// webhookData is the request as seen by WEBHOOK_TEMPLATE, and the JSON body
+// posted when no template is set
+type webhookData struct {
+	ServerID  string   `json:"server_id"`
+	RequestID string   `json:"request_id"`
+	IP        string   `json:"ip"`
+	Reason    string   `json:"reason,omitempty"`
+	Priority  string   `json:"priority"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt string   `json:"created_at"`
+	// Signed single-use approval link, empty unless APPROVE_LINK_SECRET is set
+	ApproveURL string `json:"approve_url,omitempty"`
+}
+
+// webhookFuncs are available to WEBHOOK_TEMPLATE, json quotes a value for
+// embedding in a JSON body
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// errWebhookStatus marks responses a retry will not fix
+var errWebhookStatus = errors.New("webhook rejected the notification")
+
+// webhookNotifier posts new requests to a generic HTTP endpoint
+type webhookNotifier struct {
+	url         string
+	tmpl        *template.Template // nil posts webhookData as JSON
+	contentType string
+	timeout     time.Duration // bounds each attempt
+}
+
+// newWebhookNotifier parses tmpl, if set, so a broken template fails at startup
+func newWebhookNotifier(url, tmpl, contentType string, timeout time.Duration) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		url:         url,
+		contentType: contentType,
+		timeout:     timeout,
+	}
+	if tmpl != "" {
+		parsed, err := template.New("webhook").Funcs(webhookFuncs).Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WEBHOOK_TEMPLATE: %w", err)
+		}
+		n.tmpl = parsed
+	}
+	return n, nil
+}
+
+// render builds the POST body for req
+func (n *webhookNotifier) render(req *Request) ([]byte, error) {
+	data := webhookData{
+		ServerID:   req.ServerID,
+		RequestID:  req.ID,
+		IP:         req.IP,
+		Reason:     req.Reason,
+		Priority:   req.priority(),
+		Tags:       req.Tags,
+		CreatedAt:  req.CreatedAt.UTC().Format(time.RFC3339),
+		ApproveURL: approveLink(req),
+	}
+	if n.tmpl == nil {
+		return json.Marshal(data)
+	}
+	var body bytes.Buffer
+	if err := n.tmpl.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// Notify posts req until the endpoint accepts it, retrying server errors and
+// failed connections with backoff until ctx is done
+func (n *webhookNotifier) Notify(ctx context.Context, req *Request) error {
+	body, err := n.render(req)
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(ctx, body)
+		if err == nil || attempt == webhookAttempts || errors.Is(err, errWebhookStatus) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *webhookNotifier) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	resp, err := outboundPost(ctx, n.url, n.contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%w: %s", errWebhookStatus, resp.Status)
+	}
+	return nil
+}
+
+// Ping checks that the webhook host answers. Posting would announce a request,
+// so any HTTP response counts as reachable.
+func (n *webhookNotifier) Ping() error {
+	resp, err := outboundClient.Head(n.url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
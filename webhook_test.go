@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capturedWebhook is one request received by the test webhook
+type capturedWebhook struct {
+	contentType string
+	body        string
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	captured := make(chan capturedWebhook, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		captured <- capturedWebhook{r.Header.Get("Content-Type"), string(body)}
+	}))
+	defer hook.Close()
+
+	tmpl := `server={{.ServerID}} id={{.RequestID}} ip={{.IP}} reason={{json .Reason}} at={{.CreatedAt}}`
+	webhook, err := newWebhookNotifier(hook.URL, tmpl, "text/plain", time.Second)
+	require.NoError(t, err)
+	originalNotifier := notifier
+	notifier = multiNotifier{webhook}
+	defer func() { notifier = originalNotifier }()
+
+	router := setupRouter()
+	reqID := createTestRequest(t, router, "webhook-server")
+	req := getTestRequest(t, reqID)
+
+	select {
+	case got := <-captured:
+		assert.Equal(t, "text/plain", got.contentType)
+		assert.Equal(t, "server=webhook-server id="+reqID+" ip="+req.IP+` reason="" at=`+req.CreatedAt.UTC().Format(time.RFC3339), got.body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	captured := make(chan capturedWebhook, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		captured <- capturedWebhook{r.Header.Get("Content-Type"), string(body)}
+	}))
+	defer hook.Close()
+
+	webhook, err := newWebhookNotifier(hook.URL, "", "application/json", time.Second)
+	require.NoError(t, err)
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, webhook.Notify(context.Background(), &Request{ID: "id", ServerID: "darkstar", IP: "10.0.0.1", Reason: "reboot", CreatedAt: createdAt}))
+
+	got := <-captured
+	assert.Equal(t, "application/json", got.contentType)
+	var data webhookData
+	require.NoError(t, json.Unmarshal([]byte(got.body), &data))
+	assert.Equal(t, webhookData{ServerID: "darkstar", RequestID: "id", IP: "10.0.0.1", Reason: "reboot", Priority: priorityNormal, CreatedAt: "2024-05-01T12:00:00Z"}, data)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	originalBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = originalBackoff }()
+
+	var calls atomic.Int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < webhookAttempts {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+
+	webhook, err := newWebhookNotifier(hook.URL, "", "application/json", time.Second)
+	require.NoError(t, err)
+	assert.NoError(t, webhook.Notify(context.Background(), &Request{ID: "id"}))
+	assert.Equal(t, int32(webhookAttempts), calls.Load(), "server errors are retried")
+
+	calls.Store(0)
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	webhook.url = rejecting.URL
+	assert.ErrorIs(t, webhook.Notify(context.Background(), &Request{ID: "id"}), errWebhookStatus)
+	assert.Equal(t, int32(1), calls.Load(), "client errors are not retried")
+}
+
+func TestWebhookInvalidTemplate(t *testing.T) {
+	_, err := newWebhookNotifier("http://localhost", "{{.ServerID", "text/plain", time.Second)
+	assert.ErrorContains(t, err, "invalid WEBHOOK_TEMPLATE")
+}